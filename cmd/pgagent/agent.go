@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/maps"
+
+	"pgmaven/internal/commands"
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/inventory"
+	"pgmaven/internal/utils"
+)
+
+// agent snapshots each of the databases once per cycle.
+type agent struct {
+	options          Options
+	optionsDB        dbutils.DBOptions
+	context          utils.Context
+	ds               *dbutils.DataSource
+	metrics          *metrics
+	schedule         schedule
+	targets          []dbutils.Target
+	connectionIntact bool
+	// stopping is set when a shutdown has been requested, databases not yet started in the cycle are skipped
+	stopping atomic.Bool
+}
+
+// loadTargets reads the list of databases, either the --inventory file, the --dbnames file or the single --dbname.
+func (a *agent) loadTargets() error {
+	targets, err := inventory.Targets(a.optionsDB)
+	if err != nil {
+		return err
+	}
+	a.targets = targets
+
+	return nil
+}
+
+// reload re-reads the list of databases (e.g. on SIGHUP), the existing list is retained if it cannot be read.
+func (a *agent) reload() {
+	if err := a.loadTargets(); err != nil {
+		log.Printf("ERROR: Failed to reload database list, error: %v\n", err)
+		return
+	}
+	log.Printf("Reloaded database list, %d databases\n", len(a.targets))
+}
+
+// cycle snapshots the tables for every database (pruning the history if requested) and returns the number of databases attempted,
+// the number that could not be reached, and the number that failed (including those not reached).  With --all-databases the
+// databases are discovered each cycle so new databases are picked up, a server that cannot be reached counts as a database.
+func (a *agent) cycle(tables []string, prune bool) (int, int, int) {
+	targets := a.targets
+	discoveryFailed := 0
+	if a.optionsDB.AllDatabases {
+		targets, discoveryFailed = dbutils.Discover(a.ds, a.targets, a.optionsDB.ExcludeDatabases, a.optionsDB.Inventory != "")
+	}
+
+	var args []string
+	if len(tables) != len(commands.StatsTables) {
+		args = []string{strings.Join(tables, ",")}
+	}
+
+	var unreachable, failures atomic.Int32
+	unreachable.Add(int32(discoveryFailed))
+	failures.Add(int32(discoveryFailed))
+	var outputMutex sync.Mutex
+	statuses := make(map[string]string)
+
+	dbutils.ForEachDatabase(a.ds, targets, a.options.Parallel, func(worker *dbutils.DataSource, dbName string) {
+		if a.stopping.Load() {
+			return
+		}
+
+		// Buffer the output so that it is not interleaved with other workers
+		var buffer bytes.Buffer
+		workerContext := a.context
+		workerContext.Out = &buffer
+
+		startMS := time.Now().UnixMilli()
+		if a.context.Verbose {
+			fmt.Fprintf(workerContext.Out, "Connection String: %s\n", worker.GetRedactedDataSourceString())
+		}
+
+		err := worker.Open()
+		if err != nil {
+			// Don't bother reporting the issue if we know the connection is broken, it will be reported the first time
+			if a.connectionIntact {
+				log.Printf("ERROR: Database: %s, %v\n", dbName, err)
+			}
+			unreachable.Add(1)
+			failures.Add(1)
+			if a.metrics != nil {
+				a.metrics.recordSnapshot(dbName, 0, nil, false)
+			}
+			outputMutex.Lock()
+			os.Stdout.Write(buffer.Bytes())
+			statuses[dbName] = "failed"
+			outputMutex.Unlock()
+			return
+		}
+
+		// Snapshot the Statistics tables
+		snapshot := commands.Snapshot{}
+		snapshot.Init(workerContext, worker)
+		snapshot.Execute(args...)
+		if a.metrics != nil {
+			a.metrics.recordSnapshot(dbName, time.Duration(time.Now().UnixMilli()-startMS)*time.Millisecond, snapshot.Rows, snapshot.SnapshotID != 0)
+			if snapshot.SnapshotID != 0 {
+				a.metrics.recordDatabase(worker, dbName, snapshot.SnapshotID, snapshot.Rows)
+			}
+		}
+		status := fmt.Sprintf("ok (%dms)", time.Now().UnixMilli()-startMS)
+		if snapshot.SnapshotID == 0 {
+			failures.Add(1)
+			status = "failed"
+		}
+
+		// Prune the history if a retention policy has been specified
+		if prune {
+			errors := worker.GetErrorCount()
+			prune := commands.MonitorPrune{}
+			prune.Init(workerContext, worker)
+			prune.Execute()
+			if worker.GetErrorCount() != errors && snapshot.SnapshotID != 0 {
+				failures.Add(1)
+				status = "prune failed"
+			}
+		}
+
+		outputMutex.Lock()
+		defer outputMutex.Unlock()
+		// If we are processing multiple databases then output the name of the DB we are working on
+		if a.optionsDB.DBNames != "" || a.optionsDB.Inventory != "" || a.optionsDB.AllDatabases {
+			fmt.Printf("Database: %s\n", dbName)
+		}
+		os.Stdout.Write(buffer.Bytes())
+		statuses[dbName] = status
+	})
+
+	if a.context.Verbose {
+		names := maps.Keys(statuses)
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("Database: %s, Snapshot: %s\n", name, statuses[name])
+		}
+	}
+
+	return len(targets) + discoveryFailed, int(unreachable.Load()), int(failures.Load())
+}
+
+// nextRun returns the next multiple of the frequency (e.g. the top of the hour) after now.
+func nextRun(now time.Time, frequency time.Duration) time.Time {
+	return now.Truncate(frequency).Add(frequency)
+}
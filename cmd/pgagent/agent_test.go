@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextRun(t *testing.T) {
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		now       time.Time
+		frequency time.Duration
+		expected  time.Time
+	}{
+		{base, time.Hour, base.Add(time.Hour)},
+		{base.Add(time.Second), time.Hour, base.Add(time.Hour)},
+		{base.Add(59 * time.Minute), time.Hour, base.Add(time.Hour)},
+		{base.Add(7 * time.Minute), 15 * time.Second, base.Add(7*time.Minute + 15*time.Second)},
+		{base.Add(7*time.Minute + 20*time.Second), 15 * time.Second, base.Add(7*time.Minute + 30*time.Second)},
+		{base.Add(5 * time.Hour), 6 * time.Hour, base.Add(8 * time.Hour)},
+	}
+
+	for _, test := range tests {
+		if next := nextRun(test.now, test.frequency); !next.Equal(test.expected) {
+			t.Errorf("nextRun(%v, %v): expected %v, got %v", test.now, test.frequency, test.expected, next)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// acquireLock creates the file exclusively, a lock file left by a process that did not exit cleanly must be removed manually.
+func acquireLock(name string) (func(), error) {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil, fmt.Errorf("'%s' is locked by another pgagent", name)
+		}
+		return nil, err
+	}
+	fmt.Fprintf(file, "%d\n", os.Getpid())
+
+	return func() { file.Close(); os.Remove(name) }, nil
+}
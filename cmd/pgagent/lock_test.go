@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAcquireLock(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "pgagent.lock")
+
+	release, err := acquireLock(name)
+	if err != nil {
+		t.Fatalf("acquireLock failed: %v", err)
+	}
+	if _, err := acquireLock(name); err == nil {
+		t.Fatalf("expected error acquiring a held lock")
+	}
+
+	release()
+	release, err = acquireLock(name)
+	if err != nil {
+		t.Fatalf("acquireLock failed after release: %v", err)
+	}
+	release()
+
+	if _, err := acquireLock(filepath.Join(t.TempDir(), "missing", "pgagent.lock")); err == nil {
+		t.Fatalf("expected error for a lock file in a missing directory")
+	}
+}
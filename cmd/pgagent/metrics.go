@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/history"
+	"pgmaven/internal/utils"
+)
+
+const (
+	// Limit the cardinality of the database metrics
+	metricsMaxTables  = 50
+	metricsTopQueries = 10
+)
+
+// metric is a single sample, the labels are rendered in the order provided.
+type metric struct {
+	labels []string
+	value  float64
+}
+
+// family is a set of samples with the same name, type and help text.
+type family struct {
+	help       string
+	metricType string
+	samples    map[string]metric
+}
+
+// metrics records the agent activity and a curated set of metrics from the latest snapshot of each database, and exposes them
+// in the Prometheus text format.
+type metrics struct {
+	mutex    sync.Mutex
+	families map[string]*family
+	intact   atomic.Bool
+}
+
+func newMetrics() *metrics {
+	ret := &metrics{families: make(map[string]*family)}
+	ret.intact.Store(true)
+	ret.set("pgagent_info", "gauge", "Version of pgagent", 1, "version", utils.GetVersionString())
+
+	return ret
+}
+
+// listen starts the HTTP server, errors binding the address are reported immediately.
+func (m *metrics) listen(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", m.serveMetrics)
+	mux.HandleFunc("/healthz", m.serveHealth)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Printf("ERROR: Metrics server failed, error: %v\n", err)
+		}
+	}()
+
+	return nil
+}
+
+func (m *metrics) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if !m.intact.Load() {
+		http.Error(w, "connection lost", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (m *metrics) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.write(w)
+}
+
+// setConnectionIntact records whether any database could be reached in the last cycle.
+func (m *metrics) setConnectionIntact(intact bool) {
+	m.intact.Store(intact)
+	value := 0.0
+	if intact {
+		value = 1
+	}
+	m.set("pgagent_connection_intact", "gauge", "1 if any database could be reached in the last cycle", value)
+}
+
+// recordSnapshot records the outcome of a snapshot of the database.
+func (m *metrics) recordSnapshot(dbName string, duration time.Duration, rows map[string]int64, ok bool) {
+	if !ok {
+		m.add("pgagent_snapshot_failures_total", "counter", "Snapshots that failed", 1, "database", dbName)
+		return
+	}
+
+	m.add("pgagent_snapshots_total", "counter", "Snapshots taken", 1, "database", dbName)
+	m.set("pgagent_snapshot_duration_seconds", "gauge", "Duration of the last snapshot", duration.Seconds(), "database", dbName)
+	m.set("pgagent_snapshot_last_success_timestamp_seconds", "gauge", "Time of the last successful snapshot", float64(time.Now().Unix()), "database", dbName)
+	for table, count := range rows {
+		m.set("pgagent_snapshot_rows", "gauge", "Rows captured per Statistics table in the last snapshot", float64(count), "database", dbName, "table", table)
+	}
+}
+
+// recordFailure records a failure to collect the database metrics, the metrics affected are not updated.
+func (m *metrics) recordFailure(dbName string, what string, err error) {
+	log.Printf("ERROR: Database: %s, Metrics failed to collect %s, error: %v\n", dbName, what, err)
+	m.add("pgagent_metrics_failures_total", "counter", "Failures to collect the database metrics", 1, "database", dbName)
+}
+
+// recordDatabase records the curated database metrics from the latest snapshot (and the table sizes from the database), only the
+// metrics for the tables captured by the snapshot are updated.
+func (m *metrics) recordDatabase(ds *dbutils.DataSource, dbName string, snapshotID int64, captured map[string]int64) {
+	source := history.NewSource(ds)
+
+	if _, ok := captured["pg_stat_user_tables"]; ok {
+		m.recordTables(ds, source, dbName, snapshotID)
+	}
+	if _, ok := captured["pg_stat_statements"]; ok {
+		m.recordQueries(source, dbName, snapshotID)
+	}
+}
+
+func (m *metrics) recordTables(ds *dbutils.DataSource, source history.Source, dbName string, snapshotID int64) {
+	sizes := make(map[string]float64)
+	sizeQuery := `SELECT schemaname || '.' || relname, pg_total_relation_size(relid) FROM pg_stat_user_tables`
+	err := ds.ExecuteQueryRows(sizeQuery, nil, func(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
+		sizes[(*values[0].(*interface{})).(string)] = float64((*values[1].(*interface{})).(int64))
+	}, nil)
+	if err != nil {
+		m.recordFailure(dbName, "table sizes", err)
+	}
+
+	tables, err := history.Rows(source, "pg_stat_user_tables", snapshotID, []string{"schemaname", "relname", "n_live_tup", "n_dead_tup", "seq_scan", "idx_scan"})
+	if err != nil {
+		m.recordFailure(dbName, "table statistics", err)
+	} else {
+		sort.Slice(tables, func(i, j int) bool { return tables[i].Int("n_live_tup") > tables[j].Int("n_live_tup") })
+		m.reset(dbName, "pgmaven_table_size_bytes", "pgmaven_table_live_tuples", "pgmaven_table_dead_tuples", "pgmaven_table_seq_scans_total", "pgmaven_table_index_scans_total")
+		for i, table := range tables {
+			if i == metricsMaxTables {
+				break
+			}
+			labels := []string{"database", dbName, "schema", table.String("schemaname"), "table", table.String("relname")}
+			if size, ok := sizes[table.String("schemaname")+"."+table.String("relname")]; ok {
+				m.set("pgmaven_table_size_bytes", "gauge", "Total size of the table (including indexes and toast)", size, labels...)
+			}
+			m.set("pgmaven_table_live_tuples", "gauge", "Estimated live rows in the table", table.Float("n_live_tup"), labels...)
+			m.set("pgmaven_table_dead_tuples", "gauge", "Estimated dead rows in the table", table.Float("n_dead_tup"), labels...)
+			m.set("pgmaven_table_seq_scans_total", "counter", "Sequential scans of the table", table.Float("seq_scan"), labels...)
+			m.set("pgmaven_table_index_scans_total", "counter", "Index scans of the table", table.Float("idx_scan"), labels...)
+		}
+	}
+}
+
+func (m *metrics) recordQueries(source history.Source, dbName string, snapshotID int64) {
+	queries, err := history.Rows(source, "pg_stat_statements", snapshotID, []string{"queryid", "calls", "total_exec_time"})
+	if err != nil {
+		m.recordFailure(dbName, "query statistics", err)
+	} else {
+		sort.Slice(queries, func(i, j int) bool { return queries[i].Float("total_exec_time") > queries[j].Float("total_exec_time") })
+		m.reset(dbName, "pgmaven_query_exec_seconds_total", "pgmaven_query_calls_total")
+		for i, query := range queries {
+			if i == metricsTopQueries {
+				break
+			}
+			labels := []string{"database", dbName, "queryid", query.String("queryid")}
+			m.set("pgmaven_query_exec_seconds_total", "counter", "Execution time of the top queries (by execution time)", query.Float("total_exec_time")/1000, labels...)
+			m.set("pgmaven_query_calls_total", "counter", "Calls of the top queries (by execution time)", query.Float("calls"), labels...)
+		}
+	}
+}
+
+func (m *metrics) sample(name string, metricType string, help string, labels []string) (*family, string) {
+	f, ok := m.families[name]
+	if !ok {
+		f = &family{help: help, metricType: metricType, samples: make(map[string]metric)}
+		m.families[name] = f
+	}
+
+	return f, strings.Join(labels, "\x00")
+}
+
+func (m *metrics) set(name string, metricType string, help string, value float64, labels ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	f, key := m.sample(name, metricType, help, labels)
+	f.samples[key] = metric{labels: labels, value: value}
+}
+
+func (m *metrics) add(name string, metricType string, help string, value float64, labels ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	f, key := m.sample(name, metricType, help, labels)
+	f.samples[key] = metric{labels: labels, value: f.samples[key].value + value}
+}
+
+// reset removes the samples for the database, so objects that no longer exist (or are no longer in the top N) are not reported.
+func (m *metrics) reset(dbName string, names ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for _, name := range names {
+		if f, ok := m.families[name]; ok {
+			for key, sample := range f.samples {
+				if len(sample.labels) >= 2 && sample.labels[1] == dbName {
+					delete(f.samples, key)
+				}
+			}
+		}
+	}
+}
+
+// write the metrics in the Prometheus text exposition format.
+func (m *metrics) write(w io.Writer) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	names := make([]string, 0, len(m.families))
+	for name := range m.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := m.families[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.metricType)
+		keys := make([]string, 0, len(f.samples))
+		for key := range f.samples {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			sample := f.samples[key]
+			fmt.Fprintf(w, "%s%s %v\n", name, formatLabels(sample.labels), sample.value)
+		}
+	}
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, labels[i], escape.Replace(labels[i+1])))
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
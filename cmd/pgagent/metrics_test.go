@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"pgmaven/internal/utils"
+)
+
+func TestFormatLabels(t *testing.T) {
+	tests := []struct {
+		labels   []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{"database", "sales"}, `{database="sales"}`},
+		{[]string{"database", "sales", "table", `a"b\c` + "\nd"}, `{database="sales",table="a\"b\\c\nd"}`},
+	}
+
+	for _, test := range tests {
+		if got := formatLabels(test.labels); got != test.expected {
+			t.Errorf("formatLabels(%q): expected %s, got %s", test.labels, test.expected, got)
+		}
+	}
+}
+
+func TestMetricsWrite(t *testing.T) {
+	m := newMetrics()
+	m.setConnectionIntact(false)
+	m.add("pgagent_snapshots_total", "counter", "Snapshots taken", 1, "database", "sales")
+	m.add("pgagent_snapshots_total", "counter", "Snapshots taken", 1, "database", "sales")
+	m.add("pgagent_snapshots_total", "counter", "Snapshots taken", 1, "database", "billing")
+	m.set("pgmaven_table_live_tuples", "gauge", "Estimated live rows in the table", 2500000, "database", "sales", "schema", "public", "table", `odd"name`)
+	m.set("pgmaven_table_live_tuples", "gauge", "Estimated live rows in the table", 10, "database", "billing", "schema", "public", "table", "invoices")
+
+	expected := `# HELP pgagent_connection_intact 1 if any database could be reached in the last cycle
+# TYPE pgagent_connection_intact gauge
+pgagent_connection_intact 0
+# HELP pgagent_info Version of pgagent
+# TYPE pgagent_info gauge
+pgagent_info{version="` + utils.GetVersionString() + `"} 1
+# HELP pgagent_snapshots_total Snapshots taken
+# TYPE pgagent_snapshots_total counter
+pgagent_snapshots_total{database="billing"} 1
+pgagent_snapshots_total{database="sales"} 2
+# HELP pgmaven_table_live_tuples Estimated live rows in the table
+# TYPE pgmaven_table_live_tuples gauge
+pgmaven_table_live_tuples{database="billing",schema="public",table="invoices"} 10
+pgmaven_table_live_tuples{database="sales",schema="public",table="odd\"name"} 2.5e+06
+`
+	var b strings.Builder
+	m.write(&b)
+	if b.String() != expected {
+		t.Fatalf("unexpected metrics:\n%s\nexpected:\n%s", b.String(), expected)
+	}
+
+	// Only the samples for the database are removed
+	m.reset("sales", "pgmaven_table_live_tuples", "pgmaven_unknown")
+	b.Reset()
+	m.write(&b)
+	if strings.Contains(b.String(), `database="sales",schema`) || !strings.Contains(b.String(), `table="invoices"} 10`) {
+		t.Fatalf("unexpected metrics after reset:\n%s", b.String())
+	}
+	if !strings.Contains(b.String(), `pgagent_snapshots_total{database="sales"} 2`) {
+		t.Fatalf("reset should only affect the named metrics:\n%s", b.String())
+	}
+}
+
+func TestMetricsRecordFailure(t *testing.T) {
+	var logged strings.Builder
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	m := newMetrics()
+	m.recordFailure("sales", "table sizes", errors.New("permission denied"))
+	m.recordFailure("sales", "table statistics", errors.New("permission denied"))
+
+	var b strings.Builder
+	m.write(&b)
+	if !strings.Contains(b.String(), `pgagent_metrics_failures_total{database="sales"} 2`) {
+		t.Fatalf("expected two metrics failures:\n%s", b.String())
+	}
+	if !strings.Contains(logged.String(), "Metrics failed to collect table sizes") {
+		t.Fatalf("expected the failure to be logged: %s", logged.String())
+	}
+}
@@ -3,6 +3,14 @@ package main
 import "time"
 
 type Options struct {
-	Frequency time.Duration
-	Version   bool
+	DownsampleAfter string
+	Frequency       time.Duration
+	Jitter          time.Duration
+	Listen          string
+	LockFile        string
+	Once            bool
+	Parallel        int
+	Retain          string
+	Schedule        []string
+	Version         bool
 }
@@ -16,17 +16,19 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 
 	flag "github.com/spf13/pflag"
 
-	"pgmaven/internal/commands"
+	"pgmaven/internal/config"
 	"pgmaven/internal/dbutils"
 	"pgmaven/internal/utils"
 
@@ -35,122 +37,199 @@ import (
 )
 
 const (
-	password     = "<SETME>"
-	username     = "tsegall"
-	DurationMin  = 60 * 1000 * 1000 * 1000
-	DurationHour = 60 * DurationMin
-	FrequencyMin = 15 * DurationMin
-	FrequencyMax = 24 * DurationHour
+	password       = "<SETME>"
+	username       = "tsegall"
+	DurationSecond = 1000 * 1000 * 1000
+	DurationMin    = 60 * DurationSecond
+	DurationHour   = 60 * DurationMin
+	FrequencyMin   = 5 * DurationSecond
+	FrequencyMax   = 7 * 24 * DurationHour
+	// PruneFrequency is the minimum interval between pruning the history (if a retention policy has been specified)
+	PruneFrequency = DurationHour
+)
+
+// Exit codes - note log.Fatal (used for usage errors) exits with 1
+const (
+	ExitOK    = 0
+	ExitError = 3
 )
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	var (
 		optionsDB dbutils.DBOptions
 		options   Options
 		context   utils.Context
 	)
 
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	buffer, err := os.ReadFile(filepath.Join(homeDir, ".pgpass"))
-	if err == nil {
-		fmt.Printf("%s\n", buffer)
-	}
-
 	optionsDB.Init()
+	config.Init()
 
 	flag.BoolVar(&context.Verbose, "verbose", false, "enable verbose logging")
 
-	flag.DurationVar(&options.Frequency, "frequency", DurationHour, "Snapshot frequency")
+	flag.DurationVar(&options.Frequency, "frequency", DurationHour, "Snapshot frequency (for the tables not in --schedule)")
+	flag.StringSliceVar(&options.Schedule, "schedule", nil, "frequency per Statistics table (e.g. pg_stat_activity=15s,pg_stat_user_tables=6h, off to not capture), default --frequency")
+	flag.StringVar(&options.Listen, "listen", "", "address to serve Prometheus metrics (/metrics) and health (/healthz) on (e.g. :9187)")
+	flag.DurationVar(&options.Jitter, "jitter", 0, "random delay added to each snapshot time (snapshots are aligned to multiples of the frequency)")
+	flag.StringVar(&options.LockFile, "lock-file", "", "lock file used to prevent overlapping runs (default with --once: '<tmpdir>/pgagent.lock')")
+	flag.BoolVar(&options.Once, "once", false, "take a single snapshot of each database and exit (e.g. for cron or systemd timers)")
+	flag.IntVar(&options.Parallel, "parallel", 1, "number of databases to snapshot in parallel")
+	flag.StringVar(&options.Retain, "retain", "", "delete snapshots older than this (e.g. 30d)")
+	flag.StringVar(&options.DownsampleAfter, "downsample-after", "", "thin snapshots older than this to one per interval (e.g. 7d:daily)")
 	flag.BoolVar(&options.Version, "version", false, "print version number")
 
 	flag.Parse()
 
-	if options.Frequency < FrequencyMin || options.Frequency > FrequencyMax {
-		log.Fatalf("ERROR: Frequency should be between %d and %d\n", FrequencyMin, FrequencyMax)
+	if err := config.Apply(dbutils.Environment, context.Verbose); err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
+
+	if err := optionsDB.Resolve(flag.CommandLine.Changed); err != nil {
+		log.Fatalf("ERROR: %v\n", err)
 	}
 
 	if options.Version {
 		fmt.Println(utils.GetVersionString())
-		return
+		return ExitOK
 	}
 
-	ds := dbutils.NewDataSource(optionsDB)
+	tableSchedule, err := parseSchedule(options.Schedule, options.Frequency)
+	if err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
+	if err := tableSchedule.checkJitter(options.Jitter); err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
 
-	var dbnames []string
-	if optionsDB.DBNames != "" {
-		if optionsDB.DBName != "" {
-			log.Fatalf("ERROR: Cannot specify both dbname and dbnames options\n")
-		}
-		content, err := os.ReadFile(optionsDB.DBNames)
-		if err != nil {
-			log.Fatalf("ERROR: Failed to open file, error %v\n", err)
-		}
-		dbnames = strings.Split(string(content), "\n")
-	} else {
-		dbnames = []string{optionsDB.DBName}
+	if err := context.SetRetention(options.Retain, options.DownsampleAfter); err != nil {
+		log.Fatalf("ERROR: %v\n", err)
 	}
 
-	connectionIntact := true
-	for {
-		dbs := len(dbnames)
-		failures := 0
+	if optionsDB.DBNames != "" && optionsDB.DBName != "" {
+		log.Fatalf("ERROR: Cannot specify both dbname and dbnames options\n")
+	}
 
-		for _, dbName := range dbnames {
-			if strings.Trim(dbName, " ") == "" {
-				continue
-			}
+	a := &agent{options: options, optionsDB: optionsDB, context: context, schedule: tableSchedule, connectionIntact: true}
+	if err := a.loadTargets(); err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
 
-			ds.SetDBName(dbName)
-			psqlInfo := ds.GetDataSourceString()
+	a.ds = dbutils.NewDataSource(optionsDB)
+	defer a.ds.CloseTunnels()
 
-			if context.Verbose {
-				fmt.Printf("Connection String: %s\n", psqlInfo)
-			}
+	if options.Listen != "" {
+		a.metrics = newMetrics()
+		if err := a.metrics.listen(options.Listen); err != nil {
+			log.Fatalf("ERROR: Failed to listen on '%s', error: %v\n", options.Listen, err)
+		}
+	}
 
-			db, err := sql.Open("postgres", psqlInfo)
-			if err != nil {
-				log.Printf("ERROR: Database: %s, open failed with error: %v\n", dbName, err)
-				continue
-			}
-			ds.SetDatabase(db)
+	if options.Once && options.LockFile == "" {
+		options.LockFile = filepath.Join(os.TempDir(), "pgagent.lock")
+	}
+	// Acquired once the remaining startup errors (which exit via log.Fatal) are past, so the lock is always released
+	if options.LockFile != "" {
+		release, err := acquireLock(options.LockFile)
+		if err != nil {
+			log.Fatalf("ERROR: %v\n", err)
+		}
+		defer release()
+	}
 
-			err = db.Ping()
-			if err != nil {
-				// Don't bother reporting the issue if we know the connection is broken, it will be reported the first time
-				if connectionIntact {
-					log.Printf("ERROR: Database: %s, failed to ping database, error: %v\n", dbName, err)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// Capture all the scheduled tables at startup
+	tables := tableSchedule.tables()
+	retention := context.Retain != 0 || context.DownsampleInterval != 0
+	var lastPrune time.Time
+	for {
+		// Run the cycle in the background so that signals are handled while the snapshots are in progress
+		prune := retention && time.Since(lastPrune) >= PruneFrequency
+		done := make(chan [3]int, 1)
+		go func() {
+			attempted, unreachable, failures := a.cycle(tables, prune)
+			done <- [3]int{attempted, unreachable, failures}
+		}()
+		if prune {
+			lastPrune = time.Now()
+		}
+
+		var result [3]int
+		reload := false
+		for inFlight := true; inFlight; {
+			select {
+			case result = <-done:
+				inFlight = false
+			case sig := <-signals:
+				if sig == syscall.SIGHUP {
+					// The list of databases is in use, so reload once the cycle is complete
+					reload = true
+				} else if a.stopping.Load() {
+					log.Printf("Received %v, aborting the snapshots in progress (they will be rolled back)\n", sig)
+					return ExitError
+				} else {
+					log.Printf("Received %v, completing the snapshots in progress (signal again to abort)\n", sig)
+					a.stopping.Store(true)
 				}
-				failures++
-				continue
 			}
+		}
+		attempted, unreachable, failures := result[0], result[1], result[2]
 
-			// If we are processing multiple databases then output the name of the DB we are working on
-			if optionsDB.DBNames != "" {
-				fmt.Printf("Database: %s\n", dbName)
+		if a.stopping.Load() {
+			return ExitOK
+		}
+		if options.Once {
+			if failures != 0 {
+				return ExitError
 			}
-
-			// Snapshot the Statistics tables
-			snapshot := commands.Snapshot{}
-			snapshot.Init(context, ds)
-			snapshot.Execute()
-
-			db.Close()
+			return ExitOK
 		}
 
 		// If we failed to connect to any DB then mark the connection as broken, and sleep for a shorter period before retrying
-		if failures == dbs {
-			time.Sleep(5 * DurationMin)
-			connectionIntact = false
+		if unreachable != 0 && unreachable == attempted {
+			a.connectionIntact = false
+		} else if !a.connectionIntact {
+			log.Printf("ERROR: Database: %s, Connection re-established\n", a.targets[0].Name)
+			a.connectionIntact = true
+		}
+		if a.metrics != nil {
+			a.metrics.setConnectionIntact(a.connectionIntact)
+		}
+
+		var next time.Time
+		if a.connectionIntact {
+			next, tables = tableSchedule.next(time.Now())
+			if options.Jitter > 0 {
+				next = next.Add(time.Duration(rand.Int63n(int64(options.Jitter))))
+			}
 		} else {
-			if !connectionIntact {
-				log.Printf("ERROR: Database: %s, Connection re-established\n", dbnames[0])
-				connectionIntact = true
+			next, tables = time.Now().Add(5*DurationMin), tableSchedule.tables()
+		}
+		if context.Verbose {
+			fmt.Printf("Next snapshot: %s (%s)\n", next.Format(time.RFC3339), strings.Join(tables, ", "))
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		for sleeping := true; sleeping; {
+			if reload {
+				a.reload()
+				reload = false
+			}
+			select {
+			case <-timer.C:
+				sleeping = false
+			case sig := <-signals:
+				if sig == syscall.SIGHUP {
+					reload = true
+					continue
+				}
+				log.Printf("Received %v, exiting\n", sig)
+				return ExitOK
 			}
-			time.Sleep(options.Frequency)
 		}
 	}
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"pgmaven/internal/commands"
+)
+
+// schedule is the frequency with which each of the Statistics tables is captured.
+type schedule map[string]time.Duration
+
+// parseSchedule returns the schedule from the entries (<table>=<frequency> or <table>=off), tables not specified are captured
+// at the default frequency.
+func parseSchedule(entries []string, frequency time.Duration) (schedule, error) {
+	ret := make(schedule)
+	for _, table := range commands.StatsTables {
+		ret[table] = frequency
+	}
+
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		name, value, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("schedule '%s' should be <table>=<frequency>", entry)
+		}
+		parsed, err := commands.ParseStatsTables(name)
+		if err != nil {
+			return nil, err
+		}
+		if len(parsed) != 1 {
+			return nil, fmt.Errorf("schedule '%s' should be <table>=<frequency>", entry)
+		}
+		table := parsed[0]
+		if seen[table] {
+			return nil, fmt.Errorf("schedule for %s specified more than once", table)
+		}
+		seen[table] = true
+		value = strings.TrimSpace(value)
+		if value == "off" {
+			delete(ret, table)
+			continue
+		}
+		tableFrequency, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("schedule '%s', %v", entry, err)
+		}
+		ret[table] = tableFrequency
+	}
+
+	for table, tableFrequency := range ret {
+		if tableFrequency < FrequencyMin || tableFrequency > FrequencyMax {
+			return nil, fmt.Errorf("frequency for %s should be between %v and %v", table, time.Duration(FrequencyMin), time.Duration(FrequencyMax))
+		}
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("schedule does not capture any tables")
+	}
+
+	return ret, nil
+}
+
+// tables returns the tables scheduled (in the order of the Statistics tables).
+func (s schedule) tables() []string {
+	ret := make([]string, 0, len(s))
+	for _, table := range commands.StatsTables {
+		if _, ok := s[table]; ok {
+			ret = append(ret, table)
+		}
+	}
+
+	return ret
+}
+
+// shortest returns the highest frequency in the schedule.
+func (s schedule) shortest() time.Duration {
+	var ret time.Duration
+	for _, frequency := range s {
+		if ret == 0 || frequency < ret {
+			ret = frequency
+		}
+	}
+
+	return ret
+}
+
+// checkJitter returns an error unless the jitter is less than the shortest frequency (so each table is captured once per interval).
+func (s schedule) checkJitter(jitter time.Duration) error {
+	if jitter < 0 || jitter >= s.shortest() {
+		return fmt.Errorf("jitter should be less than the frequency (%v)", s.shortest())
+	}
+
+	return nil
+}
+
+// next returns the time of the next snapshot after now and the tables due at that time.  Each table is captured at the
+// multiples of its frequency (e.g. on the hour), so tables with related frequencies are captured in the same snapshot.
+func (s schedule) next(now time.Time) (time.Time, []string) {
+	var ret time.Time
+	for _, frequency := range s {
+		if due := nextRun(now, frequency); ret.IsZero() || due.Before(ret) {
+			ret = due
+		}
+	}
+
+	due := make([]string, 0)
+	for _, table := range s.tables() {
+		if nextRun(now, s[table]).Equal(ret) {
+			due = append(due, table)
+		}
+	}
+
+	return ret, due
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"pgmaven/internal/commands"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		entries  []string
+		expected map[string]time.Duration
+		err      bool
+	}{
+		{nil, map[string]time.Duration{}, false},
+		{[]string{"pg_stat_activity=15s"}, map[string]time.Duration{"pg_stat_activity": 15 * time.Second}, false},
+		{[]string{" pg_stat_statements = 6h", "pg_stat_activity=off"}, map[string]time.Duration{"pg_stat_statements": 6 * time.Hour, "pg_stat_activity": 0}, false},
+		{[]string{"pg_stat_activity"}, nil, true},
+		{[]string{"=15s"}, nil, true},
+		{[]string{"pg_stat_activity,pg_stat_statements=15s"}, nil, true},
+		{[]string{"pg_stat_unknown=15s"}, nil, true},
+		{[]string{"pg_stat_activity=fast"}, nil, true},
+		{[]string{"pg_stat_activity=1s"}, nil, true},
+		{[]string{"pg_stat_activity=200h"}, nil, true},
+		{[]string{"pg_stat_activity=15s", "pg_stat_activity=1m"}, nil, true},
+		{[]string{"pg_stat_activity=15s", "pg_stat_activity=off"}, nil, true},
+	}
+
+	for _, test := range tests {
+		s, err := parseSchedule(test.entries, time.Hour)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: expected error", test.entries)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error: %v", test.entries, err)
+			continue
+		}
+		for _, table := range commands.StatsTables {
+			expected, ok := test.expected[table]
+			if !ok {
+				expected = time.Hour
+			}
+			if expected == 0 {
+				if _, ok := s[table]; ok {
+					t.Errorf("%v: %s should not be scheduled", test.entries, table)
+				}
+			} else if s[table] != expected {
+				t.Errorf("%v: %s expected %v, got %v", test.entries, table, expected, s[table])
+			}
+		}
+	}
+
+	off := make([]string, 0)
+	for _, table := range commands.StatsTables {
+		off = append(off, table+"=off")
+	}
+	if _, err := parseSchedule(off, time.Hour); err == nil {
+		t.Errorf("expected error for a schedule that captures no tables")
+	}
+	if _, err := parseSchedule(nil, time.Second); err == nil {
+		t.Errorf("expected error for a default frequency below the minimum")
+	}
+}
+
+func TestScheduleJitter(t *testing.T) {
+	tests := []struct {
+		entries  []string
+		shortest time.Duration
+	}{
+		{nil, time.Hour},
+		{[]string{"pg_stat_activity=15s"}, 15 * time.Second},
+		{[]string{"pg_stat_activity=off", "pg_stat_statements=6h"}, time.Hour},
+	}
+
+	for _, test := range tests {
+		s, err := parseSchedule(test.entries, time.Hour)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", test.entries, err)
+		}
+		if s.shortest() != test.shortest {
+			t.Errorf("%v: expected shortest %v, got %v", test.entries, test.shortest, s.shortest())
+		}
+		if err := s.checkJitter(test.shortest - time.Second); err != nil {
+			t.Errorf("%v: unexpected error for jitter below the shortest frequency: %v", test.entries, err)
+		}
+		if err := s.checkJitter(test.shortest); err == nil {
+			t.Errorf("%v: expected error for jitter equal to the shortest frequency", test.entries)
+		}
+		if err := s.checkJitter(-time.Second); err == nil {
+			t.Errorf("%v: expected error for negative jitter", test.entries)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	s, err := parseSchedule([]string{"pg_stat_activity=15m", "pg_stat_statements=30m", "pg_statio_user_indexes=off"}, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	all := []string{"pg_stat_user_indexes", "pg_stat_user_tables", "pg_statio_user_tables", "pg_stat_statements", "pg_stat_activity"}
+	if !slices.Equal(s.tables(), all) {
+		t.Fatalf("unexpected tables %v", s.tables())
+	}
+
+	base := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		now    time.Time
+		next   time.Time
+		tables []string
+	}{
+		{base, base.Add(15 * time.Minute), []string{"pg_stat_activity"}},
+		{base.Add(20 * time.Minute), base.Add(30 * time.Minute), []string{"pg_stat_statements", "pg_stat_activity"}},
+		{base.Add(50 * time.Minute), base.Add(time.Hour), all},
+	}
+
+	for _, test := range tests {
+		next, tables := s.next(test.now)
+		if !next.Equal(test.next) || !slices.Equal(tables, test.tables) {
+			t.Errorf("next(%v): expected %v %v, got %v %v", test.now, test.next, test.tables, next, tables)
+		}
+	}
+}
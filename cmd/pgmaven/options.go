@@ -1,7 +1,20 @@
 package main
 
 type Options struct {
-	Command string
-	Detect  string
-	Version bool
+	AggregateSchemas bool
+	Baseline         string
+	Command          string
+	Detect           string
+	DownsampleAfter  string
+	FailOn           string
+	MinHistory       string
+	MinSeverity      string
+	Output           string
+	OutputFile       string
+	Parallel         int
+	Remediation      string
+	Retain           string
+	Version          bool
+	WorstTenants     int
+	WriteBaseline    string
 }
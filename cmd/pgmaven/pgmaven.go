@@ -16,17 +16,19 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
 	"os"
 	"strings"
+	"time"
 
 	flag "github.com/spf13/pflag"
 
-	"pgmaven/internal/commands"
+	"pgmaven/internal/config"
 	"pgmaven/internal/dbutils"
+	"pgmaven/internal/inventory"
 	"pgmaven/internal/issues"
+	"pgmaven/internal/report"
 	"pgmaven/internal/utils"
 
 	// _ "github.com/jackc/pgx/v5/stdlib"
@@ -36,9 +38,23 @@ import (
 const (
 	username     = "tsegall"
 	DurationWeek = 7 * 24 * 60 * 60 * 1000 * 1000 * 1000
+
+	DefaultLockTimeout      = 5 * time.Second
+	DefaultStatementTimeout = 30 * time.Minute
+)
+
+// Exit codes - note log.Fatal (used for usage errors) exits with 1
+const (
+	ExitOK          = 0
+	ExitIssuesFound = 2
+	ExitError       = 3
 )
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	var (
 		optionsDB dbutils.DBOptions
 		options   Options
@@ -46,102 +62,165 @@ func main() {
 	)
 
 	optionsDB.Init()
+	config.Init()
+	issues.InitThresholds()
 
+	flag.StringSliceVar(&context.ApplyAllow, "apply-allow", nil, "issue types to apply without confirmation (--command Apply)")
 	flag.BoolVar(&context.DryRun, "dryrun", false, "report database commands - do not execute")
 	flag.DurationVar(&context.Duration, "duration", DurationWeek, "Duration of analysis - default week")
 	flag.DurationVar(&context.DurationOffset, "durationOffset", 0, "Duration offset (from now) - 0")
+	flag.DurationVar(&context.LockTimeout, "lock-timeout", DefaultLockTimeout, "lock_timeout used when remediating issues")
+	flag.DurationVar(&context.StatementTimeout, "statement-timeout", DefaultStatementTimeout, "statement_timeout used when remediating issues")
 	flag.BoolVar(&context.Verbose, "verbose", false, "enable verbose logging")
 
 	flag.BoolVar(&options.Version, "version", false, "print version number")
 	flag.StringVar(&options.Command, "command", "", "execute the command specified (--command Help for options)")
 	flag.StringVar(&options.Detect, "detect", "", "execute the issue detection specified (--detect Help for options)")
+	flag.StringVar(&options.Output, "output", report.FormatText, "output format for detected issues (text or json)")
+	flag.StringVar(&options.OutputFile, "output-file", "", "file to write detected issues to (default: stdout)")
+	flag.StringVar(&options.MinHistory, "min-history", "", "minimum period of snapshot history required to report unused indexes at full severity (default: half the --duration)")
+	flag.StringVar(&options.MinSeverity, "min-severity", utils.Low.String(), "only report issues at or above this severity (HIGH, MEDIUM, LOW)")
+	flag.StringVar(&options.FailOn, "fail-on", "", "exit with a non-zero status if issues at or above this severity are detected (HIGH, MEDIUM, LOW)")
+	flag.StringVar(&options.Baseline, "baseline", "", "file of known issues to suppress (only new or regressed issues are reported)")
+	flag.StringVar(&options.WriteBaseline, "write-baseline", "", "file to write a baseline of all detected issues to")
+	flag.IntVar(&options.Parallel, "parallel", 1, "number of databases to process in parallel")
+	flag.BoolVar(&options.AggregateSchemas, "aggregate-schemas", false, "group issues for the same object across schemas (e.g. a schema per tenant), remediation is templated per schema")
+	flag.IntVar(&options.WorstTenants, "worst-tenants", report.DefaultWorstTenants, "number of (most wasteful) schemas listed for each issue with --aggregate-schemas")
+	flag.StringVar(&options.Remediation, "remediation-script", "", "file to write a SQL script (and rollback script) to remediate the detected issues")
+	flag.StringVar(&options.Retain, "retain", "", "delete snapshots older than this (e.g. 30d) (--command MonitorPrune)")
+	flag.StringVar(&options.DownsampleAfter, "downsample-after", "", "thin snapshots older than this to one per interval (e.g. 7d:daily) (--command MonitorPrune)")
 
 	flag.Parse()
 
+	if err := config.Apply(dbutils.Environment, context.Verbose); err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
+
+	if err := optionsDB.Resolve(flag.CommandLine.Changed); err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
+
+	if err := issues.ApplyThresholds(flag.CommandLine.Changed); err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
+
 	if options.Version {
 		fmt.Println(utils.GetVersionString())
-		return
+		return ExitOK
 	}
 
-	ds := dbutils.NewDataSource(optionsDB)
+	minSeverity, err := utils.ParseSeverity(options.MinSeverity)
+	if err != nil {
+		log.Fatalf("ERROR: --min-severity %v\n", err)
+	}
 
-	var dbNames []string
-	if optionsDB.DBNames != "" {
-		if optionsDB.DBName != "" {
-			log.Fatalf("ERROR: Cannot specify both dbname and dbnames options\n")
-		}
-		content, err := os.ReadFile(optionsDB.DBNames)
-		if err != nil {
-			log.Fatalf("ERROR: Failed to open file, error %v\n", err)
-		}
-		dbNames = strings.Split(string(content), "\n")
-	} else {
-		if optionsDB.DBName == "" {
-			optionsDB.DBName = "''"
+	var failOn utils.IssueSeverity
+	if options.FailOn != "" {
+		if failOn, err = utils.ParseSeverity(options.FailOn); err != nil {
+			log.Fatalf("ERROR: --fail-on %v\n", err)
 		}
-		dbNames = []string{optionsDB.DBName}
 	}
 
-	for _, dbName := range dbNames {
-		if strings.Trim(dbName, " ") == "" {
-			continue
+	context.MinHistory = issues.DefaultMinHistory(context.Duration)
+	if options.MinHistory != "" {
+		if context.MinHistory, err = utils.ParseDuration(options.MinHistory); err != nil {
+			log.Fatalf("ERROR: --min-history %v\n", err)
 		}
+	}
 
-		ds.SetDBName(dbName)
-		psqlInfo := ds.GetDataSourceString()
+	if err := context.SetRetention(options.Retain, options.DownsampleAfter); err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
 
-		if context.Verbose {
-			fmt.Printf("Connection String: %s\n", psqlInfo)
-		}
+	if options.WorstTenants < 1 {
+		log.Fatalf("ERROR: --worst-tenants must be at least 1\n")
+	}
 
-		db, err := sql.Open("postgres", psqlInfo)
+	if !report.IsValidFormat(options.Output) {
+		log.Fatalf("ERROR: Output format '%s' not supported, should be one of '%s' or '%s'\n", options.Output, report.FormatText, report.FormatJSON)
+	}
+
+	out := os.Stdout
+	if options.OutputFile != "" {
+		file, err := os.Create(options.OutputFile)
 		if err != nil {
-			log.Printf("ERROR: Database: %s, open failed with error: %v\n", dbName, err)
-			continue
+			log.Fatalf("ERROR: Failed to create output file '%s', error: %v\n", options.OutputFile, err)
 		}
-		ds.SetDatabase(db)
+		defer file.Close()
+		out = file
+	}
 
-		err = db.Ping()
-		if err != nil {
-			log.Printf("ERROR: Database: %s, failed to ping database, error: %v\n", dbName, err)
-			continue
+	var baseline *report.Baseline
+	if options.Baseline != "" {
+		if baseline, err = report.LoadBaseline(options.Baseline); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
 		}
+	}
+
+	ds := dbutils.NewDataSource(optionsDB)
+	defer ds.CloseTunnels()
+
+	if optionsDB.DBNames != "" && optionsDB.DBName != "" {
+		log.Fatalf("ERROR: Cannot specify both dbname and dbnames options\n")
+	}
+	targets, err := inventory.Targets(optionsDB)
+	if err != nil {
+		log.Fatalf("ERROR: %v\n", err)
+	}
+
+	if options.Parallel > 1 && strings.HasPrefix(options.Command, "Apply") && len(context.ApplyAllow) == 0 && !context.DryRun {
+		log.Fatalf("ERROR: --command Apply with --parallel requires --apply-allow (confirmation is not possible)\n")
+	}
+
+	offline := isOffline(options, optionsDB.Archive)
+	if optionsDB.AllDatabases && offline {
+		log.Fatalf("ERROR: --all-databases requires a connection to discover the databases\n")
+	}
+
+	r := &runner{options: options, context: context, multiple: optionsDB.DBNames != "" || optionsDB.Inventory != "" || optionsDB.AllDatabases, offline: offline, minSeverity: minSeverity, baseline: baseline,
+		report: report.NewReport(), remediation: report.NewRemediation(context.LockTimeout, options.AggregateSchemas), out: out}
 
-		// If we are processing multiple databases then output the name of the DB we are working on
-		if optionsDB.DBNames != "" {
-			fmt.Printf("Database: %s\n", dbName)
+	if optionsDB.AllDatabases {
+		var failed int
+		targets, failed = dbutils.Discover(ds, targets, optionsDB.ExcludeDatabases, optionsDB.Inventory != "")
+		r.report.AddErrors(failed)
+	}
+
+	dbutils.ForEachDatabase(ds, targets, options.Parallel, r.processDatabase)
+
+	if options.WriteBaseline != "" {
+		if err := report.NewBaseline(r.allDetected, baseline).Write(options.WriteBaseline); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
 		}
+	}
 
-		if options.Detect != "" {
-			detectOptions := strings.Split(options.Detect, ":")
-			detector, err := issues.NewDetector(detectOptions[0])
-			if err != nil {
-				log.Println("ERROR: Failed to locate detector\n", err)
-				continue
-			}
-			detector.Init(context, ds)
-			detector.Execute(detectOptions[1:]...)
-			for _, issue := range detector.GetIssues() {
-				issue.Dump()
-			}
-			if context.Verbose {
-				fmt.Printf("Execution Time: %dms\n", detector.GetDurationMS())
-			}
-			continue
+	if options.Remediation != "" {
+		if err := r.remediation.Write(options.Remediation); err != nil {
+			log.Fatalf("ERROR: %v\n", err)
 		}
+	}
 
-		if options.Command != "" {
-			commandOptions := strings.Split(options.Command, ":")
-			command, err := commands.NewCommand(commandOptions[0])
-			if err != nil {
-				log.Println("ERROR: Failed to locate command\n", err)
-				continue
+	if options.Detect != "" && options.Detect != "Help" {
+		if options.Output == report.FormatJSON {
+			if err := r.report.WriteJSON(out); err != nil {
+				log.Fatalf("ERROR: %v\n", err)
+			}
+		} else {
+			if r.multiple {
+				r.report.WriteDatabaseSummary(out)
 			}
-			command.Init(context, ds)
-			command.Execute(commandOptions[1:]...)
-			continue
+			fmt.Fprint(out, r.report.SummaryLine())
 		}
+	} else if r.multiple {
+		r.report.WriteDatabaseSummary(os.Stdout)
+	}
 
-		db.Close()
+	if r.report.Summary.Errors != 0 {
+		return ExitError
+	}
+	if options.FailOn != "" && r.report.CountAtLeast(failOn) != 0 {
+		return ExitIssuesFound
 	}
+
+	return ExitOK
 }
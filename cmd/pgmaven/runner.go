@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"pgmaven/internal/commands"
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/issues"
+	"pgmaven/internal/report"
+	"pgmaven/internal/utils"
+)
+
+// offlineDetectors and offlineCommands only require the snapshot history, so can be run against an archive without a connection.
+var (
+	offlineDetectors = []string{"QueryIssues", "TableIssues"}
+	offlineCommands  = []string{"NewActivity"}
+)
+
+// isOffline returns true if the detector or command can be executed solely from an archive.
+func isOffline(options Options, archive string) bool {
+	if archive == "" {
+		return false
+	}
+	if options.Detect != "" {
+		return slices.Contains(offlineDetectors, strings.Split(options.Detect, ":")[0])
+	}
+
+	return slices.Contains(offlineCommands, strings.Split(options.Command, ":")[0])
+}
+
+// runner processes each database, it is safe to use from multiple workers concurrently.
+type runner struct {
+	options     Options
+	context     utils.Context
+	multiple    bool
+	offline     bool
+	minSeverity utils.IssueSeverity
+	baseline    *report.Baseline
+	report      *report.Report
+	remediation *report.Remediation
+	out         io.Writer
+	outMutex    sync.Mutex
+	allDetected []utils.Issue
+	allMutex    sync.Mutex
+}
+
+// processDatabase executes the detector or command against a single database.
+// When running in parallel all output is buffered and only written once the database is complete so it is not interleaved.
+func (r *runner) processDatabase(ds *dbutils.DataSource, dbName string) {
+	startMS := time.Now().UnixMilli()
+	status := report.DatabaseStatus{Cluster: ds.GetCluster(), Database: ds.GetDBName()}
+	defer func() {
+		status.DurationMS = time.Now().UnixMilli() - startMS
+		r.report.AddDatabase(status)
+	}()
+
+	var console, issueOut io.Writer = r.console(), r.out
+	if r.options.Parallel > 1 {
+		consoleBuffer := new(bytes.Buffer)
+		issueBuffer := consoleBuffer
+		if r.out != r.console() {
+			issueBuffer = new(bytes.Buffer)
+		}
+		console, issueOut = consoleBuffer, issueBuffer
+		defer r.flush(consoleBuffer, issueBuffer)
+	}
+
+	context := r.context
+	context.Out = console
+
+	// When analyzing an archive there is no connection to the monitored database
+	if !r.offline {
+		if context.Verbose {
+			fmt.Fprintf(console, "Connection String: %s\n", ds.GetRedactedDataSourceString())
+		}
+
+		if err := ds.Open(); err != nil {
+			log.Printf("ERROR: Database: %s, %v\n", dbName, err)
+			r.report.AddErrors(1)
+			status.Errors = 1
+			return
+		}
+	}
+
+	// If we are processing multiple databases then output the name of the DB we are working on
+	if r.multiple && r.options.Output == report.FormatText {
+		fmt.Fprintf(issueOut, "Database: %s\n", dbName)
+	}
+
+	if r.options.Detect != "" {
+		detectOptions := strings.Split(r.options.Detect, ":")
+		detector, err := issues.NewDetector(detectOptions[0])
+		if err != nil {
+			log.Println("ERROR: Failed to locate detector\n", err)
+			r.report.AddErrors(1)
+			status.Errors = 1
+			return
+		}
+		detector.Init(context, ds)
+		detector.Execute(detectOptions[1:]...)
+		r.report.AddErrors(ds.GetErrorCount())
+		detected, filtered := report.FilterSeverity(detector.GetIssues(), r.minSeverity)
+		r.report.AddFiltered(filtered)
+		for i := range detected {
+			detected[i].Cluster = ds.GetCluster()
+			detected[i].Database = ds.GetDBName()
+		}
+		r.addDetected(detected)
+		if r.baseline != nil {
+			var suppressed int
+			detected, suppressed = r.baseline.Suppress(detected, time.Now())
+			r.report.AddSuppressed(suppressed)
+		}
+		if r.options.Remediation != "" {
+			r.remediation.Add(ds, detected)
+		}
+		status.Issues = len(detected)
+		var aggregates []report.Aggregate
+		if r.options.AggregateSchemas {
+			aggregates, detected = report.AggregateIssues(detected)
+		}
+		r.report.Add(report.Result{Cluster: ds.GetCluster(), Database: ds.GetDBName(), Detector: detectOptions[0], DurationMS: detector.GetDurationMS(),
+			Issues: detected, Aggregates: aggregates})
+		if r.options.Output == report.FormatText {
+			for _, aggregate := range aggregates {
+				aggregate.DumpTo(issueOut, r.options.WorstTenants)
+			}
+			for _, issue := range detected {
+				issue.DumpTo(issueOut)
+			}
+			if context.Verbose {
+				fmt.Fprintf(issueOut, "Execution Time: %dms\n", detector.GetDurationMS())
+			}
+		}
+	} else if r.options.Command != "" {
+		commandOptions := strings.Split(r.options.Command, ":")
+		command, err := commands.NewCommand(commandOptions[0])
+		if err != nil {
+			log.Println("ERROR: Failed to locate command\n", err)
+			r.report.AddErrors(1)
+			status.Errors = 1
+			return
+		}
+		command.Init(context, ds)
+		command.Execute(commandOptions[1:]...)
+		r.report.AddErrors(ds.GetErrorCount())
+	}
+
+	status.Errors = ds.GetErrorCount()
+	status.OK = status.Errors == 0
+}
+
+// console returns the destination for the console output (e.g. verbose output), this is stderr if a JSON document is written to stdout.
+func (r *runner) console() io.Writer {
+	if r.options.Output == report.FormatJSON && r.out == io.Writer(os.Stdout) {
+		return os.Stderr
+	}
+
+	return os.Stdout
+}
+
+func (r *runner) addDetected(detected []utils.Issue) {
+	r.allMutex.Lock()
+	defer r.allMutex.Unlock()
+	r.allDetected = append(r.allDetected, detected...)
+}
+
+func (r *runner) flush(consoleBuffer *bytes.Buffer, issueBuffer *bytes.Buffer) {
+	r.outMutex.Lock()
+	defer r.outMutex.Unlock()
+
+	r.console().Write(consoleBuffer.Bytes())
+	if issueBuffer != consoleBuffer {
+		r.out.Write(issueBuffer.Bytes())
+	}
+}
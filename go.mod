@@ -3,11 +3,11 @@ module pgmaven
 go 1.22
 
 require (
-	github.com/elliotchance/sshtunnel v1.6.1
 	github.com/lib/pq v1.10.9
 	github.com/spf13/pflag v1.0.5
 	golang.org/x/crypto v0.19.0
 	golang.org/x/exp v0.0.0-20240318143956-a85f2c67cd81
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require golang.org/x/sys v0.17.0 // indirect
@@ -0,0 +1,223 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/issues"
+	"pgmaven/internal/report"
+	"pgmaven/internal/utils"
+)
+
+const auditTable = "pgmaven_apply_audit"
+
+// confirmations is shared by every database, a reader per database would buffer (and so lose) answers intended for later databases.
+var (
+	confirmations      = bufio.NewReader(os.Stdin)
+	confirmationsMutex sync.Mutex
+)
+
+type Apply struct {
+	datasource *dbutils.DataSource
+	context    utils.Context
+	input      *bufio.Reader
+}
+
+func (c *Apply) Init(context utils.Context, ds *dbutils.DataSource) {
+	c.datasource = ds
+	c.context = context
+	c.input = confirmations
+}
+
+// Apply executes the remediation statements for the issues detected.  The issues are either sourced from a
+// detection run (e.g. Apply:IndexIssues:IndexDuplicate) or from a saved JSON result (e.g. Apply:!issues.json).
+func (c *Apply) Execute(args ...string) {
+	if len(args) == 0 {
+		log.Printf("ERROR: Database: %s, Apply requires a detector or a saved result (!<file>)\n", c.datasource.GetName())
+		return
+	}
+
+	found, err := c.getIssues(args...)
+	if err != nil {
+		log.Printf("ERROR: Database: %s, Apply failed to get issues, error: %v\n", c.datasource.GetName(), err)
+		c.datasource.AddError()
+		return
+	}
+
+	statements := make([]report.Statement, 0)
+	seen := make(map[string]bool)
+	for _, issue := range found {
+		for _, statement := range report.Statements(issue) {
+			if !seen[statement.SQL] {
+				seen[statement.SQL] = true
+				statements = append(statements, statement)
+			}
+		}
+	}
+	report.SortStatements(statements)
+
+	if len(statements) == 0 {
+		fmt.Fprintf(c.context.Writer(), "Database: %s, Apply: no executable remediations found\n", c.datasource.GetName())
+		return
+	}
+
+	if !c.context.DryRun && !c.createAuditTable() {
+		return
+	}
+
+	for _, statement := range statements {
+		fmt.Fprintf(c.context.Writer(), "Database: %s, %s: %s\n\t%s;\n", c.datasource.GetName(), statement.Issue.IssueType, statement.Issue.Target, statement.SQL)
+		if statement.Note != "" {
+			fmt.Fprintf(c.context.Writer(), "\t-- %s\n", statement.Note)
+		}
+
+		if c.context.DryRun {
+			continue
+		}
+
+		approved, quit := c.approve(statement)
+		if quit {
+			return
+		}
+		if !approved {
+			fmt.Fprintln(c.context.Writer(), "\tSkipped")
+			continue
+		}
+
+		c.apply(statement)
+	}
+}
+
+func (c *Apply) getIssues(args ...string) ([]utils.Issue, error) {
+	if strings.HasPrefix(args[0], "!") {
+		saved, err := report.LoadReport(args[0][1:])
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]utils.Issue, 0)
+		for _, result := range saved.Results {
+			for _, issue := range result.Issues {
+				if (issue.Database == "" || issue.Database == c.datasource.GetDBName()) && (issue.Cluster == "" || issue.Cluster == c.datasource.GetCluster()) {
+					ret = append(ret, issue)
+				}
+			}
+		}
+		return ret, nil
+	}
+
+	detector, err := issues.NewDetector(args[0])
+	if err != nil {
+		return nil, err
+	}
+	detector.Init(c.context, c.datasource)
+	detector.Execute(args[1:]...)
+
+	return detector.GetIssues(), nil
+}
+
+// approve returns true if the statement should be applied, either because it is on the allow-list or the user confirmed it.
+func (c *Apply) approve(statement report.Statement) (approved bool, quit bool) {
+	if len(c.context.ApplyAllow) != 0 {
+		return slices.Contains(c.context.ApplyAllow, statement.Issue.IssueType), false
+	}
+
+	// With --parallel only one database may prompt at a time
+	confirmationsMutex.Lock()
+	defer confirmationsMutex.Unlock()
+
+	fmt.Fprint(c.context.Writer(), "\tApply? [y]es, [n]o, [q]uit: ")
+	response, err := c.input.ReadString('\n')
+	if err != nil && response == "" {
+		// No input available (e.g. not interactive) - do not apply anything
+		return false, true
+	}
+
+	switch strings.ToLower(strings.TrimSpace(response)) {
+	case "y", "yes":
+		return true, false
+	case "q", "quit":
+		return false, true
+	}
+
+	return false, false
+}
+
+// apply executes the statement on a dedicated connection (never inside a transaction) with the timeouts set, and records the outcome.
+func (c *Apply) apply(statement report.Statement) {
+	ctx := context.Background()
+	conn, err := c.datasource.GetDatabase().Conn(ctx)
+	if err != nil {
+		log.Printf("ERROR: Database: %s, Apply failed to obtain connection, error: %v\n", c.datasource.GetName(), err)
+		c.datasource.AddError()
+		return
+	}
+	defer conn.Close()
+
+	settings := []string{
+		fmt.Sprintf("SET statement_timeout = '%dms'", c.context.StatementTimeout.Milliseconds()),
+		fmt.Sprintf("SET lock_timeout = '%dms'", c.context.LockTimeout.Milliseconds()),
+	}
+	for _, setting := range settings {
+		if c.context.Verbose {
+			log.Println(setting)
+		}
+		if _, err := conn.ExecContext(ctx, setting); err != nil {
+			log.Printf("ERROR: Database: %s, Apply '%s' failed with error: %v\n", c.datasource.GetName(), setting, err)
+			c.datasource.AddError()
+			return
+		}
+	}
+
+	startMS := time.Now().UnixMilli()
+	_, err = conn.ExecContext(ctx, statement.SQL)
+	durationMS := time.Now().UnixMilli() - startMS
+
+	outcome, errorText := "SUCCESS", ""
+	if err != nil {
+		outcome, errorText = "FAILED", err.Error()
+		log.Printf("ERROR: Database: %s, Apply '%s' failed with error: %v\n", c.datasource.GetName(), statement.SQL, err)
+		c.datasource.AddError()
+	}
+	fmt.Fprintf(c.context.Writer(), "\t%s (%dms)\n", outcome, durationMS)
+
+	issue := statement.Issue
+	issue.Cluster, issue.Database = c.datasource.GetCluster(), c.datasource.GetDBName()
+	insert := fmt.Sprintf(`INSERT INTO %s (issue_type, target, fingerprint, statement, duration_ms, outcome, error) VALUES ($1, $2, $3, $4, $5, $6, $7)`, auditTable)
+	_, err = c.datasource.Exec(insert, []any{issue.IssueType, issue.Target, issue.Fingerprint(), statement.SQL, durationMS, outcome, errorText})
+	if err != nil {
+		log.Printf("ERROR: Database: %s, Apply failed to record outcome, error: %v\n", c.datasource.GetName(), err)
+	}
+}
+
+func (c *Apply) createAuditTable() bool {
+	stmt := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id BIGSERIAL PRIMARY KEY,
+	applied_dt TIMESTAMP DEFAULT NOW(),
+	applied_by TEXT DEFAULT CURRENT_USER,
+	issue_type TEXT,
+	target TEXT,
+	fingerprint TEXT,
+	statement TEXT,
+	duration_ms BIGINT,
+	outcome TEXT,
+	error TEXT)`, auditTable)
+
+	if c.context.Verbose {
+		log.Println(stmt)
+	}
+
+	if _, err := c.datasource.Exec(stmt, nil); err != nil {
+		log.Printf("ERROR: Database: %s, Apply failed to create audit table, error: %v\n", c.datasource.GetName(), err)
+		return false
+	}
+
+	return true
+}
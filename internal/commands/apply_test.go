@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/report"
+	"pgmaven/internal/utils"
+)
+
+func TestApplyGetIssues(t *testing.T) {
+	saved := report.Report{Results: []report.Result{{Issues: []utils.Issue{
+		{IssueType: "IndexDuplicate", Target: "public.idx_a", Database: "sales", Cluster: "db1:5432"},
+		{IssueType: "IndexUnused", Target: "public.idx_b", Database: "billing", Cluster: "db1:5432"},
+		{IssueType: "IndexUnused", Target: "public.idx_c", Database: "sales", Cluster: "db2:5432"},
+		{IssueType: "TableAnalyze", Target: "public.orders"},
+	}}}}
+	content, err := json.Marshal(&saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "issues.json")
+	if err := os.WriteFile(name, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ds := dbutils.NewDataSource(dbutils.DBOptions{Host: "db1", Port: 5432})
+	ds.SetTarget(dbutils.Target{Name: "sales", DBName: "sales", Options: dbutils.DBOptions{Host: "db1", Port: 5432}})
+	c := Apply{datasource: ds, context: utils.Context{Out: io.Discard}}
+
+	tests := []struct {
+		arg     string
+		targets []string
+		fails   bool
+	}{
+		// Only the issues for this database (or with no database) are applied
+		{"!" + name, []string{"public.idx_a", "public.orders"}, false},
+		{"!" + filepath.Join(t.TempDir(), "missing.json"), nil, true},
+		{"", nil, true},
+		{"NoSuchDetector", nil, true},
+	}
+	for _, test := range tests {
+		found, err := c.getIssues(test.arg)
+		if (err != nil) != test.fails {
+			t.Errorf("getIssues(%q): unexpected error %v", test.arg, err)
+			continue
+		}
+		targets := make([]string, 0)
+		for _, issue := range found {
+			targets = append(targets, issue.Target)
+		}
+		if strings.Join(targets, ",") != strings.Join(test.targets, ",") {
+			t.Errorf("getIssues(%q): expected %v, got %v", test.arg, test.targets, targets)
+		}
+	}
+}
+
+func TestApplyApprove(t *testing.T) {
+	statement := report.Statement{Issue: utils.Issue{IssueType: "IndexDuplicate"}}
+
+	tests := []struct {
+		allow    []string
+		input    string
+		approved bool
+		quit     bool
+	}{
+		{[]string{"IndexDuplicate"}, "", true, false},
+		{[]string{"IndexUnused"}, "y\n", false, false},
+		{nil, "y\n", true, false},
+		{nil, "YES\n", true, false},
+		{nil, "n\n", false, false},
+		{nil, "\n", false, false},
+		{nil, "q\n", false, true},
+		// No input (e.g. not interactive) - nothing is applied
+		{nil, "", false, true},
+	}
+	for _, test := range tests {
+		c := Apply{context: utils.Context{ApplyAllow: test.allow, Out: io.Discard}, input: bufio.NewReader(strings.NewReader(test.input))}
+		approved, quit := c.approve(statement)
+		if approved != test.approved || quit != test.quit {
+			t.Errorf("approve(allow: %v, input: %q): expected (%t, %t), got (%t, %t)", test.allow, test.input, test.approved, test.quit, approved, quit)
+		}
+	}
+}
+
+func TestApplyConfirmationsShared(t *testing.T) {
+	saved := confirmations
+	defer func() { confirmations = saved }()
+	confirmations = bufio.NewReader(strings.NewReader("n\ny\n"))
+
+	statement := report.Statement{Issue: utils.Issue{IssueType: "IndexDuplicate"}}
+	for i, expected := range []bool{false, true} {
+		c := Apply{}
+		c.Init(utils.Context{Out: io.Discard}, &dbutils.DataSource{})
+		if approved, quit := c.approve(statement); approved != expected || quit {
+			t.Fatalf("database %d: expected approved %t, got (%t, %t)", i, expected, approved, quit)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package commands
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/history"
+)
+
+// captureSnapshot reads the Statistics tables (all the tables if nil) and the details required to interpret them from the
+// monitored database in a single read-only transaction, so every table reflects the same point in time.
+func captureSnapshot(ds *dbutils.DataSource, tables []string) (*history.Archive, error) {
+	source, err := ds.GetDatabase().BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction, error: %v", err)
+	}
+	defer source.Rollback()
+
+	var info dbutils.SnapshotInfo
+	var postmasterStart, statsReset, statementsReset sql.NullTime
+	infoQuery := `SELECT NOW()::timestamp, current_setting('server_version'), pg_postmaster_start_time(), (SELECT stats_reset FROM pg_stat_database WHERE datname = current_database())`
+	if err := source.QueryRow(infoQuery).Scan(&info.Time, &info.ServerVersion, &postmasterStart, &statsReset); err != nil {
+		return nil, fmt.Errorf("failed to get server details, error: %v", err)
+	}
+	// pg_stat_statements_info only exists in PostgreSQL 14+ (and if the extension is installed)
+	if exists(source, "pg_stat_statements_info") {
+		if err := source.QueryRow(`SELECT stats_reset FROM pg_stat_statements_info`).Scan(&statementsReset); err != nil {
+			return nil, fmt.Errorf("failed to get pg_stat_statements_info, error: %v", err)
+		}
+	}
+	info.PostmasterStart, info.StatsReset, info.StatementsReset = postmasterStart.Time, statsReset.Time, statementsReset.Time
+	info.Tables = tables
+	if tables == nil {
+		tables = StatsTables[:]
+	}
+
+	ret := &history.Archive{Snapshot: history.NewArchiveSnapshot(info), Cluster: ds.GetCluster(), Database: ds.GetDBName(), Users: make(map[int64]string)}
+
+	users, err := source.Query(`SELECT usesysid::bigint, usename FROM pg_user`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users, error: %v", err)
+	}
+	defer users.Close()
+	for users.Next() {
+		var id int64
+		var name string
+		if err := users.Scan(&id, &name); err != nil {
+			return nil, fmt.Errorf("failed to read users, error: %v", err)
+		}
+		ret.Users[id] = name
+	}
+	if err := users.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read users, error: %v", err)
+	}
+
+	for _, table := range tables {
+		if !exists(source, table) {
+			continue
+		}
+		captured, err := captureTable(source, table)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s', error: %v", table, err)
+		}
+		ret.Tables = append(ret.Tables, captured)
+	}
+
+	return ret, nil
+}
+
+// exists returns true if the relation is visible in the monitored database.
+func exists(tx *sql.Tx, relation string) bool {
+	var found bool
+	if err := tx.QueryRow(`SELECT to_regclass($1) IS NOT NULL`, relation).Scan(&found); err != nil {
+		return false
+	}
+
+	return found
+}
+
+func captureTable(tx *sql.Tx, table string) (history.Capture, error) {
+	ret := history.Capture{Table: table, Rows: make([][]any, 0)}
+
+	metadata, err := tx.Query(`SELECT attname, format_type(atttypid, atttypmod) FROM pg_attribute WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped ORDER BY attnum`, table)
+	if err != nil {
+		return ret, err
+	}
+	defer metadata.Close()
+	for metadata.Next() {
+		var col history.Column
+		if err := metadata.Scan(&col.Name, &col.Type); err != nil {
+			return ret, err
+		}
+		ret.Columns = append(ret.Columns, col)
+	}
+	if err := metadata.Err(); err != nil {
+		return ret, err
+	}
+
+	rows, err := tx.Query(fmt.Sprintf("SELECT * FROM %s", table))
+	if err != nil {
+		return ret, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		values := make([]any, len(ret.Columns))
+		pointers := make([]any, len(ret.Columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return ret, err
+		}
+		// Text representations (e.g. name, oid, numeric) are returned as []uint8 and must not be copied as bytea
+		for i, value := range values {
+			if bytes, ok := value.([]uint8); ok {
+				values[i] = string(bytes)
+			}
+		}
+		ret.Rows = append(ret.Rows, values)
+	}
+
+	return ret, rows.Err()
+}
@@ -2,6 +2,9 @@ package commands
 
 import (
 	"fmt"
+	"slices"
+	"strings"
+
 	"pgmaven/internal/dbutils"
 	"pgmaven/internal/utils"
 )
@@ -17,20 +20,42 @@ type CommandDetails struct {
 }
 
 var commandRegistry map[string]CommandDetails = map[string]CommandDetails{
+	"Apply":             {"Apply remediations for detected issues (Apply:<detector> or Apply:!<saved json>)", func() Command { return &Apply{} }},
 	"Exec":              {"Execute SQL statement across all DBs provided", func() Command { return &Exec{} }},
 	"Help":              {"Output usage", func() Command { return &Help{} }},
 	"NewActivity":       {"Output New Queries in the specified duration", func() Command { return &NewActivity{} }},
 	"QueryRow":          {"Query (single row) to execute across all DBs provided", func() Command { return &QueryRow{} }},
 	"QueryRows":         {"Query (multiple rows) to execute across all DBs provided", func() Command { return &QueryRows{} }},
 	"MonitorInitialize": {"Initialize infrastructure for activity monitoring", func() Command { return &MonitorInitialize{} }},
+	"MonitorPrune":      {"Delete (--retain) and downsample (--downsample-after) old snapshots", func() Command { return &MonitorPrune{} }},
 	"MonitorReset":      {"Reset activity monitoring data", func() Command { return &MonitorReset{} }},
 	"MonitorTerminate":  {"Delete infrastructure for activity monitoring", func() Command { return &MonitorTerminate{} }},
-	"Snapshot":          {"Snapshot statistics tables", func() Command { return &Snapshot{} }},
+	"Snapshot":          {"Snapshot statistics tables (Snapshot:<table>,... for a subset)", func() Command { return &Snapshot{} }},
 	"Summary":           {"Status summary", func() Command { return &Summary{} }},
 }
 
 var StatsTables = [...]string{"pg_stat_user_indexes", "pg_statio_user_indexes", "pg_stat_user_tables", "pg_statio_user_tables", "pg_stat_statements", "pg_stat_activity"}
 
+// ParseStatsTables returns the Statistics tables in the comma separated list, nil (i.e. all the tables) if the list is empty.
+func ParseStatsTables(list string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+
+	ret := make([]string, 0)
+	for _, table := range strings.Split(list, ",") {
+		table = strings.TrimSpace(table)
+		if !slices.Contains(StatsTables[:], table) {
+			return nil, fmt.Errorf("unknown Statistics table '%s', should be one of %s", table, strings.Join(StatsTables[:], ", "))
+		}
+		if !slices.Contains(ret, table) {
+			ret = append(ret, table)
+		}
+	}
+
+	return ret, nil
+}
+
 func NewCommand(name string) (cmd Command, err error) {
 
 	details, ok := commandRegistry[name]
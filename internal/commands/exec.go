@@ -9,10 +9,12 @@ import (
 
 type Exec struct {
 	datasource *dbutils.DataSource
+	context    utils.Context
 }
 
 func (c *Exec) Init(context utils.Context, ds *dbutils.DataSource) {
 	c.datasource = ds
+	c.context = context
 }
 
 func (c *Exec) Execute(args ...string) {
@@ -23,13 +25,13 @@ func (c *Exec) Execute(args ...string) {
 	}
 	result, err := c.datasource.Exec(cmd, s)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Exec '%s' failed with error: %v\n", c.datasource.GetDBName(), cmd, err)
+		log.Printf("ERROR: Database: %s, Exec '%s' failed with error: %v\n", c.datasource.GetName(), cmd, err)
 		return
 	}
 	affected, err := result.RowsAffected()
 	if err != nil {
-		log.Printf("ERROR: Database: %s, result.RowsAffected '%s' failed with error: %v\n", c.datasource.GetDBName(), cmd, err)
+		log.Printf("ERROR: Database: %s, result.RowsAffected '%s' failed with error: %v\n", c.datasource.GetName(), cmd, err)
 		return
 	}
-	fmt.Printf("Database: %s, Exec '%s', rows affected: %d\n", c.datasource.GetDBName(), cmd, affected)
+	fmt.Fprintf(c.context.Writer(), "Database: %s, Exec '%s', rows affected: %d\n", c.datasource.GetName(), cmd, affected)
 }
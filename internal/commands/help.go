@@ -10,15 +10,17 @@ import (
 )
 
 type Help struct {
+	context utils.Context
 }
 
 func (c *Help) Init(context utils.Context, ds *dbutils.DataSource) {
+	c.context = context
 }
 
 func (h *Help) Execute(args ...string) {
 	keys := maps.Keys(commandRegistry)
 	sort.Strings(keys)
 	for _, key := range keys {
-		fmt.Printf("%s - %s\n", key, commandRegistry[key].HelpText)
+		fmt.Fprintf(h.context.Writer(), "%s - %s\n", key, commandRegistry[key].HelpText)
 	}
 }
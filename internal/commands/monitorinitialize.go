@@ -5,6 +5,7 @@ import (
 	"log"
 	"pgmaven/internal/dbutils"
 	"pgmaven/internal/utils"
+	"strings"
 )
 
 type MonitorInitialize struct {
@@ -17,51 +18,93 @@ func (c *MonitorInitialize) Init(context utils.Context, ds *dbutils.DataSource)
 	c.context = context
 }
 
-// MonitorInitialize will create the tables required to track index activity over time.
+// MonitorInitialize will create the tables required to track index activity over time.  It is safe to run against an existing
+// installation, in which case any missing infrastructure (e.g. the snapshot catalog) is added.
 func (c *MonitorInitialize) Execute(args ...string) {
-	for _, table := range StatsTables {
-		c.createTable(table)
+	// Archives are self-describing files, so there is nothing to create in any database
+	if c.datasource.GetArchive() != "" {
+		c.snapshot()
+		return
 	}
 
+	c.createCatalog()
+	// When using a repository the tables are created (from the definitions in the monitored database) as each snapshot is stored
+	if c.datasource.GetRepository() == nil {
+		for _, table := range StatsTables {
+			c.createTable(table)
+		}
+		c.backfill()
+	}
+
+	c.snapshot()
+}
+
+func (c *MonitorInitialize) snapshot() {
 	snapshotter := new(Snapshot)
 	snapshotter.Init(c.context, c.datasource)
 	snapshotter.Execute()
 }
 
-func (c *MonitorInitialize) createTable(tableName string) {
-	stmt := fmt.Sprintf("CREATE TABLE pgmaven_%s as table %s with no data;", tableName, tableName)
-	if c.context.DryRun || c.context.Verbose {
-		log.Println(stmt)
+func (c *MonitorInitialize) createCatalog() {
+	for _, stmt := range dbutils.SnapshotCatalogDDL {
+		c.execute(c.datasource.History(), stmt, "catalog creation")
 	}
+}
+
+func (c *MonitorInitialize) createTable(tableName string) {
+	c.execute(c.datasource, fmt.Sprintf("CREATE TABLE IF NOT EXISTS pgmaven_%s as table %s with no data;", tableName, tableName), "table creation")
+	c.execute(c.datasource, fmt.Sprintf("ALTER TABLE pgmaven_%s ADD COLUMN IF NOT EXISTS insert_dt TIMESTAMP DEFAULT NOW();", tableName), "alter table")
+	c.execute(c.datasource, fmt.Sprintf("ALTER TABLE pgmaven_%s ADD COLUMN IF NOT EXISTS snapshot_id BIGINT;", tableName), "alter table")
+	c.execute(c.datasource, fmt.Sprintf("CREATE INDEX IF NOT EXISTS pgmaven_ix_%s_insert_dt ON pgmaven_%s(insert_dt)", tableName, tableName), "create index")
+	c.execute(c.datasource, fmt.Sprintf("CREATE INDEX IF NOT EXISTS pgmaven_ix_%s_snapshot_id ON pgmaven_%s(snapshot_id)", tableName, tableName), "create index")
+}
+
+// backfill adds the snapshots taken by earlier versions (identified only by insert_dt) to the catalog, so they are included in the analysis.
+// Each table was captured in its own transaction, so every distinct insert_dt of a table is recorded as a snapshot of that table.
+func (c *MonitorInitialize) backfill() {
+	c.execute(c.datasource, backfillStatement(StatsTables[:]), "snapshot backfill", c.datasource.GetCluster())
 
+	// pg_stat_statements_info only exists in PostgreSQL 14+ (and if the extension is installed)
 	if !c.context.DryRun {
-		_, err := c.datasource.GetDatabase().Exec(stmt)
-		if err != nil {
-			log.Printf("ERROR: Database: %s, CreateTable table creation failed, error: %s\n", c.datasource.GetDBName(), err)
+		if exists, err := c.datasource.ExecuteQueryRow("SELECT to_regclass('pg_stat_statements_info') IS NOT NULL", nil); err != nil || exists != true {
+			return
 		}
 	}
+	c.execute(c.datasource, fmt.Sprintf(`UPDATE %s SET statements_reset = i.stats_reset FROM pg_stat_statements_info i
+	WHERE server_version IS NULL AND statements_reset IS NULL AND snapshot_dt >= i.stats_reset`, dbutils.SnapshotCatalog), "snapshot backfill")
+}
 
-	stmt = fmt.Sprintf("ALTER TABLE pgmaven_%s ADD COLUMN insert_dt TIMESTAMP DEFAULT NOW();", tableName)
-	if c.context.DryRun || c.context.Verbose {
-		log.Println(stmt)
+// backfillStatement returns the statement that records a catalog row for every distinct insert_dt not referenced by the catalog and
+// updates the rows to reference it.  The server version is not known (which identifies a backfilled snapshot), the postmaster start
+// time and stats_reset are only known if the snapshot was taken after them.
+func backfillStatement(tables []string) string {
+	legacy := make([]string, len(tables))
+	updates := make([]string, len(tables))
+	for i, table := range tables {
+		legacy[i] = fmt.Sprintf("SELECT DISTINCT '%s' AS snapshot_tables, insert_dt FROM pgmaven_%s WHERE snapshot_id IS NULL", table, table)
+		updates[i] = fmt.Sprintf(`update_%d AS (UPDATE pgmaven_%s SET snapshot_id = catalog.snapshot_id FROM catalog
+	WHERE catalog.snapshot_tables = '%s' AND pgmaven_%s.snapshot_id IS NULL AND pgmaven_%s.insert_dt = catalog.snapshot_dt)`, i, table, table, table, table)
 	}
 
-	if !c.context.DryRun {
-		_, err := c.datasource.GetDatabase().Exec(stmt)
-		if err != nil {
-			log.Printf("ERROR: Database: %s, CreateTable alter table failed, error: %s\n", c.datasource.GetDBName(), err)
-		}
-	}
+	return fmt.Sprintf(`WITH legacy AS (%s),
+	catalog AS (INSERT INTO %s (snapshot_dt, cluster_name, database_name, postmaster_start_time, stats_reset, snapshot_tables)
+	SELECT insert_dt, $1, current_database(),
+		CASE WHEN insert_dt >= pg_postmaster_start_time() THEN pg_postmaster_start_time() END,
+		(SELECT stats_reset FROM pg_stat_database WHERE datname = current_database() AND insert_dt >= stats_reset), snapshot_tables
+	FROM legacy ORDER BY insert_dt RETURNING snapshot_id, snapshot_dt, snapshot_tables),
+	%s
+	SELECT count(*) FROM catalog`, strings.Join(legacy, " UNION ALL "), dbutils.SnapshotCatalog, strings.Join(updates, ",\n\t"))
+}
 
-	stmt = fmt.Sprintf("CREATE INDEX pgmaven_ix_%s_insert_dt ON pgmaven_%s(insert_dt)", tableName, tableName)
+func (c *MonitorInitialize) execute(ds *dbutils.DataSource, stmt string, what string, args ...any) {
 	if c.context.DryRun || c.context.Verbose {
 		log.Println(stmt)
 	}
 
 	if !c.context.DryRun {
-		_, err := c.datasource.GetDatabase().Exec(stmt)
+		_, err := ds.GetDatabase().Exec(stmt, args...)
 		if err != nil {
-			log.Printf("ERROR: Database: %s, CreateTable create index failed, error: %s\n", c.datasource.GetDBName(), err)
+			log.Printf("ERROR: Database: %s, CreateTable %s failed, error: %s\n", c.datasource.GetName(), what, err)
 		}
 	}
 }
@@ -0,0 +1,24 @@
+package commands
+
+import (
+	"pgmaven/internal/dbutils"
+	"strings"
+	"testing"
+)
+
+func TestBackfillStatement(t *testing.T) {
+	statement := backfillStatement([]string{"pg_stat_user_indexes", "pg_stat_statements"})
+
+	// A catalog row is recorded for each legacy snapshot of every table and each table references its own catalog rows
+	for _, expected := range []string{
+		"INSERT INTO " + dbutils.SnapshotCatalog,
+		"SELECT DISTINCT 'pg_stat_user_indexes' AS snapshot_tables, insert_dt FROM pgmaven_pg_stat_user_indexes WHERE snapshot_id IS NULL UNION ALL",
+		"FROM legacy ORDER BY insert_dt",
+		"UPDATE pgmaven_pg_stat_user_indexes SET snapshot_id = catalog.snapshot_id",
+		"catalog.snapshot_tables = 'pg_stat_statements' AND pgmaven_pg_stat_statements.snapshot_id IS NULL",
+	} {
+		if !strings.Contains(statement, expected) {
+			t.Fatalf("expected '%s' in backfill statement:\n%s", expected, statement)
+		}
+	}
+}
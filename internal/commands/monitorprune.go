@@ -0,0 +1,215 @@
+package commands
+
+import (
+	"fmt"
+	"log"
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/history"
+	"pgmaven/internal/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type MonitorPrune struct {
+	datasource *dbutils.DataSource
+	context    utils.Context
+}
+
+func (c *MonitorPrune) Init(context utils.Context, ds *dbutils.DataSource) {
+	c.datasource = ds
+	c.context = context
+}
+
+// MonitorPrune deletes snapshots older than the retention period (--retain) and thins snapshots older than --downsample-after
+// to one per interval.  The most recent snapshot and the earliest snapshot since the statistics were last reset (required for
+// long-window index usage analysis) are always retained.
+func (c *MonitorPrune) Execute(args ...string) {
+	if c.context.Retain == 0 && c.context.DownsampleInterval == 0 {
+		fmt.Fprintf(c.context.Writer(), "Database: %s, MonitorPrune: nothing to do (use --retain and/or --downsample-after)\n", c.datasource.GetName())
+		return
+	}
+
+	if c.datasource.GetArchive() != "" {
+		c.pruneArchive()
+		return
+	}
+
+	snapshots, err := c.datasource.Snapshots()
+	if err != nil {
+		return
+	}
+
+	now, err := c.datasource.History().ExecuteQueryRow("SELECT NOW()::timestamp", nil)
+	if err != nil {
+		return
+	}
+
+	prune := prunePlan(snapshots, now.(time.Time), c.context.Retain, c.context.DownsampleAfter, c.context.DownsampleInterval)
+
+	if len(prune) != 0 || c.context.Verbose {
+		fmt.Fprintf(c.context.Writer(), "Database: %s, MonitorPrune: snapshots: %d, delete: %d\n", c.datasource.GetName(), len(snapshots), len(prune))
+	}
+	if len(prune) == 0 && c.context.Retain == 0 {
+		return
+	}
+
+	// Rows captured before the snapshot catalog existed are not referenced by it, so prune these based on their age
+	var cutoff time.Time
+	if c.context.Retain != 0 && c.datasource.GetRepository() == nil {
+		cutoff = now.(time.Time).Add(-c.context.Retain)
+	}
+
+	if !deleteSnapshots(c.context, c.datasource, prune, cutoff) {
+		c.datasource.AddError()
+	}
+}
+
+// pruneArchive deletes the archive files of the snapshots to be pruned.
+func (c *MonitorPrune) pruneArchive() {
+	source := history.NewArchiveSource(c.datasource.GetArchive(), c.datasource.GetCluster(), c.datasource.GetDBName())
+	snapshots, err := source.Snapshots()
+	if err != nil {
+		log.Printf("ERROR: Database: %s, MonitorPrune failed to list archives, error: %v\n", c.datasource.GetName(), err)
+		c.datasource.AddError()
+		return
+	}
+
+	prune := prunePlan(snapshots, time.Now(), c.context.Retain, c.context.DownsampleAfter, c.context.DownsampleInterval)
+
+	if len(prune) != 0 || c.context.Verbose {
+		fmt.Fprintf(c.context.Writer(), "Database: %s, MonitorPrune: snapshots: %d, delete: %d\n", c.datasource.GetName(), len(snapshots), len(prune))
+	}
+	if len(prune) == 0 || c.context.DryRun {
+		return
+	}
+
+	if err := source.Delete(prune); err != nil {
+		log.Printf("ERROR: Database: %s, MonitorPrune failed to delete archives, error: %v\n", c.datasource.GetName(), err)
+		c.datasource.AddError()
+	}
+}
+
+// deleteSnapshots deletes the snapshots from the history (either the monitored database or the repository) in a single transaction.
+// If the cutoff is provided then rows captured before the snapshot catalog existed that are older than the cutoff are also deleted.
+func deleteSnapshots(context utils.Context, ds *dbutils.DataSource, ids []int64, cutoff time.Time) bool {
+	tables := make([]string, 0)
+	for _, table := range StatsTables {
+		exists, err := ds.History().ExecuteQueryRow(`SELECT to_regclass($1) IS NOT NULL`, []any{"pgmaven_" + table})
+		if err != nil {
+			return false
+		}
+		// Not all tables may exist (e.g. pg_stat_statements is not installed)
+		if exists.(bool) {
+			tables = append(tables, "pgmaven_"+table)
+		}
+	}
+
+	statements := make([]string, 0)
+	for _, table := range tables {
+		statements = append(statements, fmt.Sprintf("DELETE FROM %s WHERE snapshot_id = ANY($1)", table))
+	}
+	statements = append(statements, fmt.Sprintf("DELETE FROM %s WHERE snapshot_id = ANY($1)", dbutils.SnapshotCatalog))
+
+	legacy := make([]string, 0)
+	if !cutoff.IsZero() {
+		for _, table := range tables {
+			legacy = append(legacy, fmt.Sprintf("DELETE FROM %s WHERE snapshot_id IS NULL AND insert_dt < $1", table))
+		}
+	}
+
+	if context.DryRun {
+		for _, statement := range append(statements, legacy...) {
+			log.Println(statement)
+		}
+		return true
+	}
+
+	tx, err := ds.History().GetDatabase().Begin()
+	if err != nil {
+		log.Printf("ERROR: Database: %s, failed to start transaction to delete snapshots, error: %v\n", ds.GetName(), err)
+		return false
+	}
+	defer tx.Rollback()
+
+	for i, statement := range append(statements, legacy...) {
+		if context.Verbose {
+			log.Println(statement)
+		}
+		var arg any = pq.Array(ids)
+		if i >= len(statements) {
+			arg = cutoff
+		}
+		if _, err := tx.Exec(statement, arg); err != nil {
+			log.Printf("ERROR: Database: %s, '%s' failed with error: %v\n", ds.GetName(), statement, err)
+			return false
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("ERROR: Database: %s, failed to commit deletion of snapshots, error: %v\n", ds.GetName(), err)
+		return false
+	}
+
+	return true
+}
+
+// prunePlan returns the ids of the snapshots (ordered oldest first) to delete.  Snapshots of different sets of tables (e.g. the
+// frequent snapshots of pg_stat_activity) are planned independently, so each set retains its own latest and epoch snapshots.
+func prunePlan(snapshots []dbutils.SnapshotInfo, now time.Time, retain time.Duration, downsampleAfter time.Duration, interval time.Duration) []int64 {
+	groups := make(map[string][]dbutils.SnapshotInfo)
+	for _, snapshot := range snapshots {
+		key := strings.Join(snapshot.Tables, ",")
+		groups[key] = append(groups[key], snapshot)
+	}
+
+	ret := make([]int64, 0)
+	for _, group := range groups {
+		ret = append(ret, prunePlanGroup(group, now, retain, downsampleAfter, interval)...)
+	}
+	slices.Sort(ret)
+
+	return ret
+}
+
+// prunePlanGroup returns the ids of the snapshots (which all captured the same tables) to delete.
+func prunePlanGroup(snapshots []dbutils.SnapshotInfo, now time.Time, retain time.Duration, downsampleAfter time.Duration, interval time.Duration) []int64 {
+	ret := make([]int64, 0)
+	if len(snapshots) == 0 {
+		return ret
+	}
+
+	latest := snapshots[len(snapshots)-1]
+	lastBucket := time.Time{}
+	for i, snapshot := range snapshots {
+		// Always keep the latest snapshot
+		if i == len(snapshots)-1 {
+			break
+		}
+
+		// Always keep the first snapshot after a reset - i.e. the earliest snapshot of each epoch
+		epochStart := i == 0 || !snapshot.SameEpoch(snapshots[i-1])
+		age := now.Sub(snapshot.Time)
+
+		if retain != 0 && age > retain {
+			// The earliest snapshot of the current epoch is the baseline for long-window analysis
+			if !(epochStart && snapshot.SameEpoch(latest)) {
+				ret = append(ret, snapshot.ID)
+				continue
+			}
+		}
+
+		if interval != 0 && age > downsampleAfter {
+			bucket := snapshot.Time.Truncate(interval)
+			if !epochStart && bucket.Equal(lastBucket) {
+				ret = append(ret, snapshot.ID)
+				continue
+			}
+			lastBucket = bucket
+		}
+	}
+
+	return ret
+}
@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"pgmaven/internal/dbutils"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrunePlan(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	start := now.Add(-40 * 24 * time.Hour)
+	reset := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// Hourly snapshots for 40 days, statistics reset after 10 days
+	snapshots := make([]dbutils.SnapshotInfo, 0)
+	for i := 0; i < 40*24; i++ {
+		snapshot := dbutils.SnapshotInfo{ID: int64(i + 1), Time: start.Add(time.Duration(i) * time.Hour)}
+		if i >= 10*24 {
+			snapshot.StatsReset = reset
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	// Retain 20 days - the earliest snapshot after the reset must be kept
+	prune := prunePlan(snapshots, now, 20*24*time.Hour, 0, 0)
+	if slices.Contains(prune, int64(10*24+1)) {
+		t.Fatalf("earliest snapshot of the current epoch should be retained")
+	}
+	if !slices.Contains(prune, int64(1)) || slices.Contains(prune, int64(40*24)) {
+		t.Fatalf("incorrect snapshots pruned")
+	}
+	if len(prune) != 20*24-1 {
+		t.Fatalf("expected %d snapshots pruned - found %d", 20*24-1, len(prune))
+	}
+
+	// Downsample to daily after 7 days - expect one snapshot per day older than 7 days (plus the epoch start)
+	prune = prunePlan(snapshots, now, 0, 7*24*time.Hour, 24*time.Hour)
+	remaining := len(snapshots) - len(prune)
+	if remaining < 7*24+33 || remaining > 7*24+35 {
+		t.Fatalf("unexpected number of snapshots remaining after downsampling: %d", remaining)
+	}
+}
+
+func TestPrunePlanTables(t *testing.T) {
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+
+	// Hourly snapshots of all the tables, interleaved with snapshots of pg_stat_activity every 15 minutes
+	snapshots := make([]dbutils.SnapshotInfo, 0)
+	for i := 0; i < 4*48; i++ {
+		snapshot := dbutils.SnapshotInfo{ID: int64(i + 1), Time: now.Add(-time.Duration(4*48-i) * 15 * time.Minute)}
+		if i%4 != 0 {
+			snapshot.Tables = []string{"pg_stat_activity"}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+
+	// Downsample to daily after 1 day - both sets must retain a snapshot per day
+	prune := prunePlan(snapshots, now, 0, 24*time.Hour, 24*time.Hour)
+	if !slices.IsSorted(prune) {
+		t.Fatalf("snapshots to prune should be ordered")
+	}
+	remaining := map[string]int{}
+	for _, snapshot := range snapshots {
+		if !slices.Contains(prune, snapshot.ID) && snapshot.Time.Before(now.Add(-24*time.Hour)) {
+			remaining[strings.Join(snapshot.Tables, ",")]++
+		}
+	}
+	if remaining[""] == 0 || remaining["pg_stat_activity"] == 0 || remaining[""] > 2 || remaining["pg_stat_activity"] > 2 {
+		t.Fatalf("unexpected snapshots remaining after downsampling: %v", remaining)
+	}
+}
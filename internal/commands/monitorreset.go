@@ -27,7 +27,7 @@ func (c *MonitorReset) Execute(args ...string) {
 		// Reset all Index data
 		_, err := c.datasource.ExecuteQueryRow(resetStatement, nil)
 		if err != nil {
-			log.Printf("ERROR: Database %s, MonitorReset failed with error: %v\n", c.datasource.GetDBName(), err)
+			log.Printf("ERROR: Database %s, MonitorReset failed with error: %v\n", c.datasource.GetName(), err)
 		}
 	}
 
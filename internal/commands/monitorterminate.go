@@ -3,8 +3,11 @@ package commands
 import (
 	"fmt"
 	"log"
+	"os"
 	"pgmaven/internal/dbutils"
+	"pgmaven/internal/history"
 	"pgmaven/internal/utils"
+	"time"
 )
 
 type MonitorTerminate struct {
@@ -18,18 +21,64 @@ func (c *MonitorTerminate) Init(context utils.Context, ds *dbutils.DataSource) {
 }
 
 func (c *MonitorTerminate) Execute(args ...string) {
+	if c.datasource.GetArchive() != "" {
+		c.deleteArchives()
+		return
+	}
+
+	// The repository is shared, so only delete the snapshots for this database
+	if c.datasource.GetRepository() != nil {
+		c.deleteSnapshots()
+		return
+	}
+
 	c.dropTables()
 }
 
+func (c *MonitorTerminate) deleteSnapshots() {
+	if !c.datasource.HasSnapshots() {
+		return
+	}
+
+	snapshots, err := c.datasource.Snapshots()
+	if err != nil {
+		return
+	}
+
+	ids := make([]int64, len(snapshots))
+	for i, snapshot := range snapshots {
+		ids[i] = snapshot.ID
+	}
+
+	if !deleteSnapshots(c.context, c.datasource, ids, time.Time{}) {
+		c.datasource.AddError()
+	}
+}
+
+// deleteArchives removes the archive directory for this database.
+func (c *MonitorTerminate) deleteArchives() {
+	dir := history.ArchiveDir(c.datasource.GetArchive(), c.datasource.GetCluster(), c.datasource.GetDBName())
+	if c.context.DryRun || c.context.Verbose {
+		log.Printf("Remove: %s\n", dir)
+	}
+
+	if !c.context.DryRun {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Printf("ERROR: Database %s, failed to remove archives, error: %v\n", c.datasource.GetName(), err)
+		}
+	}
+}
+
 // DropTables will drop the tables required to monitor activity
 func (c *MonitorTerminate) dropTables() {
 	for _, table := range StatsTables {
-		c.dropTable(table)
+		c.dropTable("pgmaven_" + table)
 	}
+	c.dropTable(dbutils.SnapshotCatalog)
 }
 
 func (c *MonitorTerminate) dropTable(tableName string) {
-	dropStatement := fmt.Sprintf("DROP TABLE IF EXISTS pgmaven_%s;", tableName)
+	dropStatement := fmt.Sprintf("DROP TABLE IF EXISTS %s;", tableName)
 
 	if c.context.DryRun || c.context.Verbose {
 		log.Println(dropStatement)
@@ -38,7 +87,7 @@ func (c *MonitorTerminate) dropTable(tableName string) {
 	if !c.context.DryRun {
 		_, err := c.datasource.GetDatabase().Exec(dropStatement)
 		if err != nil {
-			log.Printf("ERROR: Database %s, dropTable table deletion failed with error: %s\n", c.datasource.GetDBName(), err)
+			log.Printf("ERROR: Database %s, dropTable table deletion failed with error: %s\n", c.datasource.GetName(), err)
 		}
 	}
 }
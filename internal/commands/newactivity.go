@@ -3,9 +3,16 @@ package commands
 import (
 	"database/sql"
 	"fmt"
+	"log"
 	"pgmaven/internal/dbutils"
+	"pgmaven/internal/history"
 	"pgmaven/internal/utils"
+	"slices"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type NewActivity struct {
@@ -18,81 +25,160 @@ func (c *NewActivity) Init(context utils.Context, ds *dbutils.DataSource) {
 	c.context = context
 }
 
+// NewActivity reports the queries first observed, and the indexes first used, in the specified duration.
 func (c *NewActivity) Execute(args ...string) {
 	end := time.Now().Add(-c.context.DurationOffset)
 	start := end.Add(-c.context.Duration)
-	endClosest := c.datasource.GetClosest("pgmaven_pg_stat_statements", end).(time.Time)
-	startClosest := c.datasource.GetClosest("pgmaven_pg_stat_statements", start).(time.Time)
 
+	// The statements and indexes may be captured by different snapshots, so each has its own window
+	all := history.NewSource(c.datasource)
+
+	statements := history.Filter(all, "pg_stat_statements")
+	window, err := history.NewWindow(statements, start, end)
+	if err != nil {
+		log.Printf("ERROR: Database: %s, NewActivity: %v\n", c.datasource.GetName(), err)
+		return
+	}
+	snapshots, err := statements.Snapshots()
+	if err != nil {
+		return
+	}
 	if c.context.Verbose {
-		fmt.Printf("Analyze new queries from %v to %v\n", startClosest, endClosest)
-	}
-
-	newStatementQuery := `
-select pgu.usename, calls, mean_exec_time, total_exec_time, queryid, query, min(insert_dt)
-from
-	pgmaven_pg_stat_statements pgss, pg_user pgu
-where
-	pgss.userid = pgu.usesysid
-	and total_exec_time != 0     -- Ditch Explains and Prepares
-	and pgu.usename not in ('rdsrepladmin', 'rdsadmin', 'rdstopmgr')
-	and query not ilike '%pgmaven%'
-	and queryid in 
-(
-	select
-		distinct(queryid)
-	from
-		pgmaven_pg_stat_statements
-	where
-		insert_dt = $1
-except
-	select
-		distinct(queryid)
-	from
-		pgmaven_pg_stat_statements
-	where
-		insert_dt <= $2)
-group by pgu.usename, calls, mean_exec_time, total_exec_time, queryid, query
-order by min(insert_dt)`
-
-	_ = c.datasource.ExecuteQueryRows(newStatementQuery, []any{endClosest, startClosest}, newQueryProcessor, c)
-
-	newIndexQuery := `select 
-schemaname,
-relname AS tablename,
-indexrelname AS indexname,
-pg_relation_size(indexrelid) AS index_size,
-pg_table_size(indexrelid) as table_size
-FROM pg_stat_user_indexes
-where 	indexrelname in 
-(
-SELECT
-ppsui.indexrelname
-FROM pgmaven_pg_stat_user_indexes ppsui
-JOIN pg_catalog.pg_index i using (indexrelid)
-JOIN pg_catalog.pg_indexes i2 ON ppsui.schemaname = i2.schemaname AND ppsui.relname = i2.tablename AND ppsui.indexrelname = i2.indexname
-WHERE ppsui.idx_scan != 0                -- has never been scanned
-and ppsui.schemaname = $1
-AND i2.indexdef like '%%USING btree%%'   -- only want BTREE indexes
-and insert_dt = $2
-AND 0 <>ALL (i.indkey)                 -- no index column is an expression
-AND NOT i.indisunique                  -- is not a UNIQUE index
-AND NOT EXISTS                         -- does not enforce a constraint
-(SELECT 1 FROM pg_catalog.pg_constraint c
-	WHERE c.conindid = ppsui.indexrelid)
-AND NOT EXISTS                         -- is not an index partition
-(SELECT 1 FROM pg_catalog.pg_inherits AS inh
-	WHERE inh.inhrelid = ppsui.indexrelid)
-except 
+		fmt.Fprintf(c.context.Writer(), "Analyze new queries from %v\n", window)
+	}
+	c.newStatements(statements, snapshots, window)
+
+	indexes := history.Filter(all, "pg_stat_user_indexes")
+	if window, err = history.NewWindow(indexes, start, end); err != nil {
+		log.Printf("ERROR: Database: %s, NewActivity: %v\n", c.datasource.GetName(), err)
+		return
+	}
+	if c.context.Verbose {
+		fmt.Fprintf(c.context.Writer(), "Analyze new index use from %v\n", window)
+	}
+	c.newIndexes(indexes, window)
+}
+
+// newStatements reports the statements present at the end of the window that were not present in any snapshot prior to the window.
+func (c *NewActivity) newStatements(source history.Source, snapshots []dbutils.SnapshotInfo, window *history.Window) {
+	existing := make(map[int64]bool)
+	err := source.Scan("pg_stat_statements", history.SnapshotIDs(snapshots, 0, window.Start.ID), []string{"queryid"}, func(snapshotID int64, row history.Row) {
+		existing[row.Int("queryid")] = true
+	})
+	if err != nil {
+		return
+	}
+
+	current, err := history.Rows(source, "pg_stat_statements", window.End.ID, []string{"userid", "calls", "mean_exec_time", "total_exec_time", "queryid", "query"})
+	if err != nil {
+		return
+	}
+
+	userNames, err := source.UserNames()
+	if err != nil {
+		return
+	}
+
+	added := make([]history.Row, 0)
+	for _, row := range current {
+		if existing[row.Int("queryid")] ||
+			row.Float("total_exec_time") == 0 || // Ditch Explains and Prepares
+			slices.Contains(dbutils.InternalUsers, userNames[row.Int("userid")]) ||
+			strings.Contains(strings.ToLower(row.String("query")), "pgmaven") {
+			continue
+		}
+		added = append(added, row)
+	}
+
+	// Determine when each statement was first observed
+	firstSeen := make(map[int64]time.Time)
+	times := make(map[int64]time.Time)
+	for _, snapshot := range snapshots {
+		times[snapshot.ID] = snapshot.Time
+	}
+	err = source.Scan("pg_stat_statements", history.SnapshotIDs(snapshots, window.Start.ID+1, window.End.ID), []string{"queryid"}, func(snapshotID int64, row history.Row) {
+		queryID := row.Int("queryid")
+		if seen, ok := firstSeen[queryID]; !ok || times[snapshotID].Before(seen) {
+			firstSeen[queryID] = times[snapshotID]
+		}
+	})
+	if err != nil {
+		return
+	}
+
+	sort.SliceStable(added, func(i, j int) bool {
+		return firstSeen[added[i].Int("queryid")].Before(firstSeen[added[j].Int("queryid")])
+	})
+
+	for i, row := range added {
+		if i == 0 {
+			fmt.Fprintln(c.context.Writer(), "username,calls,mean_exec_time,total_exec_time,queryid,insert_dt,query")
+		}
+		fmt.Fprintf(c.context.Writer(), "%s,%d,%.2f,%.2f,%d,%v,%s\n", userNames[row.Int("userid")], row.Int("calls"), row.Float("mean_exec_time"),
+			row.Float("total_exec_time"), row.Int("queryid"), firstSeen[row.Int("queryid")], utils.QuoteAlways(row.String("query")))
+	}
+}
+
+// newIndexes reports the indexes that have been used at the end of the window but had not been used at the start.
+func (c *NewActivity) newIndexes(source history.Source, window *history.Window) {
+	columns := []string{"indexrelid", "schemaname", "relname", "indexrelname", "idx_scan"}
+	used := func(snapshotID int64) (map[int64]history.Row, error) {
+		ret := make(map[int64]history.Row)
+		err := source.Scan("pg_stat_user_indexes", []int64{snapshotID}, columns, func(snapshotID int64, row history.Row) {
+			if row.Int("idx_scan") != 0 && c.datasource.SchemaMatches(row.String("schemaname")) {
+				ret[row.Int("indexrelid")] = row
+			}
+		})
+		return ret, err
+	}
+
+	startUsed, err := used(window.Start.ID)
+	if err != nil {
+		return
+	}
+	endUsed, err := used(window.End.ID)
+	if err != nil {
+		return
+	}
+
+	indexIDs := make([]int64, 0)
+	for indexID := range endUsed {
+		if _, ok := startUsed[indexID]; !ok {
+			indexIDs = append(indexIDs, indexID)
+		}
+	}
+	if len(indexIDs) == 0 {
+		return
+	}
+
+	// The index definitions and sizes are only available from the live catalog
+	if c.datasource.IsOffline() {
+		added := make([]history.Row, 0, len(indexIDs))
+		for _, indexID := range indexIDs {
+			added = append(added, endUsed[indexID])
+		}
+		sort.Slice(added, func(i, j int) bool {
+			return added[i].Key([]string{"schemaname", "relname", "indexrelname"}) < added[j].Key([]string{"schemaname", "relname", "indexrelname"})
+		})
+		fmt.Fprintln(c.context.Writer(), "schema,table,index")
+		for _, row := range added {
+			fmt.Fprintf(c.context.Writer(), "%s,%s,%s\n", row.String("schemaname"), row.String("relname"), row.String("indexrelname"))
+		}
+		return
+	}
+
+	newIndexQuery := `
 SELECT
-ppsui.indexrelname
-FROM pgmaven_pg_stat_user_indexes ppsui
+	ppsui.schemaname,
+	ppsui.relname AS tablename,
+	ppsui.indexrelname AS indexname,
+	pg_relation_size(ppsui.indexrelid) AS index_size,
+	pg_table_size(ppsui.indexrelid) as table_size
+FROM pg_stat_user_indexes ppsui
 JOIN pg_catalog.pg_index i using (indexrelid)
 JOIN pg_catalog.pg_indexes i2 ON ppsui.schemaname = i2.schemaname AND ppsui.relname = i2.tablename AND ppsui.indexrelname = i2.indexname
-WHERE ppsui.idx_scan != 0                -- has never been scanned
-and ppsui.schemaname = $3
-AND i2.indexdef like '%%USING btree%%'   -- only want BTREE indexes
-and insert_dt = $4
+WHERE ppsui.indexrelid::bigint = ANY($1)
+AND i2.indexdef like '%USING btree%'     -- only want BTREE indexes
 AND 0 <>ALL (i.indkey)                 -- no index column is an expression
 AND NOT i.indisunique                  -- is not a UNIQUE index
 AND NOT EXISTS                         -- does not enforce a constraint
@@ -101,36 +187,14 @@ AND NOT EXISTS                         -- does not enforce a constraint
 AND NOT EXISTS                         -- is not an index partition
 (SELECT 1 FROM pg_catalog.pg_inherits AS inh
 	WHERE inh.inhrelid = ppsui.indexrelid)
-	)
+ORDER BY 1, 2, 3
 `
 
-	endClosest = c.datasource.GetClosest("pgmaven_pg_stat_user_indexes", end).(time.Time)
-	startClosest = c.datasource.GetClosest("pgmaven_pg_stat_user_indexes", start).(time.Time)
-
-	if c.context.Verbose {
-		fmt.Printf("Analyze new index use from %v to %v\n", startClosest, endClosest)
-	}
-
-	_ = c.datasource.ExecuteQueryRows(newIndexQuery, []any{c.datasource.GetSchema(), endClosest, c.datasource.GetSchema(), startClosest}, newIndexProcessor, c)
-
-}
-
-func newQueryProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
-	userName := string((*values[0].(*interface{})).([]uint8))
-	calls := (*values[1].(*interface{})).(int64)
-	mean_exec_time := (*values[2].(*interface{})).(float64)
-	total_exec_time := (*values[3].(*interface{})).(float64)
-	queryId := (*values[4].(*interface{})).(int64)
-	queryText := (*values[5].(*interface{})).(string)
-	minInsertDt := (*values[6].(*interface{})).(time.Time)
-
-	if rowNumber == 1 {
-		fmt.Println("username,calls,mean_exec_time,total_exec_time,queryid,insert_dt,query")
-	}
-	fmt.Printf("%s,%d,%.2f,%.2f,%d,%v,%s\n", userName, calls, mean_exec_time, total_exec_time, queryId, minInsertDt, utils.QuoteAlways(queryText))
+	_ = c.datasource.ExecuteQueryRows(newIndexQuery, []any{pq.Array(indexIDs)}, newIndexProcessor, c)
 }
 
 func newIndexProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
+	c := self.(*NewActivity)
 	schemaName := string((*values[0].(*interface{})).([]uint8))
 	tableName := string((*values[1].(*interface{})).([]uint8))
 	indexName := string((*values[2].(*interface{})).([]uint8))
@@ -138,7 +202,7 @@ func newIndexProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []in
 	tableSize := (*values[4].(*interface{})).(int64)
 
 	if rowNumber == 1 {
-		fmt.Println("schema,table,index,indexSize,tableSize")
+		fmt.Fprintln(c.context.Writer(), "schema,table,index,indexSize,tableSize")
 	}
-	fmt.Printf("%s,%s,%s,%d,%d\n", schemaName, tableName, indexName, indexSize, tableSize)
+	fmt.Fprintf(c.context.Writer(), "%s,%s,%s,%d,%d\n", schemaName, tableName, indexName, indexSize, tableSize)
 }
@@ -9,10 +9,12 @@ import (
 
 type QueryRow struct {
 	datasource *dbutils.DataSource
+	context    utils.Context
 }
 
 func (c *QueryRow) Init(context utils.Context, ds *dbutils.DataSource) {
 	c.datasource = ds
+	c.context = context
 }
 
 func (c *QueryRow) Execute(args ...string) {
@@ -23,8 +25,8 @@ func (c *QueryRow) Execute(args ...string) {
 	}
 	result, err := c.datasource.ExecuteQueryRow(query, s)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Query '%s' failed with error: %v\n", c.datasource.GetDBName(), query, err)
+		log.Printf("ERROR: Database: %s, Query '%s' failed with error: %v\n", c.datasource.GetName(), query, err)
 		return
 	}
-	fmt.Printf("Database: %s, Query '%s', result: %v\n", c.datasource.GetDBName(), query, result)
+	fmt.Fprintf(c.context.Writer(), "Database: %s, Query '%s', result: %v\n", c.datasource.GetName(), query, result)
 }
@@ -3,6 +3,7 @@ package commands
 import (
 	"database/sql"
 	"fmt"
+	"io"
 	"log"
 	"pgmaven/internal/dbutils"
 	"pgmaven/internal/utils"
@@ -11,54 +12,58 @@ import (
 
 type QueryRows struct {
 	datasource *dbutils.DataSource
+	context    utils.Context
 }
 
 func (c *QueryRows) Init(context utils.Context, ds *dbutils.DataSource) {
 	c.datasource = ds
+	c.context = context
 }
 
 func (c *QueryRows) Execute(args ...string) {
 	query := utils.OptionallyFromFile(args...)
-	err := c.datasource.ExecuteQueryRows(query, nil, dump, c)
+	err := c.datasource.ExecuteQueryRows(query, nil, dump, c.context.Writer())
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Query '%s' failed, error: %v\n", c.datasource.GetDBName(), args[0], err)
+		log.Printf("ERROR: Database: %s, Query '%s' failed, error: %v\n", c.datasource.GetName(), args[0], err)
 	}
 }
 
-func dump(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, sefl any) {
+// dump outputs each row (preceded by a header) to the io.Writer provided.
+func dump(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
+	w := self.(io.Writer)
 	if rowNumber == 1 {
 		for i, columnType := range columnTypes {
 			if i != 0 {
-				fmt.Print("\t")
+				fmt.Fprint(w, "\t")
 			}
-			fmt.Print(columnType.Name())
+			fmt.Fprint(w, columnType.Name())
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
 	}
 	for i := 0; i < len(values); i++ {
 		if i != 0 {
-			fmt.Print("\t")
+			fmt.Fprint(w, "\t")
 		}
-		printValue(values[i].(*interface{}))
+		printValue(w, values[i].(*interface{}))
 	}
-	fmt.Println()
+	fmt.Fprintln(w)
 }
 
-func printValue(pval *interface{}) {
+func printValue(w io.Writer, pval *interface{}) {
 	switch v := (*pval).(type) {
 	case nil:
-		fmt.Print("NULL")
+		fmt.Fprint(w, "NULL")
 	case bool:
 		if v {
-			fmt.Print("1")
+			fmt.Fprint(w, "1")
 		} else {
-			fmt.Print("0")
+			fmt.Fprint(w, "0")
 		}
 	case []byte:
-		fmt.Print(string(v))
+		fmt.Fprint(w, string(v))
 	case time.Time:
-		fmt.Print(v.Format("2006-01-02 15:04:05.999"))
+		fmt.Fprint(w, v.Format("2006-01-02 15:04:05.999"))
 	default:
-		fmt.Print(v)
+		fmt.Fprint(w, v)
 	}
 }
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/history"
+
+	"github.com/lib/pq"
+)
+
+// repositoryTables records the tables (and columns) known to exist in the repository, so the DDL is only executed once.
+var repositoryTables sync.Map
+
+// snapshotToRepository reads the Statistics tables from the monitored database and stores them in the repository (in a single
+// transaction) referencing a new snapshot in the catalog.
+func (c *Snapshot) snapshotToRepository() {
+	ds := c.datasource
+	archive, err := captureSnapshot(ds, c.tables)
+	if err != nil {
+		log.Printf("ERROR: Database: %s, Snapshot %v\n", ds.GetName(), err)
+		return
+	}
+	captures := archive.Tables
+
+	if c.context.DryRun {
+		for _, captured := range captures {
+			log.Printf("Snapshot: %s, %d rows to repository\n", captured.Table, len(captured.Rows))
+		}
+		return
+	}
+
+	repository := ds.GetRepository()
+	for _, captured := range captures {
+		if err := ensureRepositoryTable(repository, captured); err != nil {
+			log.Printf("ERROR: Database: %s, Snapshot failed to create repository table for '%s', error: %v\n", ds.GetName(), captured.Table, err)
+			return
+		}
+	}
+
+	tx, err := repository.GetDatabase().Begin()
+	if err != nil {
+		log.Printf("ERROR: Database: %s, Snapshot failed to start repository transaction, error: %v\n", ds.GetName(), err)
+		return
+	}
+	defer tx.Rollback()
+
+	catalogInsert := fmt.Sprintf(`INSERT INTO %s (cluster_name, database_name, server_version, postmaster_start_time, stats_reset, statements_reset, snapshot_tables)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING snapshot_id`, dbutils.SnapshotCatalog)
+	var snapshotID int64
+	err = tx.QueryRow(catalogInsert, ds.GetCluster(), ds.GetDBName(), archive.Snapshot.ServerVersion,
+		archive.Snapshot.PostmasterStart, archive.Snapshot.StatsReset, archive.Snapshot.StatementsReset, c.snapshotTables()).Scan(&snapshotID)
+	if err != nil {
+		log.Printf("ERROR: Database: %s, Snapshot failed to record snapshot, has MonitorInitialize been run? error: %v\n", ds.GetName(), err)
+		return
+	}
+
+	for _, captured := range captures {
+		if err := copyToRepository(tx, captured, snapshotID); err != nil {
+			log.Printf("ERROR: Database: %s, Snapshot failed to store '%s', error: %v\n", ds.GetName(), captured.Table, err)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("ERROR: Database: %s, Snapshot failed to commit, error: %v\n", ds.GetName(), err)
+		return
+	}
+	c.record(snapshotID, captures)
+
+	if c.context.Verbose {
+		fmt.Fprintf(c.context.Writer(), "Database: %s, Snapshot: %d (repository)\n", ds.GetName(), snapshotID)
+	}
+}
+
+// ensureRepositoryTable creates the table in the repository (or adds any missing columns, e.g. from a newer server version).
+func ensureRepositoryTable(repository *dbutils.DataSource, captured history.Capture) error {
+	signature := captured.Table
+	for _, col := range captured.Columns {
+		signature += "," + col.Name + " " + col.Type
+	}
+	if _, done := repositoryTables.Load(signature); done {
+		return nil
+	}
+
+	table := "pgmaven_" + captured.Table
+	statements := []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (snapshot_id BIGINT, insert_dt TIMESTAMP DEFAULT NOW())", table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS pgmaven_ix_%s_snapshot_id ON %s(snapshot_id)", captured.Table, table),
+	}
+	for _, col := range captured.Columns {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, pq.QuoteIdentifier(col.Name), col.Type))
+	}
+
+	tx, err := repository.GetDatabase().Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize DDL from concurrent agents
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('pgmaven_repository'))`); err != nil {
+		return err
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("'%s' failed with error: %v", statement, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	repositoryTables.Store(signature, true)
+
+	return nil
+}
+
+func copyToRepository(tx *sql.Tx, captured history.Capture, snapshotID int64) error {
+	columns := []string{"snapshot_id"}
+	for _, col := range captured.Columns {
+		columns = append(columns, col.Name)
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("pgmaven_"+captured.Table, columns...))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, row := range captured.Rows {
+		if _, err := stmt.Exec(append([]any{snapshotID}, row...)...); err != nil {
+			return err
+		}
+	}
+	_, err = stmt.Exec()
+
+	return err
+}
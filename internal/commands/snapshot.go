@@ -1,24 +1,188 @@
 package commands
 
 import (
+	"database/sql"
+	"fmt"
+	"log"
 	"pgmaven/internal/dbutils"
+	"pgmaven/internal/history"
 	"pgmaven/internal/utils"
+	"strings"
 )
 
 type Snapshot struct {
 	datasource *dbutils.DataSource
 	context    utils.Context
+	// tables to capture, nil for all the Statistics tables
+	tables []string
+	// SnapshotID and Rows (captured per Statistics table) record the outcome, SnapshotID is 0 if the snapshot was not taken
+	SnapshotID int64
+	Rows       map[string]int64
 }
 
 func (c *Snapshot) Init(context utils.Context, ds *dbutils.DataSource) {
 	c.datasource = ds
 	c.context = context
+	c.SnapshotID = 0
+	c.Rows = make(map[string]int64)
 }
 
+// Snapshot the Statistics tables.  A row is recorded in the snapshot catalog and all the tables are copied in a single
+// transaction referencing it, so every table in a snapshot reflects the same point in time.  The optional argument is the
+// (comma separated) list of tables to capture, by default all the Statistics tables are captured.
 func (c *Snapshot) Execute(args ...string) {
-	snapShotter := new(SnapshotTable)
-	snapShotter.Init(c.context, c.datasource)
-	for _, table := range StatsTables {
-		snapShotter.Execute(table)
+	if len(args) != 0 {
+		var err error
+		if c.tables, err = ParseStatsTables(args[0]); err != nil {
+			log.Printf("ERROR: Database: %s, Snapshot %v\n", c.datasource.GetName(), err)
+			return
+		}
 	}
+
+	if c.datasource.GetArchive() != "" {
+		c.snapshotToArchive()
+		return
+	}
+	if c.datasource.GetRepository() != nil {
+		c.snapshotToRepository()
+		return
+	}
+
+	catalogInsert := fmt.Sprintf(`INSERT INTO %s (cluster_name, database_name, server_version, postmaster_start_time, stats_reset, snapshot_tables)
+	SELECT $1, current_database(), current_setting('server_version'), pg_postmaster_start_time(), (SELECT stats_reset FROM pg_stat_database WHERE datname = current_database()), $2
+	RETURNING snapshot_id`, dbutils.SnapshotCatalog)
+	// pg_stat_statements_info only exists in PostgreSQL 14+ (and if the extension is installed)
+	statementsReset := fmt.Sprintf(`UPDATE %s SET statements_reset = (SELECT stats_reset FROM pg_stat_statements_info) WHERE snapshot_id = $1`, dbutils.SnapshotCatalog)
+
+	if c.context.DryRun {
+		log.Println(catalogInsert)
+		log.Println(statementsReset)
+		for _, table := range c.captured() {
+			log.Println(snapshotStatement(table))
+		}
+		return
+	}
+
+	tx, err := c.datasource.GetDatabase().Begin()
+	if err != nil {
+		log.Printf("ERROR: Database: %s, Snapshot failed to start transaction, error: %v\n", c.datasource.GetName(), err)
+		return
+	}
+	defer tx.Rollback()
+
+	if c.context.Verbose {
+		log.Println(catalogInsert)
+	}
+	var snapshotID int64
+	if err := tx.QueryRow(catalogInsert, c.datasource.GetCluster(), c.snapshotTables()).Scan(&snapshotID); err != nil {
+		log.Printf("ERROR: Database: %s, Snapshot failed to record snapshot, has MonitorInitialize been run? error: %v\n", c.datasource.GetName(), err)
+		return
+	}
+
+	c.execute(tx, statementsReset, snapshotID, false)
+	rows := make(map[string]int64)
+	for _, table := range c.captured() {
+		if count, ok := c.execute(tx, snapshotStatement(table), snapshotID, true); ok {
+			rows[table] = count
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("ERROR: Database: %s, Snapshot failed to commit, error: %v\n", c.datasource.GetName(), err)
+		return
+	}
+	c.SnapshotID, c.Rows = snapshotID, rows
+
+	if c.context.Verbose {
+		fmt.Fprintf(c.context.Writer(), "Database: %s, Snapshot: %d\n", c.datasource.GetName(), snapshotID)
+	}
+}
+
+// snapshotToArchive writes the Statistics tables to a compressed file in the archive directory, nothing is written to any database.
+func (c *Snapshot) snapshotToArchive() {
+	ds := c.datasource
+	archive, err := captureSnapshot(ds, c.tables)
+	if err != nil {
+		log.Printf("ERROR: Database: %s, Snapshot %v\n", ds.GetName(), err)
+		return
+	}
+
+	if c.context.DryRun {
+		for _, captured := range archive.Tables {
+			log.Printf("Snapshot: %s, %d rows to archive\n", captured.Table, len(captured.Rows))
+		}
+		return
+	}
+
+	name, err := history.WriteArchive(ds.GetArchive(), archive)
+	if err != nil {
+		log.Printf("ERROR: Database: %s, Snapshot failed to write archive, error: %v\n", ds.GetName(), err)
+		return
+	}
+	c.record(archive.Snapshot.ID, archive.Tables)
+
+	if c.context.Verbose {
+		fmt.Fprintf(c.context.Writer(), "Database: %s, Snapshot: %d (%s)\n", ds.GetName(), archive.Snapshot.ID, name)
+	}
+}
+
+// captured returns the tables to capture.
+func (c *Snapshot) captured() []string {
+	if c.tables == nil {
+		return StatsTables[:]
+	}
+
+	return c.tables
+}
+
+// snapshotTables returns the value recorded in the catalog for the tables captured, NULL if all the tables are captured.
+func (c *Snapshot) snapshotTables() any {
+	if c.tables == nil {
+		return nil
+	}
+
+	return strings.Join(c.tables, ",")
+}
+
+// record the outcome of a snapshot taken from the captured tables.
+func (c *Snapshot) record(snapshotID int64, captures []history.Capture) {
+	c.SnapshotID = snapshotID
+	for _, captured := range captures {
+		c.Rows[captured.Table] = int64(len(captured.Rows))
+	}
+}
+
+func snapshotStatement(table string) string {
+	return fmt.Sprintf("INSERT INTO pgmaven_%s SELECT *, NOW(), $1 FROM %s", table, table)
+}
+
+// execute runs the statement within a savepoint, so that a failure (e.g. pg_stat_statements is not installed) does not
+// abort the entire snapshot.  The number of rows affected is returned if the statement succeeded.
+func (c *Snapshot) execute(tx *sql.Tx, statement string, snapshotID int64, report bool) (int64, bool) {
+	if c.context.Verbose {
+		log.Println(statement)
+	}
+
+	if _, err := tx.Exec("SAVEPOINT pgmaven_snapshot"); err != nil {
+		log.Printf("ERROR: Database: %s, Snapshot savepoint failed with error: %v\n", c.datasource.GetName(), err)
+		return 0, false
+	}
+
+	result, err := tx.Exec(statement, snapshotID)
+	if err != nil {
+		if report {
+			log.Printf("ERROR: Database: %s, Snapshot '%s' failed with error: %v\n", c.datasource.GetName(), statement, err)
+		}
+		if _, err := tx.Exec("ROLLBACK TO SAVEPOINT pgmaven_snapshot"); err != nil {
+			log.Printf("ERROR: Database: %s, Snapshot rollback to savepoint failed with error: %v\n", c.datasource.GetName(), err)
+		}
+		return 0, false
+	}
+
+	if _, err := tx.Exec("RELEASE SAVEPOINT pgmaven_snapshot"); err != nil {
+		log.Printf("ERROR: Database: %s, Snapshot release savepoint failed with error: %v\n", c.datasource.GetName(), err)
+	}
+
+	count, _ := result.RowsAffected()
+	return count, true
 }
@@ -0,0 +1,67 @@
+package commands
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/history"
+	"pgmaven/internal/utils"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotDryRun(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	snapshot := Snapshot{}
+	snapshot.Init(utils.Context{DryRun: true}, &dbutils.DataSource{})
+	snapshot.Execute("pg_stat_activity, pg_stat_statements")
+
+	// The catalog row is inserted first and each table references it
+	lines := output.String()
+	insert := strings.Index(lines, "INSERT INTO "+dbutils.SnapshotCatalog)
+	activity := strings.Index(lines, "INSERT INTO pgmaven_pg_stat_activity SELECT *, NOW(), $1 FROM pg_stat_activity")
+	statements := strings.Index(lines, "INSERT INTO pgmaven_pg_stat_statements SELECT *, NOW(), $1 FROM pg_stat_statements")
+	if insert == -1 || activity < insert || statements < activity || strings.Contains(lines, "pg_stat_user_tables") {
+		t.Fatalf("unexpected snapshot statements:\n%s", lines)
+	}
+	if snapshot.SnapshotID != 0 {
+		t.Fatalf("a dry run should not record a snapshot")
+	}
+	if tables := snapshot.snapshotTables(); tables != "pg_stat_activity,pg_stat_statements" {
+		t.Fatalf("unexpected snapshot_tables %v", tables)
+	}
+
+	output.Reset()
+	snapshot.Init(utils.Context{DryRun: true}, &dbutils.DataSource{})
+	snapshot.tables = nil
+	snapshot.Execute()
+	if snapshot.snapshotTables() != nil || len(snapshot.captured()) != len(StatsTables) {
+		t.Fatalf("all the tables should be captured by default")
+	}
+	for _, table := range StatsTables {
+		if !strings.Contains(output.String(), snapshotStatement(table)) {
+			t.Fatalf("missing statement for %s:\n%s", table, output.String())
+		}
+	}
+
+	output.Reset()
+	snapshot.Init(utils.Context{DryRun: true}, &dbutils.DataSource{})
+	snapshot.Execute("pg_stat_unknown")
+	if !strings.Contains(output.String(), "unknown Statistics table") || strings.Contains(output.String(), "INSERT") {
+		t.Fatalf("expected error for an unknown table:\n%s", output.String())
+	}
+}
+
+func TestSnapshotRecord(t *testing.T) {
+	snapshot := Snapshot{}
+	snapshot.Init(utils.Context{}, &dbutils.DataSource{})
+	snapshot.record(42, []history.Capture{{Table: "pg_stat_activity", Rows: make([][]any, 3)}, {Table: "pg_stat_statements"}})
+
+	if snapshot.SnapshotID != 42 || snapshot.Rows["pg_stat_activity"] != 3 || snapshot.Rows["pg_stat_statements"] != 0 || len(snapshot.Rows) != 2 {
+		t.Fatalf("unexpected outcome %d %v", snapshot.SnapshotID, snapshot.Rows)
+	}
+}
@@ -1,29 +1,35 @@
 package commands
 
 import (
+	"fmt"
 	"log"
 	"pgmaven/internal/dbutils"
+	"pgmaven/internal/history"
 	"pgmaven/internal/utils"
+
+	"github.com/lib/pq"
 )
 
 type Summary struct {
 	datasource *dbutils.DataSource
+	context    utils.Context
 }
 
 func (c *Summary) Init(context utils.Context, ds *dbutils.DataSource) {
 	c.datasource = ds
+	c.context = context
 }
 
 func (c *Summary) Execute(args ...string) {
-	isSetupQuery := `
-	select count(*) FROM information_schema.tables where table_schema = $1 and table_type = 'BASE TABLE' and table_name ilike 'PGMAVEN_%'
-	`
-	count, err := c.datasource.ExecuteQueryRow(isSetupQuery, []any{c.datasource.GetSchema()})
-	if err != nil {
-		log.Fatalf("Summary: Failed to check for existence of pgmaven tables, err: %v\n", err)
+	var snapshots []dbutils.SnapshotInfo
+	if c.datasource.GetArchive() != "" || c.datasource.HasSnapshots() {
+		var err error
+		if snapshots, err = history.NewSource(c.datasource).Snapshots(); err != nil {
+			log.Fatalf("Summary: Failed to list snapshots, err: %v\n", err)
+		}
 	}
-	if count.(int64) == 0 {
-		log.Fatalf("Summary: No pgmaven tables exist, has MonitorInitialize been run?\n")
+	if len(snapshots) == 0 {
+		log.Fatalf("Summary: No snapshots exist, has MonitorInitialize been run?\n")
 	}
 
 	query := `
@@ -37,12 +43,21 @@ func (c *Summary) Execute(args ...string) {
 	union all
 	select 'pg_stat_statements', CASE WHEN setting ilike('%pg_stat_statements%') THEN 'Enabled' ELSE 'Disabled' END from pg_settings where name = 'shared_preload_libraries'
 	union all
-	select 'TableCount', count(*)::text FROM information_schema.tables where table_schema = $2 and table_type = 'BASE TABLE' and table_name not ilike 'PGMAVEN_%'
-	union all
-	select 'IndexCount', count(*)::text from pg_indexes where schemaname = $2
+	select 'Schemas', array_to_string($2::text[], ',')
 	union all
-	select 'TrackingMin', min(insert_dt)::text from pgmaven_pg_stat_statements
+	select 'TableCount', count(*)::text FROM information_schema.tables where table_schema = ANY($2) and table_type = 'BASE TABLE' and table_name not ilike 'PGMAVEN_%'
 	union all
-	select 'TrackingMax', max(insert_dt)::text from pgmaven_pg_stat_statements`
-	c.datasource.ExecuteQueryRows(query, []any{c.datasource.GetDBName(), c.datasource.GetSchema()}, dump, c)
+	select 'IndexCount', count(*)::text from pg_indexes where schemaname = ANY($2)`
+	c.datasource.ExecuteQueryRows(query, []any{c.datasource.GetDBName(), pq.Array(c.datasource.GetSchemas())}, dump, c.context.Writer())
+
+	// The snapshots may be stored in a repository or an archive
+	w := c.context.Writer()
+	fmt.Fprintf(w, "TrackingMin\t%s\n", snapshots[0].Time.Format("2006-01-02 15:04:05.999"))
+	fmt.Fprintf(w, "TrackingMax\t%s\n", snapshots[len(snapshots)-1].Time.Format("2006-01-02 15:04:05.999"))
+	fmt.Fprintf(w, "Snapshots\t%d\n", len(snapshots))
+	if c.datasource.GetArchive() != "" {
+		fmt.Fprintf(w, "Archive\t%s\n", history.ArchiveDir(c.datasource.GetArchive(), c.datasource.GetCluster(), c.datasource.GetDBName()))
+	} else if c.datasource.GetRepository() != nil {
+		fmt.Fprintf(w, "Repository\t%s\n", c.datasource.GetCluster())
+	}
 }
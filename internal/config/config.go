@@ -0,0 +1,150 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the content of the configuration file, a set of named profiles each of which provides values for the command line
+// options (keyed by the option name, e.g. host, tunnelHost, schema, duration).
+type Config struct {
+	// Default is the profile used if --profile is not specified
+	Default  string                    `yaml:"default"`
+	Profiles map[string]map[string]any `yaml:"profiles"`
+}
+
+var (
+	configFile string
+	profile    string
+)
+
+// Init registers the --config and --profile options.
+func Init() {
+	flag.StringVar(&configFile, "config", "", "configuration file with named profiles (default: '~/.config/pgmaven/config.yaml')")
+	flag.StringVar(&profile, "profile", "", "profile from the configuration file to use")
+}
+
+// DefaultFile returns the location of the configuration file used if --config is not specified.
+func DefaultFile() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(homeDir, ".config", "pgmaven", "config.yaml")
+}
+
+// Load reads the configuration file.
+func Load(name string) (*Config, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret Config
+	if err := yaml.Unmarshal(content, &ret); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration file '%s', error: %v", name, err)
+	}
+
+	return &ret, nil
+}
+
+// Apply sets the options from the selected profile (called once the command line has been parsed).  Options set on the command
+// line, or via the environment variables provided (keyed by option name), take precedence over the profile.
+func Apply(environment map[string]string, verbose bool) error {
+	name := configFile
+	if name == "" {
+		name = DefaultFile()
+		// The default configuration file is optional
+		if _, err := os.Stat(name); err != nil {
+			if profile != "" {
+				return fmt.Errorf("--profile '%s' requires a configuration file ('%s' not found)", profile, name)
+			}
+			return nil
+		}
+	}
+
+	config, err := Load(name)
+	if err != nil {
+		return err
+	}
+
+	selected := profile
+	if selected == "" {
+		selected = config.Default
+	}
+	if selected == "" {
+		return nil
+	}
+
+	values, ok := config.Profiles[selected]
+	if !ok {
+		return fmt.Errorf("profile '%s' not found in '%s'", selected, name)
+	}
+
+	return ApplyProfile(flag.CommandLine, values, environment, verbose)
+}
+
+// ApplyProfile sets the options in the flag set from the profile values, unless already set on the command line or via the environment.
+func ApplyProfile(flags *flag.FlagSet, values map[string]any, environment map[string]string, verbose bool) error {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		option := flags.Lookup(key)
+		if option == nil {
+			// Profiles are shared by pgmaven and pgagent, so may contain options not supported by this command
+			if verbose {
+				log.Printf("WARNING: Profile option '%s' is not supported, ignored\n", key)
+			}
+			continue
+		}
+		if option.Changed {
+			continue
+		}
+		if variable, ok := environment[key]; ok {
+			if _, set := os.LookupEnv(variable); set {
+				continue
+			}
+		}
+
+		if err := flags.Set(key, format(values[key])); err != nil {
+			return fmt.Errorf("profile option '%s', error: %v", key, err)
+		}
+	}
+
+	return nil
+}
+
+// format returns the value as a string suitable for the option, lists are comma separated and a leading ~/ is expanded.
+func format(value any) string {
+	switch v := value.(type) {
+	case []any:
+		parts := make([]string, len(v))
+		for i, part := range v {
+			parts[i] = format(part)
+		}
+		return strings.Join(parts, ",")
+	case string:
+		if strings.HasPrefix(v, "~/") {
+			if homeDir, err := os.UserHomeDir(); err == nil {
+				return filepath.Join(homeDir, v[2:])
+			}
+		}
+		return v
+	case nil:
+		return ""
+	}
+
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	flag "github.com/spf13/pflag"
+)
+
+func TestApplyProfile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config.yaml")
+	content := `
+default: dev
+profiles:
+  prod-eu:
+    host: db.eu.example.com
+    port: 6432
+    username: monitor
+    duration: 24h
+    apply-allow: [IndexDuplicate, TableAnalyze]
+    tunnelHost: bastion.eu.example.com
+`
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := Load(name)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if config.Default != "dev" || len(config.Profiles) != 1 {
+		t.Fatalf("unexpected configuration %+v", config)
+	}
+
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	host := flags.String("host", "localhost", "")
+	port := flags.Int("port", 5432, "")
+	username := flags.String("username", "", "")
+	duration := flags.Duration("duration", 0, "")
+	allow := flags.StringSlice("apply-allow", nil, "")
+	if err := flags.Parse([]string{"--port", "5433"}); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_PGUSER", "override")
+	err = ApplyProfile(flags, config.Profiles["prod-eu"], map[string]string{"username": "TEST_PGUSER"}, false)
+	if err != nil {
+		t.Fatalf("ApplyProfile failed: %v", err)
+	}
+
+	// The command line and environment take precedence, options not supported (tunnelHost) are ignored
+	if *host != "db.eu.example.com" || *port != 5433 || *username != "" || duration.String() != "24h0m0s" || len(*allow) != 2 {
+		t.Errorf("unexpected options host: %s, port: %d, username: %s, duration: %v, apply-allow: %v", *host, *port, *username, *duration, *allow)
+	}
+
+	invalid := flag.NewFlagSet("invalid", flag.ContinueOnError)
+	invalid.Int("port", 5432, "")
+	if err := ApplyProfile(invalid, map[string]any{"port": "abc"}, nil, false); err == nil {
+		t.Errorf("expected error for invalid port")
+	}
+}
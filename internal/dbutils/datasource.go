@@ -2,80 +2,165 @@ package dbutils
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
-	"os"
+	"net"
 	"strconv"
-	"time"
 
-	"github.com/elliotchance/sshtunnel"
-	"golang.org/x/crypto/ssh"
+	"pgmaven/internal/utils"
+
+	"github.com/lib/pq"
 )
 
 type DataSource struct {
-	tunnel   *sshtunnel.SSHTunnel
-	options  DBOptions
-	dbName   string
-	database *sql.DB
+	tunnels    *tunnels
+	tunnel     *Tunnel
+	tunnelErr  error
+	options    DBOptions
+	dbName     string
+	name       string
+	schemas    []string
+	database   *sql.DB
+	errorCount int
+	repository *DataSource
 }
 
-func PrivateKeyFileWithPassphrase(file string, passphrase string) ssh.AuthMethod {
-	buffer, err := os.ReadFile(file)
-	if err != nil {
-		return nil
+func NewDataSource(o DBOptions) *DataSource {
+	ret := &DataSource{}
+	ret.options = o
+	ret.tunnels = newTunnels()
+	// The tunnel is connected when the first database is opened, and shared by all the databases (and servers)
+	var err error
+	if ret.tunnel, err = ret.tunnels.get(o); err != nil {
+		log.Fatalf("ERROR: Failed to establish tunnel, error: %v\n", err)
 	}
 
-	key, err := ssh.ParsePrivateKeyWithPassphrase(buffer, []byte(passphrase))
-	if err != nil {
-		return nil
+	if o.Repository != "" {
+		db, err := sql.Open("postgres", o.Repository)
+		if err == nil {
+			err = db.Ping()
+		}
+		if err != nil {
+			log.Fatalf("ERROR: Failed to connect to repository, error: %v\n", err)
+		}
+		ret.repository = &DataSource{dbName: "repository", database: db}
 	}
 
-	return ssh.PublicKeys(key)
+	return ret
 }
 
-func NewDataSource(o DBOptions) *DataSource {
-	ret := &DataSource{}
-	ret.options = o
-	if o.TunnelHost != "" {
-		if o.TunnelPassphrase == "" {
-			log.Fatalf("ERROR: Required passphrase for Key file missing\n")
+// Clone returns a new DataSource with the same options (and tunnels) but its own database connection.
+// The connection pool for the repository (if any) is shared.
+func (ds *DataSource) Clone() *DataSource {
+	ret := &DataSource{tunnels: ds.tunnels, tunnel: ds.tunnel, options: ds.options}
+	if ds.repository != nil {
+		repository := *ds.repository
+		repository.errorCount = 0
+		ret.repository = &repository
+	}
+
+	return ret
+}
+
+// Open connects to the database previously set via SetTarget.
+func (ds *DataSource) Open() error {
+	ds.schemas = nil
+	if ds.tunnelErr != nil {
+		return fmt.Errorf("failed to establish tunnel, error: %v", ds.tunnelErr)
+	}
+	var err error
+	if ds.tunnel != nil {
+		if _, err = ds.tunnel.Connect(); err != nil {
+			return err
+		}
+		if _, err = ds.tunnel.Forward(ds.server()); err != nil {
+			return err
+		}
+	}
+	modes := ds.sslModes()
+	for i, mode := range modes {
+		if err = ds.open(formatParameters(ds.connectionParameters(mode))); err == nil || i == len(modes)-1 {
+			break
+		}
+		// Only retry if the server rejected the SSL mode
+		if mode == SSLModeRequire && !errors.Is(err, pq.ErrSSLNotSupported) {
+			break
 		}
+		ds.Close()
+	}
 
-		var err error
-		// Setup the tunnel, but do not yet start it yet.
-		ret.tunnel, err = sshtunnel.NewSSHTunnel(
-			// User and host of tunnel server, it will default to port 22
-			// if not specified.
-			o.TunnelUsername+"@"+o.TunnelHost,
+	return err
+}
 
-			PrivateKeyFileWithPassphrase(o.TunnelPrivateKeyFile, o.TunnelPassphrase),
+func (ds *DataSource) open(dataSourceString string) error {
+	db, err := sql.Open("postgres", dataSourceString)
+	if err != nil {
+		return fmt.Errorf("open failed with error: %v", err)
+	}
+	ds.database = db
 
-			// The destination host and port of the actual server.
-			o.Host+":"+strconv.Itoa(o.Port),
+	err = db.Ping()
+	if err != nil {
+		return fmt.Errorf("failed to ping database, error: %w", err)
+	}
 
-			// The local port you want to bind the remote port to.
-			// Specifying "0" will lead to a random port.
-			"0",
-		)
-		if err != nil {
-			log.Fatalf("ERROR: Failed to establish tunnel, error: %v\n", err)
-		}
+	return nil
+}
 
-		go ret.tunnel.Start()
-		time.Sleep(500 * time.Millisecond)
+// Close the connection to the database (if open).
+func (ds *DataSource) Close() {
+	if ds.database != nil {
+		ds.database.Close()
+		ds.database = nil
 	}
+}
 
-	return ret
+// CloseTunnels closes the SSH tunnels shared by this DataSource and its clones, this should be called once all the databases are processed.
+func (ds *DataSource) CloseTunnels() {
+	if ds.tunnels != nil {
+		ds.tunnels.close()
+	}
 }
 
 func (ds *DataSource) SetDBName(dbName string) {
-	ds.dbName = dbName
+	ds.dbName, ds.name = dbName, ""
+}
+
+// SetTarget sets the database (and the options used to connect to it), any error setting up the tunnel is reported by Open.
+func (ds *DataSource) SetTarget(target Target) {
+	ds.options, ds.dbName, ds.name = target.Options, target.DBName, target.Name
+	ds.tunnel, ds.tunnelErr = ds.tunnels.get(target.Options)
+}
+
+// server returns the host:port of the database server.
+func (ds *DataSource) server() string {
+	return net.JoinHostPort(ds.options.Host, strconv.Itoa(ds.options.Port))
+}
+
+// GetHost returns the database server host (or socket directory).
+func (ds *DataSource) GetHost() string {
+	return ds.options.Host
+}
+
+// GetPort returns the database server port.
+func (ds *DataSource) GetPort() int {
+	return ds.options.Port
 }
 
 func (ds *DataSource) GetDBName() string {
 	return ds.dbName
 }
 
+// GetName returns the name used to identify the database in the output, e.g. cluster/dbname for an inventory entry.
+func (ds *DataSource) GetName() string {
+	if ds.name != "" {
+		return ds.name
+	}
+
+	return ds.dbName
+}
+
 func (ds *DataSource) SetDatabase(db *sql.DB) {
 	ds.database = db
 }
@@ -84,28 +169,81 @@ func (ds *DataSource) GetDatabase() *sql.DB {
 	return ds.database
 }
 
-func (ds *DataSource) GetSchema() string {
-	return ds.options.Schema
+// GetErrorCount returns the number of database errors (including the repository) encountered since the last ResetErrorCount.
+func (ds *DataSource) GetErrorCount() int {
+	if ds.repository != nil {
+		return ds.errorCount + ds.repository.errorCount
+	}
+
+	return ds.errorCount
+}
+
+// AddError records an error that was not raised by a database query (e.g. a missing or unreadable snapshot archive).
+func (ds *DataSource) AddError() {
+	ds.errorCount++
 }
 
-func (ds *DataSource) GetDataSourceString() string {
-	password := ds.options.Password
-	if password == "" {
-		password = "''"
+func (ds *DataSource) ResetErrorCount() {
+	ds.errorCount = 0
+	if ds.repository != nil {
+		ds.repository.errorCount = 0
 	}
-	userClause := ""
-	if ds.options.Username != "" {
-		userClause = "user=" + ds.options.Username
+}
+
+// GetRepository returns the repository used to store snapshots, nil if snapshots are stored in the monitored database.
+func (ds *DataSource) GetRepository() *DataSource {
+	return ds.repository
+}
+
+// History returns the DataSource where the snapshots are stored, either the repository or the monitored database.
+func (ds *DataSource) History() *DataSource {
+	if ds.repository != nil {
+		return ds.repository
 	}
-	if ds.tunnel != nil {
-		return fmt.Sprintf("host=localhost port=%d %s "+
-			"password=%s dbname=%s sslmode=disable",
-			ds.tunnel.Local.Port, userClause, password, ds.dbName)
+
+	return ds
+}
+
+// GetArchive returns the directory of snapshot archives, "" if snapshots are stored in a database.
+func (ds *DataSource) GetArchive() string {
+	return ds.options.Archive
+}
+
+// IsOffline returns true if there is no connection to the monitored database, i.e. only the archive is being analyzed.
+func (ds *DataSource) IsOffline() bool {
+	return ds.database == nil
+}
+
+func (ds *DataSource) GetCluster() string {
+	return ds.options.GetCluster()
+}
+
+// GetDataSourceString returns the connection string for the database (with the first SSL mode attempted).
+func (ds *DataSource) GetDataSourceString() string {
+	return formatParameters(ds.connectionParameters(ds.sslModes()[0]))
+}
+
+// GetRedactedDataSourceString returns the connection string with the password masked, suitable for logging.
+func (ds *DataSource) GetRedactedDataSourceString() string {
+	parameters := ds.connectionParameters(ds.sslModes()[0])
+	if parameters["password"] != "" {
+		parameters["password"] = "********"
+	}
+
+	return formatParameters(parameters)
+}
+
+// sslModes returns the SSL modes to attempt (in order), prefer and allow are not supported by the driver so are mapped to require
+// and disable.
+func (ds *DataSource) sslModes() []string {
+	switch ds.options.SSLMode {
+	case SSLModePrefer, "":
+		return []string{SSLModeRequire, SSLModeDisable}
+	case SSLModeAllow:
+		return []string{SSLModeDisable, SSLModeRequire}
 	}
 
-	return fmt.Sprintf("host=%s port=%d %s "+
-		"password=%s dbname=%s sslmode=disable",
-		ds.options.Host, ds.options.Port, userClause, password, ds.dbName)
+	return []string{ds.options.SSLMode}
 }
 
 func (ds *DataSource) ExecuteQueryRows(query string, queryArgs []any, processor func(int, []*sql.ColumnType, []interface{}, any), processorArg any) error {
@@ -115,14 +253,16 @@ func (ds *DataSource) ExecuteQueryRows(query string, queryArgs []any, processor
 	rows, err = ds.database.Query(query, queryArgs...)
 
 	if err != nil {
-		fmt.Printf("ERROR: Database: %s, Failed to query database, error: %v\n", ds.GetDBName(), err)
+		ds.errorCount++
+		log.Printf("ERROR: Database: %s, Failed to query database, error: %v\n", ds.GetName(), err)
 		return err
 	}
 	defer rows.Close()
 
 	columnsTypes, err := rows.ColumnTypes()
 	if err != nil {
-		fmt.Printf("ERROR: Database: %s, Failed to get Column Types, error: %v\n", ds.GetDBName(), err)
+		ds.errorCount++
+		log.Printf("ERROR: Database: %s, Failed to get Column Types, error: %v\n", ds.GetName(), err)
 		return err
 	}
 
@@ -140,13 +280,15 @@ func (ds *DataSource) ExecuteQueryRows(query string, queryArgs []any, processor
 	for rows.Next() {
 		err = rows.Scan(vals...)
 		if err != nil {
-			fmt.Println(err)
+			ds.errorCount++
+			log.Printf("ERROR: Database: %s, Failed to scan row, error: %v\n", ds.GetName(), err)
 			continue
 		}
 		processor(rowNumber, columnsTypes, vals, processorArg)
 		rowNumber++
 	}
 	if rows.Err() != nil {
+		ds.errorCount++
 		return rows.Err()
 	}
 	return nil
@@ -158,7 +300,8 @@ func (ds *DataSource) ExecuteQueryRow(query string, queryArgs []any) (any, error
 	var result any
 	err := row.Scan(&result)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Failed to get row, error: %v\n", ds.GetDBName(), err)
+		ds.errorCount++
+		log.Printf("ERROR: Database: %s, Failed to get row, error: %v\n", ds.GetName(), err)
 		return "", err
 	}
 
@@ -169,83 +312,90 @@ func (ds *DataSource) Exec(statement string, statementArgs []any) (sql.Result, e
 	result, err := ds.database.Exec(statement, statementArgs...)
 
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Failed to get row, error: %v\n", ds.GetDBName(), err)
+		ds.errorCount++
+		log.Printf("ERROR: Database: %s, Failed to get row, error: %v\n", ds.GetName(), err)
 		return nil, err
 	}
 
 	return result, nil
 }
 
+// TableList returns the (schema qualified) names of the tables in the schemas being analyzed, if minRows is not -1 then only those
+// with more than minRows rows.
 func (ds *DataSource) TableList(minRows int) ([]string, error) {
 	var rows *sql.Rows
 	var err error
 
 	if minRows == -1 {
-		rows, err = ds.GetDatabase().Query(`SELECT table_name FROM information_schema.tables where table_schema = $1 and table_type = 'BASE TABLE' and table_name not ilike 'PGMAVEN_%'`, ds.options.Schema)
+		rows, err = ds.GetDatabase().Query(`SELECT table_schema, table_name FROM information_schema.tables where table_schema = ANY($1) and table_type = 'BASE TABLE' and table_name not ilike 'PGMAVEN_%'`, pq.Array(ds.GetSchemas()))
 	} else {
 		rows, err = ds.GetDatabase().Query(`
-			SELECT table_name FROM information_schema.tables, pg_stat_user_tables
+			SELECT table_schema, table_name FROM information_schema.tables, pg_stat_user_tables
 			where table_name = relname
-		  	  and table_schema = $1
+			  and table_schema = schemaname
+		  	  and table_schema = ANY($1)
 		  	  and table_type = 'BASE TABLE'
 		  	  and table_name not ilike 'PGMAVEN_%'
-		  	  and n_live_tup > $2`, ds.options.Schema, minRows)
+		  	  and n_live_tup > $2`, pq.Array(ds.GetSchemas()), minRows)
 	}
 	if err != nil {
-		fmt.Printf("ERROR: Failed to query database, error: %v\n", err)
+		ds.errorCount++
+		log.Printf("ERROR: Database: %s, Failed to query database, error: %v\n", ds.GetName(), err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	ret := make([]string, 0)
-	var table_name string
+	var table_schema, table_name string
 
 	for rows.Next() {
-		err := rows.Scan(&table_name)
+		err := rows.Scan(&table_schema, &table_name)
 		if err != nil {
-			log.Printf("ERROR: Database: %s, Failed to get row, error: %v\n", ds.GetDBName(), err)
+			ds.errorCount++
+			log.Printf("ERROR: Database: %s, Failed to get row, error: %v\n", ds.GetName(), err)
 			return nil, err
 		}
-		ret = append(ret, table_name)
+		ret = append(ret, utils.QualifiedName(table_schema, table_name))
 	}
 	return ret, nil
 }
 
-// IndexDefinition returns the DDL for the named index.
+// IndexDefinition returns the DDL for the named (optionally schema qualified) index.
 func (ds *DataSource) IndexDefinition(indexName string) string {
-	query := fmt.Sprintf(`SELECT pg_get_indexdef('%s'::regclass);`, indexName)
-	ret, err := ds.ExecuteQueryRow(query, nil)
+	ret, err := ds.ExecuteQueryRow(`SELECT pg_get_indexdef($1::regclass)`, []any{indexName})
 	if err != nil {
-		log.Printf("ERROR: Database: %s, IndexDefinition failed with error: %v\n", ds.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, IndexDefinition failed with error: %v\n", ds.GetName(), err)
 		return ""
 	}
 
 	return ret.(string)
 }
 
-// Get closest record to the time provided based on the supplied table
-func (d *DataSource) GetClosest(table string, t time.Time) any {
-	query := `with
-	date_options as (
-	select
-		distinct(insert_dt) as insert_dt
-	from
-		%s),
-	closest as (
-	select
-		insert_dt,
-		abs(extract(epoch from insert_dt - $1 AT TIME ZONE 'UTC')) as diff
-	from
-		date_options
-	order by
-		diff asc
-	limit 1)
-	select
-		insert_dt
-	from
-		closest
-	`
-	closest, _ := d.ExecuteQueryRow(fmt.Sprintf(query, table), []any{t})
-
-	return closest
+// InternalUsers are the internal users (e.g. on RDS) whose queries are not reported.
+var InternalUsers = []string{"rdsrepladmin", "rdsadmin", "rdstopmgr"}
+
+// UserNames returns a map from the user id to the user name.
+func (ds *DataSource) UserNames() (map[int64]string, error) {
+	ret := make(map[int64]string)
+	err := ds.ExecuteQueryRows("SELECT usesysid::bigint, usename FROM pg_user", nil, userProcessor, ret)
+
+	return ret, err
+}
+
+func userProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
+	m := self.(map[int64]string)
+	m[oidValue(*values[0].(*interface{}))] = string((*values[1].(*interface{})).([]uint8))
+}
+
+// oidValue returns the value of an oid column, lib/pq returns these as []byte unless they are cast (e.g. usesysid::bigint).
+func oidValue(v any) int64 {
+	switch value := v.(type) {
+	case int64:
+		return value
+	case []uint8:
+		ret, _ := strconv.ParseInt(string(value), 10, 64)
+		return ret
+	}
+
+	return 0
 }
@@ -0,0 +1,31 @@
+package dbutils
+
+import (
+	"testing"
+)
+
+func TestUserProcessor(t *testing.T) {
+	users := make(map[int64]string)
+	for _, id := range []any{int64(10), []uint8("16384")} {
+		var idValue, nameValue interface{} = id, []uint8("monitor")
+		userProcessor(0, nil, []interface{}{&idValue, &nameValue}, users)
+	}
+
+	if users[10] != "monitor" || users[16384] != "monitor" {
+		t.Fatalf("unexpected users %v", users)
+	}
+}
+
+func TestAddError(t *testing.T) {
+	ds := &DataSource{repository: &DataSource{}}
+	ds.AddError()
+	ds.repository.AddError()
+	if ds.GetErrorCount() != 2 {
+		t.Fatalf("expected 2 errors - found %d", ds.GetErrorCount())
+	}
+
+	ds.ResetErrorCount()
+	if ds.GetErrorCount() != 0 {
+		t.Fatalf("expected no errors after reset - found %d", ds.GetErrorCount())
+	}
+}
@@ -8,41 +8,102 @@ import (
 )
 
 type DBOptions struct {
-	DBName               string
-	DBNames              string
-	Host                 string
-	Password             string
-	Port                 int
-	Schema               string
-	TunnelHost           string
-	TunnelPort           int
-	TunnelPassphrase     string
-	TunnelPrivateKeyFile string
-	TunnelUsername       string
-	Username             string
+	AllDatabases          bool
+	AllSchemas            bool
+	Archive               string
+	Cluster               string
+	DBName                string
+	DBNames               string
+	ExcludeDatabases      []string
+	ExcludeSchemas        []string
+	Host                  string
+	HostCPUs              int
+	HostMemory            string
+	HostStorage           string
+	Inventory             string
+	MaintenanceDB         string
+	Parameters            map[string]string // additional connection parameters (e.g. application_name) from the URI or service
+	Password              string
+	Port                  int
+	Repository            string
+	Schemas               []string
+	Service               string
+	SSLCert               string
+	SSLKey                string
+	SSLMode               string
+	SSLRootCert           string
+	Tags                  []string
+	TunnelHost            string
+	TunnelInsecureHostKey bool
+	TunnelKnownHosts      string
+	TunnelPort            int
+	TunnelPassphrase      string
+	TunnelPrivateKeyFile  string
+	TunnelUsername        string
+	URI                   string
+	Username              string
+	Workload              string
 }
 
 const (
-	DefaultHost       = "localhost"
-	DefaultPort       = "5432"
-	DefaultSchema     = "public"
-	DefaultTunnelPort = 22
+	DefaultHost          = "localhost"
+	DefaultMaintenanceDB = "postgres"
+	DefaultPort          = "5432"
+	DefaultSchema        = "public"
+	DefaultSSLMode       = SSLModePrefer
+	DefaultTunnelPort    = 22
 )
 
+// Environment is the environment variable that provides the default for each option, these take precedence over a profile.
+var Environment = map[string]string{
+	"dbname":      "PGDATABASE",
+	"host":        "PGHOST",
+	"password":    "PGPASSWORD",
+	"port":        "PGPORT",
+	"service":     "PGSERVICE",
+	"sslcert":     "PGSSLCERT",
+	"sslkey":      "PGSSLKEY",
+	"sslmode":     "PGSSLMODE",
+	"sslrootcert": "PGSSLROOTCERT",
+	"username":    "PGUSER",
+}
+
 func (o *DBOptions) Init() {
+	flag.BoolVar(&o.AllDatabases, "all-databases", false, "process every database on the server (or each server in the inventory), discovered from pg_database")
+	flag.StringSliceVar(&o.ExcludeDatabases, "exclude-databases", nil, "databases to exclude with --all-databases, patterns may include * and ? (e.g. rdsadmin,test_*)")
+	flag.StringVar(&o.MaintenanceDB, "maintenance-db", DefaultMaintenanceDB, "database connected to in order to discover the databases with --all-databases")
 	flag.StringVar(&o.DBNames, "dbnames", "", "file with a list of dbnames to connect to")
-	flag.StringVar(&o.DBName, "dbname", envWithDefault("PGDATABASE", ""), "database name to connect to")
-	flag.StringVar(&o.Host, "host", envWithDefault("PGHOST", DefaultHost), "database server host or socket directory (default: 'local socket')")
-	flag.StringVar(&o.Password, "password", envWithDefault("PGPASSWORD", ""), "password for DB")
-	port, _ := strconv.Atoi(envWithDefault("PGPORT", DefaultPort))
+	flag.StringVar(&o.DBName, "dbname", envWithDefault(Environment["dbname"], ""), "database name to connect to")
+	flag.StringVar(&o.Host, "host", envWithDefault(Environment["host"], DefaultHost), "database server host or socket directory (e.g. /var/run/postgresql)")
+	flag.StringVar(&o.HostMemory, "host-memory", "", "RAM of the database server (e.g. 64GB), read from /proc/meminfo if the server is local")
+	flag.IntVar(&o.HostCPUs, "host-cpus", 0, "number of CPUs of the database server (default: the number of CPUs if the server is local)")
+	flag.StringVar(&o.HostStorage, "host-storage", "", "storage of the database server (ssd or hdd) (default: ssd)")
+	flag.StringVar(&o.Workload, "workload", "", "workload of the database (oltp, olap or mixed) (default: mixed)")
+	flag.StringVar(&o.Inventory, "inventory", "", "inventory file of databases (each with its own host, port, user, schema, tunnel and tags)")
+	flag.StringSliceVar(&o.Tags, "tags", nil, "only process the inventory entries with all these tags (e.g. env=prod,tier=gold)")
+	flag.StringVar(&o.Password, "password", envWithDefault(Environment["password"], ""), "password for DB")
+	port, _ := strconv.Atoi(envWithDefault(Environment["port"], DefaultPort))
 	flag.IntVar(&o.Port, "port", port, "database server port (default: '5432')")
-	flag.StringVar(&o.Schema, "schema", DefaultSchema, "database schema (default: 'public')")
+	flag.StringVar(&o.Repository, "repository", "", "connection string for a repository database to store snapshots (default: the monitored database)")
+	flag.StringVar(&o.Archive, "archive", "", "directory of snapshot archives, written by Snapshot and read (without a connection) by QueryIssues, TableIssues and NewActivity")
+	flag.StringVar(&o.Cluster, "cluster", "", "cluster name used to identify snapshots in the repository (default: 'host:port')")
+	flag.StringSliceVar(&o.Schemas, "schema", []string{DefaultSchema}, "schemas to analyze, patterns may include * and ? (e.g. public,tenant_*)")
+	flag.BoolVar(&o.AllSchemas, "all-schemas", false, "analyze every schema (other than the system schemas)")
+	flag.StringSliceVar(&o.ExcludeSchemas, "exclude-schemas", nil, "schemas to exclude, patterns may include * and ? (e.g. archive,*_staging)")
+	flag.StringVar(&o.Service, "service", envWithDefault(Environment["service"], ""), "connection service name from pg_service.conf")
+	flag.StringVar(&o.SSLCert, "sslcert", envWithDefault(Environment["sslcert"], ""), "client SSL certificate file")
+	flag.StringVar(&o.SSLKey, "sslkey", envWithDefault(Environment["sslkey"], ""), "client SSL private key file")
+	flag.StringVar(&o.SSLMode, "sslmode", envWithDefault(Environment["sslmode"], DefaultSSLMode), "SSL mode (disable, allow, prefer, require, verify-ca or verify-full)")
+	flag.StringVar(&o.SSLRootCert, "sslrootcert", envWithDefault(Environment["sslrootcert"], ""), "file of SSL certificate authorities used to verify the server")
 	flag.StringVar(&o.TunnelHost, "tunnelHost", "", "hostname of tunnel server")
-	flag.IntVar(&o.TunnelPort, "tunnelPort", DefaultTunnelPort, "port for tunnel server default: '22')")
-	flag.StringVar(&o.TunnelPassphrase, "tunnelPassphrase", "", "passphrase for private key file")
-	flag.StringVar(&o.TunnelPrivateKeyFile, "tunnelPrivateKeyFile", "", "path to private key file")
+	flag.BoolVar(&o.TunnelInsecureHostKey, "tunnelInsecureHostKey", false, "do not verify the host key of the tunnel server (not recommended)")
+	flag.StringVar(&o.TunnelKnownHosts, "tunnelKnownHosts", DefaultKnownHosts(), "known hosts file used to verify the tunnel server")
+	flag.IntVar(&o.TunnelPort, "tunnelPort", DefaultTunnelPort, "port for tunnel server (default: '22')")
+	flag.StringVar(&o.TunnelPassphrase, "tunnelPassphrase", "", "passphrase for private key file (if encrypted)")
+	flag.StringVar(&o.TunnelPrivateKeyFile, "tunnelPrivateKeyFile", "", "path to private key file (keys held by ssh-agent are also used)")
 	flag.StringVar(&o.TunnelUsername, "tunnelUsername", "", "username for tunnel server")
-	flag.StringVar(&o.Username, "username", envWithDefault("PGUSER", ""), "database user name")
+	flag.StringVar(&o.URI, "uri", "", "connection URI (postgres://user@host:port/dbname?sslmode=require) or connection string, explicit options take precedence")
+	flag.StringVar(&o.Username, "username", envWithDefault(Environment["username"], ""), "database user name")
 }
 
 func envWithDefault(e string, def string) string {
@@ -53,3 +114,12 @@ func envWithDefault(e string, def string) string {
 
 	return def
 }
+
+// GetCluster returns the name used to identify the cluster, this defaults to host:port.
+func (o *DBOptions) GetCluster() string {
+	if o.Cluster != "" {
+		return o.Cluster
+	}
+
+	return o.Host + ":" + strconv.Itoa(o.Port)
+}
@@ -0,0 +1,146 @@
+package dbutils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"pgmaven/internal/utils"
+)
+
+const (
+	StorageSSD = "ssd"
+	StorageHDD = "hdd"
+
+	WorkloadOLTP  = "oltp"
+	WorkloadOLAP  = "olap"
+	WorkloadMixed = "mixed"
+
+	DefaultStorage  = StorageSSD
+	DefaultWorkload = WorkloadMixed
+)
+
+// HostProfile is the hardware (and workload) of the database server, used to determine the recommended configuration.
+type HostProfile struct {
+	Memory   int64 // bytes, 0 if unknown
+	CPUs     int   // 0 if unknown
+	Storage  string
+	Workload string
+	// sources records where each value came from (e.g. specified, /proc/meminfo, assumed)
+	sources map[string]string
+}
+
+// ValidateHostProfile checks the host profile options (--host-memory, --host-cpus, --host-storage and --workload).
+func (o *DBOptions) ValidateHostProfile() error {
+	if o.HostMemory != "" {
+		if memory, err := utils.ParseSize(o.HostMemory); err != nil || memory == 0 {
+			return fmt.Errorf("--host-memory '%s' invalid, should be a size (e.g. 64GB)", o.HostMemory)
+		}
+	}
+	if o.HostCPUs < 0 {
+		return fmt.Errorf("--host-cpus must not be negative")
+	}
+	if o.HostStorage != "" && o.HostStorage != StorageSSD && o.HostStorage != StorageHDD {
+		return fmt.Errorf("--host-storage '%s' not supported, should be one of %s or %s", o.HostStorage, StorageSSD, StorageHDD)
+	}
+	if o.Workload != "" && o.Workload != WorkloadOLTP && o.Workload != WorkloadOLAP && o.Workload != WorkloadMixed {
+		return fmt.Errorf("--workload '%s' not supported, should be one of %s, %s or %s", o.Workload, WorkloadOLTP, WorkloadOLAP, WorkloadMixed)
+	}
+
+	return nil
+}
+
+// IsLocal returns true if the database server is on this machine (i.e. connected via localhost or a Unix socket, not a tunnel).
+func (o *DBOptions) IsLocal() bool {
+	if o.TunnelHost != "" {
+		return false
+	}
+
+	return o.Host == "" || o.Host == "localhost" || o.Host == "127.0.0.1" || o.Host == "::1" || strings.HasPrefix(o.Host, "/")
+}
+
+// GetHostProfile returns the profile of the database server, values not specified are determined from this machine if the
+// server is local (RAM from /proc/meminfo), the storage and workload otherwise default to SSD and mixed.
+func (ds *DataSource) GetHostProfile() HostProfile {
+	o := ds.options
+	ret := HostProfile{Storage: DefaultStorage, Workload: DefaultWorkload, sources: make(map[string]string)}
+
+	if o.HostMemory != "" {
+		ret.Memory, _ = utils.ParseSize(o.HostMemory)
+		ret.sources["memory"] = "specified"
+	} else if o.IsLocal() {
+		if memory, err := readMemInfo("/proc/meminfo"); err == nil {
+			ret.Memory = memory
+			ret.sources["memory"] = "/proc/meminfo"
+		}
+	}
+
+	if o.HostCPUs != 0 {
+		ret.CPUs = o.HostCPUs
+		ret.sources["cpus"] = "specified"
+	} else if o.IsLocal() {
+		ret.CPUs = runtime.NumCPU()
+		ret.sources["cpus"] = "local"
+	}
+
+	ret.sources["storage"], ret.sources["workload"] = "assumed", "assumed"
+	if o.HostStorage != "" {
+		ret.Storage = o.HostStorage
+		ret.sources["storage"] = "specified"
+	}
+	if o.Workload != "" {
+		ret.Workload = o.Workload
+		ret.sources["workload"] = "specified"
+	}
+
+	return ret
+}
+
+// String returns the profile (and where each value came from) in a human-readable form, for inclusion in the issues.
+func (p HostProfile) String() string {
+	memory, cpus := "unknown", "unknown"
+	if p.Memory != 0 {
+		memory = fmt.Sprintf("%.1fGB (%s)", float64(p.Memory)/float64(utils.SIZE_GB), p.sources["memory"])
+	}
+	if p.CPUs != 0 {
+		cpus = fmt.Sprintf("%d (%s)", p.CPUs, p.sources["cpus"])
+	}
+
+	return fmt.Sprintf("RAM: %s, CPUs: %s, Storage: %s (%s), Workload: %s (%s)",
+		memory, cpus, strings.ToUpper(p.Storage), p.sources["storage"], strings.ToUpper(p.Workload), p.sources["workload"])
+}
+
+func readMemInfo(name string) (int64, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return parseMemInfo(file)
+}
+
+// parseMemInfo returns the total memory (in bytes) from the content of /proc/meminfo.
+func parseMemInfo(r io.Reader) (int64, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+		value, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemTotal '%s'", fields[1])
+		}
+		if len(fields) > 2 && fields[2] == "kB" {
+			value *= utils.SIZE_KB
+		}
+		return value, nil
+	}
+
+	return 0, fmt.Errorf("MemTotal not found")
+}
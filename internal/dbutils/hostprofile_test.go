@@ -0,0 +1,46 @@
+package dbutils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHostProfile(t *testing.T) {
+	memInfo := "MemTotal:       16318196 kB\nMemFree:         1187540 kB\n"
+	memory, err := parseMemInfo(strings.NewReader(memInfo))
+	if err != nil || memory != 16318196*1024 {
+		t.Fatalf("parseMemInfo - found %d (%v)", memory, err)
+	}
+	if _, err := parseMemInfo(strings.NewReader("MemFree: 1 kB\n")); err == nil {
+		t.Fatalf("parseMemInfo should fail if MemTotal is missing")
+	}
+
+	ds := NewDataSource(DBOptions{Host: "db1.example.com", HostMemory: "64GB", HostStorage: StorageHDD, Workload: WorkloadOLAP})
+	profile := ds.GetHostProfile()
+	if profile.Memory != 64*1024*1024*1024 || profile.CPUs != 0 || profile.Storage != StorageHDD || profile.Workload != WorkloadOLAP {
+		t.Fatalf("unexpected profile %+v", profile)
+	}
+	expected := "RAM: 64.0GB (specified), CPUs: unknown, Storage: HDD (specified), Workload: OLAP (specified)"
+	if profile.String() != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, profile.String())
+	}
+
+	if remote := NewDataSource(DBOptions{Host: "db1.example.com"}).GetHostProfile(); remote.Memory != 0 || remote.Workload != DefaultWorkload {
+		t.Fatalf("unexpected profile for remote server %+v", remote)
+	}
+
+	local := DBOptions{Host: "/var/run/postgresql"}
+	if !local.IsLocal() {
+		t.Fatalf("socket directory should be local")
+	}
+	local.TunnelHost = "bastion.example.com"
+	if local.IsLocal() {
+		t.Fatalf("tunnelled server should not be local")
+	}
+
+	for _, invalid := range []DBOptions{{HostMemory: "lots"}, {HostStorage: "nvme"}, {Workload: "batch"}, {HostCPUs: -1}} {
+		if err := invalid.ValidateHostProfile(); err == nil {
+			t.Fatalf("expected error for %+v", invalid)
+		}
+	}
+}
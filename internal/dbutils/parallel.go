@@ -0,0 +1,37 @@
+package dbutils
+
+import (
+	"sync"
+)
+
+// ForEachDatabase invokes fn for every target using (up to) parallel workers, fn is passed the name of the target.
+// Each worker owns its own DataSource (cloned from ds) and hence its own connection to the database.
+func ForEachDatabase(ds *DataSource, targets []Target, parallel int, fn func(worker *DataSource, name string)) {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	jobs := make(chan Target)
+	var wg sync.WaitGroup
+
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := ds.Clone()
+			for target := range jobs {
+				worker.ResetErrorCount()
+				worker.SetTarget(target)
+				fn(worker, target.Name)
+				worker.Close()
+			}
+		}()
+	}
+
+	for _, target := range targets {
+		jobs <- target
+	}
+	close(jobs)
+
+	wg.Wait()
+}
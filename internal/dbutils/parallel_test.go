@@ -0,0 +1,50 @@
+package dbutils
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestForEachDatabase(t *testing.T) {
+	targets := make([]Target, 0)
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("db%d", i)
+		targets = append(targets, Target{Name: "cluster/" + name, DBName: name})
+	}
+
+	for _, parallel := range []int{0, 1, 3, 20} {
+		var mutex sync.Mutex
+		names := make([]string, 0)
+		workers := make(map[*DataSource]bool)
+		var active, peak atomic.Int32
+
+		ForEachDatabase(&DataSource{}, targets, parallel, func(worker *DataSource, name string) {
+			if n := active.Add(1); n > peak.Load() {
+				peak.Store(n)
+			}
+			defer active.Add(-1)
+			time.Sleep(time.Millisecond)
+
+			if worker.GetName() != name || worker.GetDBName() != name[len("cluster/"):] {
+				t.Errorf("worker target %s/%s does not match %s", worker.GetName(), worker.GetDBName(), name)
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			names = append(names, name)
+			workers[worker] = true
+		})
+
+		sort.Strings(names)
+		if len(names) != len(targets) || names[0] != "cluster/db0" || names[9] != "cluster/db9" {
+			t.Errorf("parallel %d: each target should be processed once, got %v", parallel, names)
+		}
+		limit := max(parallel, 1)
+		if int(peak.Load()) > limit || len(workers) > limit {
+			t.Errorf("parallel %d: %d concurrent, %d workers", parallel, peak.Load(), len(workers))
+		}
+	}
+}
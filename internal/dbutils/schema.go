@@ -0,0 +1,54 @@
+package dbutils
+
+import (
+	"database/sql"
+	"log"
+	"strings"
+)
+
+// SchemaQuery lists the schemas in the database, excluding the system schemas (pg_catalog, pg_toast, information_schema, ...).
+const SchemaQuery = `SELECT nspname FROM pg_namespace WHERE nspname !~ '^pg_' AND nspname <> 'information_schema' ORDER BY nspname`
+
+// IsSystemSchema returns true if the schema is one of the schemas maintained by PostgreSQL.
+func IsSystemSchema(schema string) bool {
+	return strings.HasPrefix(schema, "pg_") || schema == "information_schema"
+}
+
+// SchemaMatches returns true if the schema is to be analyzed, i.e. it matches --schema (or --all-schemas is set) and does not
+// match --exclude-schemas.
+func (o *DBOptions) SchemaMatches(schema string) bool {
+	if IsSystemSchema(schema) || Excluded(schema, o.ExcludeSchemas) {
+		return false
+	}
+
+	return o.AllSchemas || Matches(schema, o.Schemas)
+}
+
+// SchemaMatches returns true if the schema is to be analyzed, this is used where the schemas are not known in advance (e.g.
+// when analyzing an archive).
+func (ds *DataSource) SchemaMatches(schema string) bool {
+	return ds.options.SchemaMatches(schema)
+}
+
+// GetSchemas returns the schemas to be analyzed (resolved against the database once per connection), these are typically passed
+// to a query as an array, e.g. WHERE schemaname = ANY($1).
+func (ds *DataSource) GetSchemas() []string {
+	if ds.schemas != nil {
+		return ds.schemas
+	}
+
+	ds.schemas = make([]string, 0)
+	err := ds.ExecuteQueryRows(SchemaQuery, nil, func(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
+		schema := string((*values[0].(*interface{})).([]uint8))
+		if ds.SchemaMatches(schema) {
+			ds.schemas = append(ds.schemas, schema)
+		}
+	}, nil)
+	if err != nil {
+		log.Printf("ERROR: Database: %s, failed to list schemas, error: %v\n", ds.GetName(), err)
+	} else if len(ds.schemas) == 0 {
+		log.Printf("WARNING: Database: %s, no schemas match %s\n", ds.GetName(), strings.Join(ds.options.Schemas, ","))
+	}
+
+	return ds.schemas
+}
@@ -0,0 +1,121 @@
+package dbutils
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SnapshotCatalog is the table recording every snapshot taken, the rows in the pgmaven_<table> tables reference it via snapshot_id.
+const SnapshotCatalog = "pgmaven_snapshot"
+
+// SnapshotInfo describes a single snapshot from the catalog (times that are not known are zero).
+// Tables lists the Statistics tables captured, nil if all the tables were captured.
+type SnapshotInfo struct {
+	ID              int64
+	Time            time.Time
+	ServerVersion   string
+	PostmasterStart time.Time
+	StatsReset      time.Time
+	StatementsReset time.Time
+	Tables          []string
+}
+
+// SnapshotCatalogDDL creates the snapshot catalog.  The cluster and database name identify the source of the snapshot when
+// stored in a repository, snapshot_tables is the (comma separated) list of tables captured, NULL if all the tables were captured.
+var SnapshotCatalogDDL = []string{
+	fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	snapshot_id BIGSERIAL PRIMARY KEY,
+	snapshot_dt TIMESTAMP DEFAULT NOW(),
+	cluster_name TEXT,
+	database_name TEXT,
+	server_version TEXT,
+	postmaster_start_time TIMESTAMPTZ,
+	stats_reset TIMESTAMPTZ,
+	statements_reset TIMESTAMPTZ)`, SnapshotCatalog),
+	// Catalogs created by earlier versions do not have the cluster and database name
+	fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS cluster_name TEXT`, SnapshotCatalog),
+	fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS database_name TEXT`, SnapshotCatalog),
+	fmt.Sprintf(`ALTER TABLE %s ADD COLUMN IF NOT EXISTS snapshot_tables TEXT`, SnapshotCatalog),
+	fmt.Sprintf(`CREATE INDEX IF NOT EXISTS pgmaven_ix_snapshot_database ON %s(cluster_name, database_name)`, SnapshotCatalog),
+}
+
+const snapshotColumns = `snapshot_id, snapshot_dt, coalesce(server_version, ''), postmaster_start_time, stats_reset, statements_reset, snapshot_tables`
+
+func scanSnapshot(row interface{ Scan(...any) error }) (SnapshotInfo, error) {
+	var ret SnapshotInfo
+	var postmasterStart, statsReset, statementsReset sql.NullTime
+	var tables sql.NullString
+
+	err := row.Scan(&ret.ID, &ret.Time, &ret.ServerVersion, &postmasterStart, &statsReset, &statementsReset, &tables)
+	ret.PostmasterStart = postmasterStart.Time
+	ret.StatsReset = statsReset.Time
+	ret.StatementsReset = statementsReset.Time
+	if tables.Valid {
+		ret.Tables = strings.Split(tables.String, ",")
+	}
+
+	return ret, err
+}
+
+// Snapshots returns all the snapshots from the catalog (oldest first).
+func (ds *DataSource) Snapshots() ([]SnapshotInfo, error) {
+	filter, filterArgs := ds.snapshotFilter(1)
+	rows, err := ds.History().database.Query(fmt.Sprintf(`select %s from %s %s order by snapshot_id`, snapshotColumns, SnapshotCatalog, filter), filterArgs...)
+	if err != nil {
+		ds.History().errorCount++
+		log.Printf("ERROR: Database: %s, Failed to list snapshots, error: %v\n", ds.GetName(), err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	ret := make([]SnapshotInfo, 0)
+	for rows.Next() {
+		snapshot, err := scanSnapshot(rows)
+		if err != nil {
+			ds.History().errorCount++
+			log.Printf("ERROR: Database: %s, Failed to scan snapshot, error: %v\n", ds.GetName(), err)
+			return nil, err
+		}
+		ret = append(ret, snapshot)
+	}
+
+	return ret, rows.Err()
+}
+
+// SameEpoch returns true if the statistics counters have not been reset (or the server restarted) between the two snapshots.
+func (s SnapshotInfo) SameEpoch(other SnapshotInfo) bool {
+	return s.PostmasterStart.Equal(other.PostmasterStart) && s.StatsReset.Equal(other.StatsReset)
+}
+
+// Includes returns true if the table was captured by the snapshot.
+func (s SnapshotInfo) Includes(table string) bool {
+	return s.Tables == nil || slices.Contains(s.Tables, table)
+}
+
+// HasSnapshots returns true if the snapshot catalog exists and contains at least one snapshot.
+func (ds *DataSource) HasSnapshots() bool {
+	history := ds.History()
+	exists, err := history.ExecuteQueryRow(`SELECT to_regclass($1) IS NOT NULL`, []any{SnapshotCatalog})
+	if err != nil || !exists.(bool) {
+		return false
+	}
+
+	filter, filterArgs := ds.snapshotFilter(1)
+	count, err := history.ExecuteQueryRow(fmt.Sprintf(`SELECT count(*) FROM %s %s`, SnapshotCatalog, filter), filterArgs)
+
+	return err == nil && count.(int64) != 0
+}
+
+// snapshotFilter returns the where clause (and arguments) that selects the snapshots of this database from the catalog.
+// This is only required for a repository, the parameters are numbered from the index provided.
+func (ds *DataSource) snapshotFilter(index int) (string, []any) {
+	if ds.repository == nil {
+		return "", nil
+	}
+
+	return fmt.Sprintf("where cluster_name = $%d and database_name = $%d", index, index+1), []any{ds.GetCluster(), ds.GetDBName()}
+}
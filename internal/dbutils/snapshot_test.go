@@ -0,0 +1,88 @@
+package dbutils
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshotCatalogDDL(t *testing.T) {
+	// Catalogs created by earlier versions must gain the columns before they are indexed
+	added := make(map[string]bool)
+	for _, stmt := range SnapshotCatalogDDL {
+		if column, found := strings.CutPrefix(stmt, "ALTER TABLE "+SnapshotCatalog+" ADD COLUMN IF NOT EXISTS "); found {
+			added[strings.Fields(column)[0]] = true
+		}
+		if strings.HasPrefix(stmt, "CREATE INDEX") && (!added["cluster_name"] || !added["database_name"]) {
+			t.Fatalf("index created before the columns are added: %s", stmt)
+		}
+	}
+}
+
+// fakeRow returns the values provided from Scan (as database/sql would for the snapshot columns).
+type fakeRow []any
+
+func (r fakeRow) Scan(dest ...any) error {
+	for i, value := range r {
+		switch d := dest[i].(type) {
+		case *int64:
+			*d = value.(int64)
+		case *string:
+			*d = value.(string)
+		case *time.Time:
+			*d = value.(time.Time)
+		case *sql.NullTime:
+			*d = value.(sql.NullTime)
+		case *sql.NullString:
+			*d = value.(sql.NullString)
+		}
+	}
+
+	return nil
+}
+
+func TestScanSnapshot(t *testing.T) {
+	taken := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+	started := taken.Add(-24 * time.Hour)
+
+	snapshot, err := scanSnapshot(fakeRow{int64(7), taken, "16.2", sql.NullTime{Time: started, Valid: true}, sql.NullTime{}, sql.NullTime{}, sql.NullString{}})
+	if err != nil {
+		t.Fatalf("scanSnapshot failed: %v", err)
+	}
+	if snapshot.ID != 7 || !snapshot.Time.Equal(taken) || snapshot.ServerVersion != "16.2" || !snapshot.PostmasterStart.Equal(started) {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+	if !snapshot.StatsReset.IsZero() || snapshot.Tables != nil || !snapshot.Includes("pg_stat_activity") {
+		t.Fatalf("NULL columns should be zero and all the tables captured, got %+v", snapshot)
+	}
+
+	partial, err := scanSnapshot(fakeRow{int64(8), taken, "", sql.NullTime{Time: started, Valid: true}, sql.NullTime{}, sql.NullTime{}, sql.NullString{String: "pg_stat_activity,pg_stat_statements", Valid: true}})
+	if err != nil {
+		t.Fatalf("scanSnapshot failed: %v", err)
+	}
+	if !partial.Includes("pg_stat_statements") || partial.Includes("pg_stat_user_tables") {
+		t.Fatalf("unexpected tables %v", partial.Tables)
+	}
+
+	if !snapshot.SameEpoch(partial) {
+		t.Fatalf("snapshots with the same postmaster start and stats reset should be in the same epoch")
+	}
+	partial.StatsReset = taken
+	if snapshot.SameEpoch(partial) {
+		t.Fatalf("a stats reset should start a new epoch")
+	}
+}
+
+func TestSnapshotFilter(t *testing.T) {
+	ds := &DataSource{dbName: "sales", options: DBOptions{Host: "db.example.com", Port: 5432}}
+	if filter, args := ds.snapshotFilter(1); filter != "" || args != nil {
+		t.Fatalf("snapshots in the monitored database should not be filtered, got '%s' %v", filter, args)
+	}
+
+	ds.repository = &DataSource{dbName: "repository"}
+	filter, args := ds.snapshotFilter(3)
+	if filter != "where cluster_name = $3 and database_name = $4" || len(args) != 2 || args[0] != ds.GetCluster() || args[1] != "sales" {
+		t.Fatalf("unexpected repository filter '%s' %v", filter, args)
+	}
+}
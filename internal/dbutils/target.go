@@ -0,0 +1,165 @@
+package dbutils
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Target is a database to be processed along with the options used to connect to it, so a single run can span several servers.
+type Target struct {
+	// Name identifies the database in the output
+	Name    string
+	DBName  string
+	Options DBOptions
+}
+
+// DefaultDBName is the target name used if no database is specified, the server then connects to the database with the same
+// name as the user.
+const DefaultDBName = "''"
+
+// GetCluster returns the name used to identify the cluster of the target.
+func (t Target) GetCluster() string {
+	return t.Options.GetCluster()
+}
+
+// NewTargets returns the targets for the list of databases (e.g. from --dbnames), each is either a database name or
+// host[:port]/dbname for a database on another server.  Blank entries are ignored.
+func NewTargets(o DBOptions, entries []string) ([]Target, error) {
+	ret := make([]Target, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		target := Target{Name: entry, DBName: entry, Options: o}
+		if slash := strings.LastIndex(entry, "/"); slash > 0 {
+			server := entry[:slash]
+			target.Options.Host, target.DBName = server, entry[slash+1:]
+			if host, port, err := net.SplitHostPort(server); err == nil {
+				if target.Options.Port, err = strconv.Atoi(port); err != nil {
+					return nil, fmt.Errorf("invalid port in '%s'", entry)
+				}
+				target.Options.Host = host
+			}
+		}
+		ret = append(ret, target)
+	}
+
+	return ret, nil
+}
+
+// tunnels are the SSH tunnels shared by all the DataSources, keyed by the tunnel server and credentials.
+type tunnels struct {
+	mutex   sync.Mutex
+	tunnels map[string]*Tunnel
+}
+
+func newTunnels() *tunnels {
+	return &tunnels{tunnels: make(map[string]*Tunnel)}
+}
+
+// get returns the tunnel for the options, nil if no tunnel is required.
+func (t *tunnels) get(o DBOptions) (*Tunnel, error) {
+	if o.TunnelHost == "" {
+		return nil, nil
+	}
+
+	key := strings.Join([]string{o.TunnelUsername, o.TunnelHost, strconv.Itoa(o.TunnelPort), o.TunnelPrivateKeyFile, o.TunnelKnownHosts}, "\x00")
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if tunnel, ok := t.tunnels[key]; ok {
+		return tunnel, nil
+	}
+	tunnel, err := NewTunnel(o)
+	if err != nil {
+		return nil, err
+	}
+	t.tunnels[key] = tunnel
+
+	return tunnel, nil
+}
+
+// close every tunnel (the listeners, SSH connection and ssh-agent connection).
+func (t *tunnels) close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for key, tunnel := range t.tunnels {
+		tunnel.Close()
+		delete(t.tunnels, key)
+	}
+}
+
+// DiscoveryQuery lists the databases that can be connected to (excluding templates).
+const DiscoveryQuery = `SELECT datname FROM pg_database WHERE NOT datistemplate AND datallowconn ORDER BY datname`
+
+// Discover returns a target for each database on the servers (connecting to the maintenance database of each), excluding
+// those that match any of the patterns.  If qualify is set the name of each target is cluster/dbname.  Servers that cannot be
+// reached are reported and the number of these is returned.
+func Discover(ds *DataSource, servers []Target, exclude []string, qualify bool) ([]Target, int) {
+	ret := make([]Target, 0)
+	failed := 0
+
+	worker := ds.Clone()
+	for _, server := range servers {
+		worker.SetTarget(server)
+		dbNames, err := worker.databases()
+		worker.Close()
+		if err != nil {
+			log.Printf("ERROR: Database: %s, failed to discover databases, error: %v\n", server.Name, err)
+			failed++
+			continue
+		}
+
+		for _, dbName := range dbNames {
+			if Excluded(dbName, exclude) {
+				continue
+			}
+			target := Target{Name: dbName, DBName: dbName, Options: server.Options}
+			if qualify {
+				target.Name = target.GetCluster() + "/" + dbName
+			}
+			ret = append(ret, target)
+		}
+	}
+
+	return ret, failed
+}
+
+func (ds *DataSource) databases() ([]string, error) {
+	if err := ds.Open(); err != nil {
+		return nil, err
+	}
+
+	ret := make([]string, 0)
+	err := ds.ExecuteQueryRows(DiscoveryQuery, nil, func(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
+		ret = append(ret, string((*values[0].(*interface{})).([]uint8)))
+	}, nil)
+
+	return ret, err
+}
+
+// Excluded returns true if the name (of a database or schema) matches any of the exclusion patterns.
+func Excluded(name string, patterns []string) bool {
+	return Matches(name, patterns)
+}
+
+// Matches returns true if the name (of a database or schema) matches any of the (shell style, e.g. test_*) patterns.
+func Matches(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,68 @@
+package dbutils
+
+import (
+	"testing"
+)
+
+func TestNewTargets(t *testing.T) {
+	o := DBOptions{Host: "localhost", Port: 5432}
+	targets, err := NewTargets(o, []string{"sales", "", "db2.example.com/billing", "[::1]:6432/audit", "/var/run/postgresql/local"})
+	if err != nil {
+		t.Fatalf("NewTargets failed: %v", err)
+	}
+	if len(targets) != 4 {
+		t.Fatalf("expected 4 targets, found %d", len(targets))
+	}
+
+	expected := []struct {
+		host   string
+		port   int
+		dbName string
+	}{{"localhost", 5432, "sales"}, {"db2.example.com", 5432, "billing"}, {"::1", 6432, "audit"}, {"/var/run/postgresql", 5432, "local"}}
+	for i, e := range expected {
+		if targets[i].Options.Host != e.host || targets[i].Options.Port != e.port || targets[i].DBName != e.dbName {
+			t.Errorf("target %d: expected %+v, found %+v", i, e, targets[i])
+		}
+	}
+
+	if _, err := NewTargets(o, []string{"db2.example.com:port/billing"}); err == nil {
+		t.Errorf("expected error for invalid port")
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	patterns := []string{"rdsadmin", "test_*"}
+	for dbName, expected := range map[string]bool{"rdsadmin": true, "test_orders": true, "orders": false, "orders_test": false} {
+		if Excluded(dbName, patterns) != expected {
+			t.Errorf("'%s': expected excluded %v", dbName, expected)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	patterns := []string{"public", "tenant_?", "app_*"}
+	for name, expected := range map[string]bool{"public": true, "tenant_1": true, "tenant_12": false, "app_": true, "app_sales": true, "sales": false} {
+		if Matches(name, patterns) != expected {
+			t.Errorf("'%s': expected match %v", name, expected)
+		}
+	}
+	if Matches("public", nil) {
+		t.Errorf("no patterns should match nothing")
+	}
+}
+
+func TestSchemaMatches(t *testing.T) {
+	options := DBOptions{Schemas: []string{"public", "tenant_*"}, ExcludeSchemas: []string{"tenant_test"}}
+	for schema, expected := range map[string]bool{"public": true, "tenant_1": true, "tenant_test": false, "sales": false, "pg_catalog": false} {
+		if options.SchemaMatches(schema) != expected {
+			t.Errorf("'%s': expected match %v", schema, expected)
+		}
+	}
+
+	options.AllSchemas = true
+	for schema, expected := range map[string]bool{"sales": true, "tenant_test": false, "pg_toast": false, "information_schema": false} {
+		if options.SchemaMatches(schema) != expected {
+			t.Errorf("all schemas, '%s': expected match %v", schema, expected)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package dbutils
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const tunnelDialTimeout = 15 * time.Second
+
+// Tunnel is an SSH connection to the tunnel server, shared by the local listeners that forward to each database server.
+// The SSH connection is established on demand and re-established if it is lost (e.g. between pgagent cycles).
+type Tunnel struct {
+	server  string
+	config  *ssh.ClientConfig
+	mutex   sync.Mutex
+	client  *ssh.Client
+	forward map[string]net.Listener
+	// The connection to ssh-agent (if used), held open so the agent keys are available when reconnecting
+	agent net.Conn
+}
+
+// NewTunnel validates the tunnel options (authentication and host key verification) and returns the tunnel, the SSH connection
+// is not established until Connect (or the first database connection).
+func NewTunnel(o DBOptions) (*Tunnel, error) {
+	auth, agentConn, err := tunnelAuth(o, os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return nil, err
+	}
+
+	server := net.JoinHostPort(o.TunnelHost, strconv.Itoa(o.TunnelPort))
+	config := &ssh.ClientConfig{User: o.TunnelUsername, Auth: auth, Timeout: tunnelDialTimeout}
+	if o.TunnelInsecureHostKey {
+		log.Printf("WARNING: Host key for tunnel server '%s' will not be verified\n", server)
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	} else {
+		if config.HostKeyCallback, err = knownhosts.New(o.TunnelKnownHosts); err != nil {
+			if agentConn != nil {
+				agentConn.Close()
+			}
+			return nil, fmt.Errorf("failed to read known hosts file, error: %v (add the tunnel server with ssh-keyscan or use --tunnelInsecureHostKey)", err)
+		}
+		config.HostKeyAlgorithms = knownAlgorithms(o.TunnelKnownHosts, server)
+	}
+
+	return &Tunnel{server: server, config: config, forward: make(map[string]net.Listener), agent: agentConn}, nil
+}
+
+// tunnelAuth returns the authentication methods, the key file (if specified) followed by the keys held by ssh-agent (listening on
+// socket), along with the connection to ssh-agent (nil if not used) which the caller must close.
+func tunnelAuth(o DBOptions, socket string) ([]ssh.AuthMethod, net.Conn, error) {
+	ret := make([]ssh.AuthMethod, 0, 2)
+
+	if o.TunnelPrivateKeyFile != "" {
+		buffer, err := os.ReadFile(o.TunnelPrivateKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read private key file, error: %v", err)
+		}
+		var key ssh.Signer
+		if o.TunnelPassphrase != "" {
+			key, err = ssh.ParsePrivateKeyWithPassphrase(buffer, []byte(o.TunnelPassphrase))
+		} else {
+			key, err = ssh.ParsePrivateKey(buffer)
+		}
+		if err != nil {
+			var missing *ssh.PassphraseMissingError
+			if errors.As(err, &missing) {
+				return nil, nil, fmt.Errorf("private key file '%s' is encrypted, --tunnelPassphrase required", o.TunnelPrivateKeyFile)
+			}
+			return nil, nil, fmt.Errorf("failed to parse private key file '%s', error: %v", o.TunnelPrivateKeyFile, err)
+		}
+		ret = append(ret, ssh.PublicKeys(key))
+	}
+
+	var agentConn net.Conn
+	if socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			agentConn = conn
+			ret = append(ret, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	if len(ret) == 0 {
+		return nil, nil, fmt.Errorf("no SSH authentication available, specify --tunnelPrivateKeyFile or start ssh-agent")
+	}
+
+	return ret, agentConn, nil
+}
+
+// knownAlgorithms returns the host key algorithms for the keys recorded for the server in the known hosts file, so the server is
+// not asked for a type of key that cannot be verified.  Nil (the default algorithms) is returned if the server is not known.
+func knownAlgorithms(file string, server string) []string {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil
+	}
+
+	host := knownhosts.Normalize(server)
+	var ret []string
+	for len(content) > 0 {
+		marker, hosts, key, _, rest, err := ssh.ParseKnownHosts(content)
+		if err != nil {
+			break
+		}
+		content = rest
+		// Revoked keys and certificate authorities do not identify the algorithm of the host key
+		if marker != "" || !knownHostMatches(hosts, host) {
+			continue
+		}
+		if key.Type() == ssh.KeyAlgoRSA {
+			ret = append(ret, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		ret = append(ret, key.Type())
+	}
+
+	return ret
+}
+
+// knownHostMatches returns true if the (normalized) host matches the host patterns of a known hosts entry, which may be hashed
+// (|1|salt|hash) or contain wildcards.  A negated pattern that matches excludes the host.
+func knownHostMatches(patterns []string, host string) bool {
+	ret := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if knownHostPatternMatches(strings.TrimPrefix(pattern, "!"), host) {
+			if negated {
+				return false
+			}
+			ret = true
+		}
+	}
+
+	return ret
+}
+
+func knownHostPatternMatches(pattern string, host string) bool {
+	if hashed, ok := strings.CutPrefix(pattern, "|1|"); ok {
+		encodedSalt, encodedHash, found := strings.Cut(hashed, "|")
+		if !found {
+			return false
+		}
+		salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+		if err != nil {
+			return false
+		}
+		hash, err := base64.StdEncoding.DecodeString(encodedHash)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(host))
+		return bytes.Equal(mac.Sum(nil), hash)
+	}
+
+	return wildcardMatches(pattern, host)
+}
+
+// wildcardMatches returns true if the value matches the pattern, where * matches any sequence of characters and ? any single
+// character (other characters, including [ and ], match literally).
+func wildcardMatches(pattern string, value string) bool {
+	if pattern == "" {
+		return value == ""
+	}
+	switch pattern[0] {
+	case '*':
+		for i := 0; i <= len(value); i++ {
+			if wildcardMatches(pattern[1:], value[i:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return value != "" && wildcardMatches(pattern[1:], value[1:])
+	}
+
+	return value != "" && pattern[0] == value[0] && wildcardMatches(pattern[1:], value[1:])
+}
+
+// DefaultKnownHosts returns the default known hosts file.
+func DefaultKnownHosts() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(homeDir, ".ssh", "known_hosts")
+}
+
+// Connect returns the SSH connection, establishing it if necessary.  An existing connection is checked with a keepalive so a
+// connection dropped by the server (or the network) is replaced.
+func (t *Tunnel) Connect() (*ssh.Client, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.client != nil {
+		if _, _, err := t.client.SendRequest("keepalive@openssh.com", true, nil); err == nil {
+			return t.client, nil
+		}
+		log.Printf("WARNING: Connection to tunnel server '%s' lost, reconnecting\n", t.server)
+		t.client.Close()
+		t.client = nil
+	}
+
+	client, err := ssh.Dial("tcp", t.server, t.config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to tunnel server '%s', error: %v", t.server, err)
+	}
+	t.client = client
+
+	return client, nil
+}
+
+// Forward returns the local address that forwards to the database server (host:port), the listener is created on first use.
+func (t *Tunnel) Forward(remote string) (*net.TCPAddr, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if listener, ok := t.forward[remote]; ok {
+		return listener.Addr().(*net.TCPAddr), nil
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for tunnel, error: %v", err)
+	}
+	t.forward[remote] = listener
+	go t.serve(listener, remote)
+
+	return listener.Addr().(*net.TCPAddr), nil
+}
+
+func (t *Tunnel) serve(listener net.Listener, remote string) {
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.relay(local, remote)
+	}
+}
+
+// relay copies the traffic between the local connection and the database server, via the tunnel server.
+func (t *Tunnel) relay(local net.Conn, remote string) {
+	defer local.Close()
+
+	client, err := t.Connect()
+	if err != nil {
+		log.Printf("ERROR: %v\n", err)
+		return
+	}
+	conn, err := client.Dial("tcp", remote)
+	if err != nil {
+		log.Printf("ERROR: Tunnel failed to connect to '%s', error: %v\n", remote, err)
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{}, 2)
+	copyConn := func(writer net.Conn, reader net.Conn) {
+		io.Copy(writer, reader)
+		done <- struct{}{}
+	}
+	go copyConn(local, conn)
+	go copyConn(conn, local)
+	<-done
+}
+
+// Close the listeners and the SSH connection.
+func (t *Tunnel) Close() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	for remote, listener := range t.forward {
+		listener.Close()
+		delete(t.forward, remote)
+	}
+	if t.client != nil {
+		t.client.Close()
+		t.client = nil
+	}
+	if t.agent != nil {
+		t.agent.Close()
+		t.agent = nil
+	}
+}
@@ -0,0 +1,185 @@
+package dbutils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestTunnelAuth(t *testing.T) {
+	dir := t.TempDir()
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey := func(name string, block *pem.Block) string {
+		file := filepath.Join(dir, name)
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := writeKey("id_plain", block)
+	block, err = ssh.MarshalPrivateKeyWithPassphrase(private, "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := writeKey("id_encrypted", block)
+
+	// ssh-agent holding the key
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: private}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	tests := []struct {
+		name       string
+		keyFile    string
+		passphrase string
+		socket     string
+		methods    int
+		agent      bool
+		err        string
+	}{
+		{"key file", plain, "", "", 1, false, ""},
+		{"encrypted key file", encrypted, "secret", "", 1, false, ""},
+		{"encrypted key file without passphrase", encrypted, "", "", 0, false, "--tunnelPassphrase required"},
+		{"encrypted key file with wrong passphrase", encrypted, "wrong", "", 0, false, "failed to parse"},
+		{"missing key file", filepath.Join(dir, "missing"), "", "", 0, false, "failed to read"},
+		{"agent", "", "", socket, 1, true, ""},
+		{"key file and agent", plain, "", socket, 2, true, ""},
+		{"agent not running", "", "", filepath.Join(dir, "missing.sock"), 0, false, "no SSH authentication"},
+		{"none", "", "", "", 0, false, "no SSH authentication"},
+	}
+
+	for _, test := range tests {
+		o := DBOptions{TunnelPrivateKeyFile: test.keyFile, TunnelPassphrase: test.passphrase}
+		methods, conn, err := tunnelAuth(o, test.socket)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected error containing '%s', got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if len(methods) != test.methods || (conn != nil) != test.agent {
+			t.Errorf("%s: expected %d methods (agent %v), got %d (agent %v)", test.name, test.methods, test.agent, len(methods), conn != nil)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
+
+func TestKnownAlgorithms(t *testing.T) {
+	edPublic, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := ssh.NewPublicKey(edPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPrivate, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := ssh.NewPublicKey(&rsaPrivate.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []string{
+		"# comment",
+		knownhosts.Line([]string{"bastion.example.com"}, edKey),
+		knownhosts.Line([]string{knownhosts.HashHostname("hashed.example.com")}, rsaKey),
+		knownhosts.Line([]string{"[bastion.example.com]:2222"}, rsaKey),
+		knownhosts.Line([]string{"*.internal", "!secret.internal"}, edKey),
+		"@cert-authority *.example.com " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(rsaKey))),
+	}
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rsaAlgorithms := []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA}
+
+	tests := []struct {
+		server   string
+		expected []string
+	}{
+		{"bastion.example.com:22", []string{ssh.KeyAlgoED25519}},
+		{"hashed.example.com:22", rsaAlgorithms},
+		{"bastion.example.com:2222", rsaAlgorithms},
+		{"db.internal:22", []string{ssh.KeyAlgoED25519}},
+		{"secret.internal:22", nil},
+		{"unknown.example.com:22", nil},
+	}
+
+	for _, test := range tests {
+		if got := knownAlgorithms(file, test.server); !slices.Equal(got, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.server, test.expected, got)
+		}
+	}
+
+	if got := knownAlgorithms(filepath.Join(t.TempDir(), "missing"), "bastion.example.com:22"); got != nil {
+		t.Errorf("expected the default algorithms for a missing file, got %v", got)
+	}
+}
+
+func TestCloseTunnels(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	agentConn, agentPeer := net.Pipe()
+	defer agentPeer.Close()
+
+	ds := &DataSource{tunnels: newTunnels()}
+	ds.tunnels.tunnels["tunnel"] = &Tunnel{forward: map[string]net.Listener{"db1:5432": listener}, agent: agentConn}
+	clone := ds.Clone()
+	clone.CloseTunnels()
+
+	// The tunnels are shared with the clone, so closing them from the clone closes the listeners and the ssh-agent connection
+	if len(ds.tunnels.tunnels) != 0 {
+		t.Fatalf("expected no tunnels after close - found %d", len(ds.tunnels.tunnels))
+	}
+	if _, err := listener.Accept(); err == nil {
+		t.Fatalf("expected the forward listener to be closed")
+	}
+	if _, err := agentConn.Write([]byte("x")); err == nil {
+		t.Fatalf("expected the ssh-agent connection to be closed")
+	}
+}
@@ -0,0 +1,349 @@
+package history
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"pgmaven/internal/dbutils"
+)
+
+// ArchiveSuffix is the suffix of every snapshot archive file.
+const ArchiveSuffix = ".json.gz"
+
+// Archive is a single snapshot of one database, stored as gzip compressed JSON in <dir>/<cluster>/<database>/<snapshot_id>.json.gz.
+// The snapshot details are written first so that listing the snapshots does not require the tables to be read.
+type Archive struct {
+	Snapshot ArchiveSnapshot  `json:"snapshot"`
+	Cluster  string           `json:"cluster"`
+	Database string           `json:"database"`
+	Users    map[int64]string `json:"users"`
+	Tables   []Capture        `json:"tables"`
+}
+
+// ArchiveSnapshot is the catalog entry for the snapshot (times that are not known are omitted).
+type ArchiveSnapshot struct {
+	ID              int64      `json:"id"`
+	Time            time.Time  `json:"time"`
+	ServerVersion   string     `json:"server_version"`
+	PostmasterStart *time.Time `json:"postmaster_start_time,omitempty"`
+	StatsReset      *time.Time `json:"stats_reset,omitempty"`
+	StatementsReset *time.Time `json:"statements_reset,omitempty"`
+	Tables          []string   `json:"tables,omitempty"`
+}
+
+// Column is the name and type (as reported by format_type) of a column in one of the Statistics tables.
+type Column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Capture is the content of one of the Statistics tables read from the monitored database.
+type Capture struct {
+	Table   string   `json:"table"`
+	Columns []Column `json:"columns"`
+	Rows    [][]any  `json:"rows"`
+}
+
+// NewArchiveSnapshot returns the archive representation of the snapshot, the id is derived from the time so it is ordered.
+func NewArchiveSnapshot(info dbutils.SnapshotInfo) ArchiveSnapshot {
+	optional := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+
+	return ArchiveSnapshot{ID: info.Time.UnixMilli(), Time: info.Time, ServerVersion: info.ServerVersion,
+		PostmasterStart: optional(info.PostmasterStart), StatsReset: optional(info.StatsReset), StatementsReset: optional(info.StatementsReset),
+		Tables: info.Tables}
+}
+
+// Info returns the snapshot details in the same form as the snapshot catalog.
+func (s ArchiveSnapshot) Info() dbutils.SnapshotInfo {
+	ret := dbutils.SnapshotInfo{ID: s.ID, Time: s.Time, ServerVersion: s.ServerVersion, Tables: s.Tables}
+	if s.PostmasterStart != nil {
+		ret.PostmasterStart = *s.PostmasterStart
+	}
+	if s.StatsReset != nil {
+		ret.StatsReset = *s.StatsReset
+	}
+	if s.StatementsReset != nil {
+		ret.StatementsReset = *s.StatementsReset
+	}
+
+	return ret
+}
+
+// ArchiveDir returns the directory holding the archives for the database.
+func ArchiveDir(dir string, cluster string, database string) string {
+	clean := strings.NewReplacer("/", "_", "\\", "_", ":", "_")
+	return filepath.Join(dir, clean.Replace(cluster), clean.Replace(database))
+}
+
+// WriteArchive writes the archive to the directory, the file is written under a temporary name and then renamed so a partially
+// written archive is never read.
+func WriteArchive(dir string, archive *Archive) (string, error) {
+	target := ArchiveDir(dir, archive.Cluster, archive.Database)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(target, strconv.FormatInt(archive.Snapshot.ID, 10)+ArchiveSuffix)
+	file, err := os.CreateTemp(target, ".snapshot-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+
+	writer := gzip.NewWriter(file)
+	if err := json.NewEncoder(writer).Encode(archive); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := writer.Close(); err != nil {
+		file.Close()
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		return "", err
+	}
+
+	return name, os.Rename(file.Name(), name)
+}
+
+// ArchiveSource is a Source backed by the snapshot archives of a single database.
+type ArchiveSource struct {
+	dir    string
+	loaded *Archive
+}
+
+// NewArchiveSource returns the Source for the archives of the database in the directory.
+func NewArchiveSource(dir string, cluster string, database string) *ArchiveSource {
+	return &ArchiveSource{dir: ArchiveDir(dir, cluster, database)}
+}
+
+func (s *ArchiveSource) file(snapshotID int64) string {
+	return filepath.Join(s.dir, strconv.FormatInt(snapshotID, 10)+ArchiveSuffix)
+}
+
+// ids returns the ids of all the archives (oldest first).
+func (s *ArchiveSource) ids() ([]int64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	ret := make([]int64, 0)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ArchiveSuffix) {
+			continue
+		}
+		if id, err := strconv.ParseInt(strings.TrimSuffix(entry.Name(), ArchiveSuffix), 10, 64); err == nil {
+			ret = append(ret, id)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+
+	return ret, nil
+}
+
+func (s *ArchiveSource) Snapshots() ([]dbutils.SnapshotInfo, error) {
+	ids, err := s.ids()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]dbutils.SnapshotInfo, 0, len(ids))
+	for _, id := range ids {
+		snapshot, err := readSnapshot(s.file(id))
+		if err != nil {
+			return nil, fmt.Errorf("archive '%s': %v", s.file(id), err)
+		}
+		ret = append(ret, snapshot.Info())
+	}
+
+	return ret, nil
+}
+
+func (s *ArchiveSource) Scan(table string, snapshotIDs []int64, columns []string, fn func(snapshotID int64, row Row)) error {
+	for _, id := range snapshotIDs {
+		archive, err := s.load(id)
+		if err != nil {
+			return err
+		}
+		for _, captured := range archive.Tables {
+			if captured.Table != table {
+				continue
+			}
+			captured.scan(id, columns, fn)
+		}
+	}
+
+	return nil
+}
+
+// UserNames returns the users captured in the most recent archive.
+func (s *ArchiveSource) UserNames() (map[int64]string, error) {
+	ids, err := s.ids()
+	if err != nil || len(ids) == 0 {
+		return map[int64]string{}, err
+	}
+
+	archive, err := s.load(ids[len(ids)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	return archive.Users, nil
+}
+
+// Delete removes the archives for the snapshots.
+func (s *ArchiveSource) Delete(snapshotIDs []int64) error {
+	for _, id := range snapshotIDs {
+		if err := os.Remove(s.file(id)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// load reads the archive, the most recent archive is retained as the analysis typically scans the same snapshot repeatedly.
+func (s *ArchiveSource) load(snapshotID int64) (*Archive, error) {
+	if s.loaded != nil && s.loaded.Snapshot.ID == snapshotID {
+		return s.loaded, nil
+	}
+
+	reader, closer, err := openArchive(s.file(snapshotID))
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	var ret Archive
+	if err := decoder.Decode(&ret); err != nil {
+		return nil, fmt.Errorf("archive '%s': %v", s.file(snapshotID), err)
+	}
+	for i := range ret.Tables {
+		ret.Tables[i].convert()
+	}
+	s.loaded = &ret
+
+	return s.loaded, nil
+}
+
+func openArchive(name string) (io.Reader, func(), error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return reader, func() { reader.Close(); file.Close() }, nil
+}
+
+// readSnapshot reads only the snapshot details from the start of the archive.
+func readSnapshot(name string) (ArchiveSnapshot, error) {
+	var ret ArchiveSnapshot
+
+	reader, closer, err := openArchive(name)
+	if err != nil {
+		return ret, err
+	}
+	defer closer()
+
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		return ret, err
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return ret, err
+		}
+		if key == "snapshot" {
+			err = decoder.Decode(&ret)
+			return ret, err
+		}
+		var skip json.RawMessage
+		if err := decoder.Decode(&skip); err != nil {
+			return ret, err
+		}
+	}
+
+	return ret, fmt.Errorf("snapshot details not found")
+}
+
+// convert restores the values to the types returned by the database driver, based on the column metadata.
+func (c *Capture) convert() {
+	for _, row := range c.Rows {
+		for i, value := range row {
+			if i >= len(c.Columns) {
+				break
+			}
+			row[i] = convertValue(c.Columns[i].Type, value)
+		}
+	}
+}
+
+func convertValue(dataType string, value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		switch dataType {
+		case "bigint", "integer", "smallint":
+			if ret, err := v.Int64(); err == nil {
+				return ret
+			}
+		case "double precision", "real":
+			if ret, err := v.Float64(); err == nil {
+				return ret
+			}
+		}
+		return v.String()
+	case string:
+		if strings.HasPrefix(dataType, "timestamp") {
+			if ret, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return ret
+			}
+		}
+	}
+
+	return value
+}
+
+func (c *Capture) scan(snapshotID int64, columns []string, fn func(snapshotID int64, row Row)) {
+	indexes := make(map[string]int, len(c.Columns))
+	for i, col := range c.Columns {
+		indexes[col.Name] = i
+	}
+
+	for _, values := range c.Rows {
+		row := make(Row, len(columns)+1)
+		row["snapshot_id"] = snapshotID
+		for _, column := range columns {
+			if i, ok := indexes[column]; ok && i < len(values) {
+				row[column] = values[i]
+			} else {
+				row[column] = nil
+			}
+		}
+		fn(snapshotID, row)
+	}
+}
@@ -0,0 +1,52 @@
+package history
+
+import (
+	"pgmaven/internal/dbutils"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	columns := []Column{{"userid", "oid"}, {"queryid", "bigint"}, {"calls", "bigint"}, {"total_exec_time", "double precision"}, {"query", "text"}}
+
+	for i := 0; i < 3; i++ {
+		info := dbutils.SnapshotInfo{Time: start.Add(time.Duration(i) * time.Hour), ServerVersion: "16.2", PostmasterStart: start}
+		archive := &Archive{Snapshot: NewArchiveSnapshot(info), Cluster: "db.example.com:5432", Database: "sales", Users: map[int64]string{10: "postgres"},
+			Tables: []Capture{{Table: "pg_stat_statements", Columns: columns, Rows: [][]any{{"10", int64(-4611686018427387904), int64(100 * (i + 1)), 12.5, "SELECT 1"}}}}}
+		if _, err := WriteArchive(dir, archive); err != nil {
+			t.Fatalf("WriteArchive failed: %v", err)
+		}
+	}
+
+	source := NewArchiveSource(dir, "db.example.com:5432", "sales")
+	snapshots, err := source.Snapshots()
+	if err != nil || len(snapshots) != 3 {
+		t.Fatalf("Snapshots: expected 3, got %d (%v)", len(snapshots), err)
+	}
+	if !snapshots[2].Time.Equal(start.Add(2*time.Hour)) || !snapshots[0].PostmasterStart.Equal(start) || !snapshots[0].StatsReset.IsZero() {
+		t.Errorf("Snapshots: unexpected details %+v", snapshots[0])
+	}
+
+	rows, err := Rows(source, "pg_stat_statements", snapshots[1].ID, []string{"userid", "queryid", "calls", "total_exec_time", "missing"})
+	if err != nil || len(rows) != 1 {
+		t.Fatalf("Rows: expected 1, got %d (%v)", len(rows), err)
+	}
+	row := rows[0]
+	if row.Int("queryid") != -4611686018427387904 || row.Int("calls") != 200 || row.Float("total_exec_time") != 12.5 || row.Int("userid") != 10 || row["missing"] != nil {
+		t.Errorf("Rows: unexpected row %v", row)
+	}
+
+	users, err := source.UserNames()
+	if err != nil || users[10] != "postgres" {
+		t.Errorf("UserNames: unexpected %v (%v)", users, err)
+	}
+
+	if err := source.Delete([]int64{snapshots[0].ID}); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if snapshots, _ = source.Snapshots(); len(snapshots) != 2 {
+		t.Errorf("Delete: expected 2 snapshots, got %d", len(snapshots))
+	}
+}
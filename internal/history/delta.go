@@ -0,0 +1,277 @@
+package history
+
+import (
+	"fmt"
+	"pgmaven/internal/dbutils"
+	"time"
+)
+
+// Window is the range of snapshots analyzed, from the snapshot closest to the start time to the snapshot closest to the end time.
+type Window struct {
+	Start     dbutils.SnapshotInfo
+	End       dbutils.SnapshotInfo
+	Snapshots []dbutils.SnapshotInfo
+}
+
+// NewWindow returns the window of snapshots that most closely matches the period requested.
+func NewWindow(source Source, start time.Time, end time.Time) (*Window, error) {
+	snapshots, err := source.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, fmt.Errorf("no snapshots found, has MonitorInitialize been run?")
+	}
+
+	startIndex, endIndex := closest(snapshots, start), closest(snapshots, end)
+
+	return &Window{Start: snapshots[startIndex], End: snapshots[endIndex], Snapshots: snapshots[startIndex : endIndex+1]}, nil
+}
+
+// closest returns the index of the snapshot closest to the time provided (the later one on a tie).
+func closest(snapshots []dbutils.SnapshotInfo, t time.Time) int {
+	ret := 0
+	for i, snapshot := range snapshots {
+		if snapshot.Time.Sub(t).Abs() <= snapshots[ret].Time.Sub(t).Abs() {
+			ret = i
+		}
+	}
+
+	return ret
+}
+
+// Coverage returns the period covered by the window.
+func (w *Window) Coverage() time.Duration {
+	return w.End.Time.Sub(w.Start.Time)
+}
+
+func (w *Window) String() string {
+	return fmt.Sprintf("%v - %v (%v), snapshots: %d - %d", w.Start.Time, w.End.Time, w.Coverage(), w.Start.ID, w.End.ID)
+}
+
+// Reset records a reset of the counters (detected from the snapshot catalog) between two consecutive snapshots.
+type Reset struct {
+	Before dbutils.SnapshotInfo
+	After  dbutils.SnapshotInfo
+	Reason string
+}
+
+// Delta is the change in the counters for a single key (e.g. a query or an index) over the window.
+type Delta struct {
+	Key        string
+	Attributes Row
+	Counters   map[string]float64
+	// First is the earliest snapshot in the window where the key was observed (e.g. an index created during the window)
+	First dbutils.SnapshotInfo
+	// Stitched is set if the counters were reset during the window (and so the delta was stitched across the reset)
+	Stitched bool
+}
+
+// Deltas is the change in the counters for every key in a statistics table over the window.
+type Deltas struct {
+	Window *Window
+	Resets []Reset
+	// KeyResets is the number of keys where the counters went backwards without a reset being recorded in the catalog
+	// (e.g. pg_stat_statements_reset() prior to PostgreSQL 14, or a statement that was evicted and subsequently re-added)
+	KeyResets int
+	// Evicted is the number of keys present at the start of a period but not at the end (e.g. statements evicted from pg_stat_statements)
+	Evicted int
+	Entries map[string]*Delta
+}
+
+// resetReason returns the reason the counters for the table were reset between the two snapshots ("" if not reset).
+func resetReason(table string, before dbutils.SnapshotInfo, after dbutils.SnapshotInfo) string {
+	if !before.PostmasterStart.Equal(after.PostmasterStart) {
+		return "server restart"
+	}
+	if table == "pg_stat_statements" {
+		if !before.StatementsReset.Equal(after.StatementsReset) {
+			return "pg_stat_statements_reset()"
+		}
+		return ""
+	}
+	if !before.StatsReset.Equal(after.StatsReset) {
+		return "pg_stat_reset()"
+	}
+
+	return ""
+}
+
+// Compute the deltas for the counters in the table over the window.  The window is split into periods at every reset recorded
+// in the snapshot catalog, and the deltas for each period are summed (after a reset the counters start from zero).
+// The attributes are taken from the most recent row for each key.
+func Compute(source Source, window *Window, table string, keys []string, counters []string, attributes []string) (*Deltas, error) {
+	ret := &Deltas{Window: window, Resets: make([]Reset, 0), Entries: make(map[string]*Delta)}
+
+	columns := append(append(append([]string{}, keys...), counters...), attributes...)
+
+	// Split the window into periods with no reset
+	periods := [][2]dbutils.SnapshotInfo{{window.Start, window.Start}}
+	for i := 1; i < len(window.Snapshots); i++ {
+		before, after := window.Snapshots[i-1], window.Snapshots[i]
+		if reason := resetReason(table, before, after); reason != "" {
+			ret.Resets = append(ret.Resets, Reset{Before: before, After: after, Reason: reason})
+			periods = append(periods, [2]dbutils.SnapshotInfo{after, after})
+		}
+		periods[len(periods)-1][1] = after
+	}
+
+	for i, period := range periods {
+		// After a reset the counters start from zero (rather than the value at the start of the period)
+		zeroBased := i != 0
+
+		start := make(map[string]Row)
+		if period[0].ID != period[1].ID || zeroBased {
+			rows, err := Rows(source, table, period[0].ID, columns)
+			if err != nil {
+				return nil, err
+			}
+			for _, row := range rows {
+				start[row.Key(keys)] = row
+			}
+		}
+
+		end := start
+		if period[0].ID != period[1].ID {
+			rows, err := Rows(source, table, period[1].ID, columns)
+			if err != nil {
+				return nil, err
+			}
+			end = make(map[string]Row)
+			for _, row := range rows {
+				end[row.Key(keys)] = row
+			}
+		}
+
+		first, err := firstObserved(source, window, table, keys, period, start, end)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, row := range end {
+			previous, found := start[key]
+
+			// If any counter went backwards then the counters for this key were reset during the period
+			decreased := false
+			if found {
+				for _, counter := range counters {
+					if row.Float(counter) < previous.Float(counter) {
+						decreased = true
+						ret.KeyResets++
+						break
+					}
+				}
+			}
+
+			observed := period[0]
+			if !found {
+				observed = first[key]
+			}
+			delta := ret.entry(key, observed)
+			delta.Attributes = row
+			delta.Stitched = delta.Stitched || decreased
+			for _, counter := range counters {
+				delta.Counters[counter] += row.Float(counter)
+				if found && decreased == zeroBased {
+					// Either the activity since the start of the period (no reset) or the activity from the catalog reset until the key reset
+					if zeroBased {
+						delta.Counters[counter] += previous.Float(counter)
+					} else {
+						delta.Counters[counter] -= previous.Float(counter)
+					}
+				}
+			}
+		}
+
+		for key, row := range start {
+			if _, found := end[key]; !found {
+				ret.Evicted++
+				// The activity from the catalog reset to the start of the period is known
+				if zeroBased {
+					delta := ret.entry(key, period[0])
+					if delta.Attributes == nil {
+						delta.Attributes = row
+					}
+					for _, counter := range counters {
+						delta.Counters[counter] += row.Float(counter)
+					}
+				}
+			}
+		}
+	}
+
+	// Everything is stitched if there was a reset recorded in the catalog
+	if len(ret.Resets) != 0 {
+		for _, delta := range ret.Entries {
+			delta.Stitched = true
+		}
+	}
+
+	return ret, nil
+}
+
+// firstObserved returns the first snapshot in the period where each key present at the end (but not the start) of the period was observed.
+func firstObserved(source Source, window *Window, table string, keys []string, period [2]dbutils.SnapshotInfo, start map[string]Row, end map[string]Row) (map[string]dbutils.SnapshotInfo, error) {
+	ret := make(map[string]dbutils.SnapshotInfo)
+	for key := range end {
+		if _, found := start[key]; !found {
+			ret[key] = period[1]
+		}
+	}
+	if len(ret) == 0 {
+		return ret, nil
+	}
+
+	// Only the snapshots strictly inside the period need to be checked
+	snapshots := make(map[int64]dbutils.SnapshotInfo)
+	for _, snapshot := range window.Snapshots {
+		if snapshot.ID > period[0].ID && snapshot.ID < period[1].ID {
+			snapshots[snapshot.ID] = snapshot
+		}
+	}
+	if len(snapshots) == 0 {
+		return ret, nil
+	}
+
+	err := source.Scan(table, SnapshotIDs(window.Snapshots, period[0].ID+1, period[1].ID-1), keys, func(snapshotID int64, row Row) {
+		key := row.Key(keys)
+		if observed, ok := ret[key]; ok && snapshots[snapshotID].Time.Before(observed.Time) {
+			ret[key] = snapshots[snapshotID]
+		}
+	})
+
+	return ret, err
+}
+
+// entry returns the delta for the key (creating it if required, when it was first observed in the snapshot provided).
+func (d *Deltas) entry(key string, observed dbutils.SnapshotInfo) *Delta {
+	delta, ok := d.Entries[key]
+	if !ok {
+		delta = &Delta{Key: key, Counters: make(map[string]float64), First: observed}
+		d.Entries[key] = delta
+	}
+
+	return delta
+}
+
+// Coverage returns the period of the window over which the key was observed.
+func (d *Delta) Coverage(window *Window) time.Duration {
+	return window.End.Time.Sub(d.First.Time)
+}
+
+// Warnings describes the resets detected in the window, activity in the affected periods may be under-reported.
+func (d *Deltas) Warnings() []string {
+	ret := make([]string, 0)
+	for _, reset := range d.Resets {
+		ret = append(ret, fmt.Sprintf("counters reset (%s) between snapshots %d (%v) and %d (%v) - activity between these snapshots prior to the reset is not included",
+			reset.Reason, reset.Before.ID, reset.Before.Time, reset.After.ID, reset.After.Time))
+	}
+	if d.KeyResets != 0 {
+		ret = append(ret, fmt.Sprintf("counters for %d entries went backwards during the window (reset or evicted and re-added) - only activity since the reset is included", d.KeyResets))
+	}
+	if d.Evicted != 0 {
+		ret = append(ret, fmt.Sprintf("%d entries present at the start of the window (or a reset) were not present at the end (evicted or dropped) and are not included", d.Evicted))
+	}
+
+	return ret
+}
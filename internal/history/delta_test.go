@@ -0,0 +1,121 @@
+package history
+
+import (
+	"pgmaven/internal/dbutils"
+	"testing"
+	"time"
+)
+
+type memorySource struct {
+	snapshots []dbutils.SnapshotInfo
+	rows      map[int64][]Row
+}
+
+func (s *memorySource) Snapshots() ([]dbutils.SnapshotInfo, error) {
+	return s.snapshots, nil
+}
+
+func (s *memorySource) Scan(table string, snapshotIDs []int64, columns []string, fn func(snapshotID int64, row Row)) error {
+	for _, snapshotID := range snapshotIDs {
+		for _, row := range s.rows[snapshotID] {
+			fn(snapshotID, row)
+		}
+	}
+
+	return nil
+}
+
+func (s *memorySource) UserNames() (map[int64]string, error) {
+	return map[int64]string{}, nil
+}
+
+func TestCompute(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	reset := start.Add(36 * time.Hour)
+	source := &memorySource{rows: make(map[int64][]Row)}
+	for i := 0; i < 4; i++ {
+		snapshot := dbutils.SnapshotInfo{ID: int64(i + 1), Time: start.Add(time.Duration(i) * 24 * time.Hour)}
+		if snapshot.Time.After(reset) {
+			snapshot.StatementsReset = reset
+		}
+		source.snapshots = append(source.snapshots, snapshot)
+	}
+
+	// Query 1 - 100 calls per day, reset after day 1
+	// Query 2 - counters go backwards between day 2 and day 3
+	// Query 3 - evicted after day 0
+	source.rows[1] = []Row{{"queryid": int64(1), "calls": int64(100)}, {"queryid": int64(2), "calls": int64(10)}, {"queryid": int64(3), "calls": int64(5)}}
+	source.rows[2] = []Row{{"queryid": int64(1), "calls": int64(200)}, {"queryid": int64(2), "calls": int64(20)}}
+	source.rows[3] = []Row{{"queryid": int64(1), "calls": int64(50)}, {"queryid": int64(2), "calls": int64(30)}}
+	source.rows[4] = []Row{{"queryid": int64(1), "calls": int64(150)}, {"queryid": int64(2), "calls": int64(5)}}
+
+	window, err := NewWindow(source, start, start.Add(72*time.Hour))
+	if err != nil {
+		t.Fatalf("NewWindow failed: %v", err)
+	}
+	if window.Start.ID != 1 || window.End.ID != 4 || window.Coverage() != 72*time.Hour {
+		t.Fatalf("unexpected window: %v", window)
+	}
+
+	deltas, err := Compute(source, window, "pg_stat_statements", []string{"queryid"}, []string{"calls"}, nil)
+	if err != nil {
+		t.Fatalf("Compute failed: %v", err)
+	}
+
+	if len(deltas.Resets) != 1 || deltas.Resets[0].Before.ID != 2 || deltas.Resets[0].After.ID != 3 {
+		t.Fatalf("expected reset between snapshots 2 and 3 - found %v", deltas.Resets)
+	}
+	if calls := deltas.Entries["1"].Counters["calls"]; calls != 250 {
+		t.Fatalf("query 1 calls should be 250 - found %v", calls)
+	}
+	if calls := deltas.Entries["2"].Counters["calls"]; calls != 45 {
+		t.Fatalf("query 2 calls should be 45 - found %v", calls)
+	}
+	if deltas.KeyResets != 1 || deltas.Evicted != 1 || !deltas.Entries["1"].Stitched {
+		t.Fatalf("unexpected reset tracking: KeyResets %d, Evicted %d", deltas.KeyResets, deltas.Evicted)
+	}
+	if deltas.Entries["2"].First.ID != 1 || deltas.Entries["1"].Coverage(window) != 72*time.Hour {
+		t.Fatalf("unexpected coverage")
+	}
+	if len(deltas.Warnings()) != 3 {
+		t.Fatalf("expected 3 warnings - found %v", deltas.Warnings())
+	}
+
+	// pg_stat_user_indexes is not affected by pg_stat_statements_reset()
+	deltas, _ = Compute(source, window, "pg_stat_user_indexes", []string{"queryid"}, []string{"calls"}, nil)
+	if len(deltas.Resets) != 0 {
+		t.Fatalf("unexpected reset for pg_stat_user_indexes")
+	}
+}
+
+func TestComputeCreatedDuringWindow(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	source := &memorySource{rows: make(map[int64][]Row)}
+	for i := 0; i < 4; i++ {
+		source.snapshots = append(source.snapshots, dbutils.SnapshotInfo{ID: int64(i + 1), Time: start.Add(time.Duration(i) * 24 * time.Hour)})
+	}
+
+	// Index 1 - present throughout
+	// Index 2 - created after day 1 (first observed on day 2)
+	// Index 3 - created after day 2 (only observed at the end of the window)
+	source.rows[1] = []Row{{"indexrelid": int64(1), "idx_scan": int64(10)}}
+	source.rows[2] = []Row{{"indexrelid": int64(1), "idx_scan": int64(20)}}
+	source.rows[3] = []Row{{"indexrelid": int64(1), "idx_scan": int64(30)}, {"indexrelid": int64(2), "idx_scan": int64(0)}}
+	source.rows[4] = []Row{{"indexrelid": int64(1), "idx_scan": int64(40)}, {"indexrelid": int64(2), "idx_scan": int64(0)}, {"indexrelid": int64(3), "idx_scan": int64(0)}}
+
+	window, _ := NewWindow(source, start, start.Add(72*time.Hour))
+	deltas, err := Compute(source, window, "pg_stat_user_indexes", []string{"indexrelid"}, []string{"idx_scan"}, nil)
+	if err != nil {
+		t.Fatalf("Compute failed: %v", err)
+	}
+
+	if len(deltas.Resets) != 0 || deltas.Entries["1"].Counters["idx_scan"] != 30 {
+		t.Fatalf("unexpected deltas for index 1: %v", deltas.Entries["1"])
+	}
+	if deltas.Entries["2"].First.ID != 3 || deltas.Entries["2"].Coverage(window) != 24*time.Hour {
+		t.Fatalf("index 2 should be first observed in snapshot 3 - found %d", deltas.Entries["2"].First.ID)
+	}
+	if deltas.Entries["3"].First.ID != 4 || deltas.Entries["3"].Coverage(window) != 0 {
+		t.Fatalf("index 3 should be first observed in snapshot 4 - found %d", deltas.Entries["3"].First.ID)
+	}
+}
@@ -0,0 +1,172 @@
+package history
+
+import (
+	"database/sql"
+	"fmt"
+	"pgmaven/internal/dbutils"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Row is a single row from a snapshot of a statistics table, keyed by column name.
+type Row map[string]any
+
+// Source provides access to the snapshot history (i.e. the pgmaven_<table> tables and the snapshot catalog).
+type Source interface {
+	// Snapshots returns all the snapshots (oldest first)
+	Snapshots() ([]dbutils.SnapshotInfo, error)
+	// Scan invokes the function for every row of the table captured in the snapshots provided
+	Scan(table string, snapshotIDs []int64, columns []string, fn func(snapshotID int64, row Row)) error
+	// UserNames returns a map from the user id to the user name
+	UserNames() (map[int64]string, error)
+}
+
+// Filter returns the Source restricted to the snapshots that captured all the tables provided (a snapshot may capture only some
+// of the tables, e.g. pg_stat_activity is typically captured more frequently than the others).
+func Filter(source Source, tables ...string) Source {
+	return &filteredSource{Source: source, tables: tables}
+}
+
+type filteredSource struct {
+	Source
+	tables []string
+}
+
+func (s *filteredSource) Snapshots() ([]dbutils.SnapshotInfo, error) {
+	snapshots, err := s.Source.Snapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]dbutils.SnapshotInfo, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		included := true
+		for _, table := range s.tables {
+			included = included && snapshot.Includes(table)
+		}
+		if included {
+			ret = append(ret, snapshot)
+		}
+	}
+
+	return ret, nil
+}
+
+// Rows returns the rows of the table captured in the snapshot.
+func Rows(source Source, table string, snapshotID int64, columns []string) ([]Row, error) {
+	ret := make([]Row, 0)
+	err := source.Scan(table, []int64{snapshotID}, columns, func(snapshotID int64, row Row) {
+		ret = append(ret, row)
+	})
+
+	return ret, err
+}
+
+// SnapshotIDs returns the ids of the snapshots between (inclusive) the two snapshots provided.
+func SnapshotIDs(snapshots []dbutils.SnapshotInfo, from int64, to int64) []int64 {
+	ret := make([]int64, 0)
+	for _, snapshot := range snapshots {
+		if snapshot.ID >= from && snapshot.ID <= to {
+			ret = append(ret, snapshot.ID)
+		}
+	}
+
+	return ret
+}
+
+// NewSource returns the Source for the DataSource - either the archive directory, the repository or the monitored database.
+func NewSource(ds *dbutils.DataSource) Source {
+	if ds.GetArchive() != "" {
+		return NewArchiveSource(ds.GetArchive(), ds.GetCluster(), ds.GetDBName())
+	}
+
+	return &DatabaseSource{datasource: ds}
+}
+
+// DatabaseSource is a Source backed by the pgmaven_<table> tables, either in the monitored database or the repository.
+type DatabaseSource struct {
+	datasource *dbutils.DataSource
+}
+
+func (s *DatabaseSource) Snapshots() ([]dbutils.SnapshotInfo, error) {
+	return s.datasource.Snapshots()
+}
+
+func (s *DatabaseSource) UserNames() (map[int64]string, error) {
+	return s.datasource.UserNames()
+}
+
+type scanState struct {
+	fn func(snapshotID int64, row Row)
+}
+
+func (s *DatabaseSource) Scan(table string, snapshotIDs []int64, columns []string, fn func(snapshotID int64, row Row)) error {
+	query := fmt.Sprintf("SELECT snapshot_id, %s FROM pgmaven_%s WHERE snapshot_id = ANY($1)", strings.Join(columns, ", "), table)
+
+	return s.datasource.History().ExecuteQueryRows(query, []any{pq.Array(snapshotIDs)}, rowProcessor, &scanState{fn})
+}
+
+func rowProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
+	state := self.(*scanState)
+	row := make(Row, len(columnTypes))
+	for i, columnType := range columnTypes {
+		value := *values[i].(*interface{})
+		// Names are returned as []uint8
+		if bytes, ok := value.([]uint8); ok {
+			value = string(bytes)
+		}
+		row[columnType.Name()] = value
+	}
+	state.fn(row.Int("snapshot_id"), row)
+}
+
+// Key returns the value of the columns provided as a single string.
+func (r Row) Key(columns []string) string {
+	parts := make([]string, len(columns))
+	for i, column := range columns {
+		parts[i] = fmt.Sprint(r[column])
+	}
+
+	return strings.Join(parts, ".")
+}
+
+// Float returns the value of the column as a float64 (0 if it is not present or not numeric).
+func (r Row) Float(column string) float64 {
+	switch v := r[column].(type) {
+	case int64:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int:
+		return float64(v)
+	case float64:
+		return v
+	case float32:
+		return float64(v)
+	case string:
+		ret, _ := strconv.ParseFloat(v, 64)
+		return ret
+	}
+
+	return 0
+}
+
+// String returns the value of the column as a string.
+func (r Row) String(column string) string {
+	if r[column] == nil {
+		return ""
+	}
+
+	return fmt.Sprint(r[column])
+}
+
+// Int returns the value of the column as an int64.
+func (r Row) Int(column string) int64 {
+	if v, ok := r[column].(int64); ok {
+		return v
+	}
+
+	return int64(r.Float(column))
+}
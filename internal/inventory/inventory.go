@@ -0,0 +1,272 @@
+package inventory
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"pgmaven/internal/dbutils"
+)
+
+// Inventory is the content of an inventory file, the set of databases (potentially across many hosts and clusters) to process.
+type Inventory struct {
+	Databases []Entry `yaml:"databases"`
+}
+
+// Entry is a single database, options that are not specified default to the corresponding command line option.
+type Entry struct {
+	Name           string            `yaml:"name"`
+	Cluster        string            `yaml:"cluster"`
+	Host           string            `yaml:"host"`
+	Port           int               `yaml:"port"`
+	Database       string            `yaml:"database"`
+	User           string            `yaml:"user"`
+	Schema         string            `yaml:"schema"` // comma separated, as for --schema
+	AllSchemas     bool              `yaml:"allSchemas"`
+	ExcludeSchemas []string          `yaml:"excludeSchemas"`
+	SSLMode        string            `yaml:"sslmode"`
+	HostMemory     string            `yaml:"hostMemory"`
+	HostCPUs       int               `yaml:"hostCPUs"`
+	HostStorage    string            `yaml:"hostStorage"`
+	Workload       string            `yaml:"workload"`
+	Tunnel         *Tunnel           `yaml:"tunnel"`
+	Tags           map[string]string `yaml:"tags"`
+}
+
+// Tunnel is the SSH tunnel used to reach the database server.
+type Tunnel struct {
+	Host           string `yaml:"host"`
+	Port           int    `yaml:"port"`
+	User           string `yaml:"user"`
+	PrivateKeyFile string `yaml:"privateKeyFile"`
+	Passphrase     string `yaml:"passphrase"`
+	KnownHosts     string `yaml:"knownHosts"`
+}
+
+// Load reads (and validates) the inventory file.
+func Load(name string) (*Inventory, error) {
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory file '%s', error: %v", name, err)
+	}
+
+	var ret Inventory
+	if err := yaml.Unmarshal(content, &ret); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory file '%s', error: %v", name, err)
+	}
+
+	for i, entry := range ret.Databases {
+		if entry.Tunnel != nil && entry.Tunnel.Host == "" {
+			return nil, fmt.Errorf("inventory file '%s', entry %d: tunnel host not specified", name, i+1)
+		}
+		profile := dbutils.DBOptions{HostMemory: entry.HostMemory, HostCPUs: entry.HostCPUs, HostStorage: entry.HostStorage, Workload: entry.Workload}
+		if err := profile.ValidateHostProfile(); err != nil {
+			return nil, fmt.Errorf("inventory file '%s', entry %d: %v", name, i+1, err)
+		}
+	}
+
+	return &ret, nil
+}
+
+// ParseTags parses the tags used to select entries, each of the form name=value.
+func ParseTags(tags []string) (map[string]string, error) {
+	ret := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		name, value, found := strings.Cut(tag, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("tag '%s' invalid, should be name=value", tag)
+		}
+		ret[name] = value
+	}
+
+	return ret, nil
+}
+
+// Matches returns true if the entry has all the tags.
+func (e *Entry) Matches(tags map[string]string) bool {
+	for name, value := range tags {
+		if e.Tags[name] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Options returns the options used to connect to the database, based on the command line options.
+func (e *Entry) Options(o dbutils.DBOptions) dbutils.DBOptions {
+	ret := o
+	if e.Cluster != "" {
+		ret.Cluster = e.Cluster
+	}
+	if e.Host != "" {
+		ret.Host = e.Host
+		// The cluster defaults to host:port of the entry, not the command line
+		if e.Cluster == "" {
+			ret.Cluster = ""
+		}
+	}
+	if e.Port != 0 {
+		ret.Port = e.Port
+	}
+	if e.User != "" {
+		ret.Username = e.User
+	}
+	// The schemas of the entry replace (rather than add to) those on the command line
+	if e.Schema != "" {
+		ret.Schemas, ret.AllSchemas = strings.Split(e.Schema, ","), false
+	}
+	if e.AllSchemas {
+		ret.AllSchemas = true
+	}
+	if e.ExcludeSchemas != nil {
+		ret.ExcludeSchemas = e.ExcludeSchemas
+	}
+	if e.SSLMode != "" {
+		ret.SSLMode = e.SSLMode
+	}
+	// The host profile describes the server of the entry, so is not inherited from the command line if the entry has its own host
+	if e.Host != "" {
+		ret.HostMemory, ret.HostCPUs = "", 0
+	}
+	if e.HostMemory != "" {
+		ret.HostMemory = e.HostMemory
+	}
+	if e.HostCPUs != 0 {
+		ret.HostCPUs = e.HostCPUs
+	}
+	if e.HostStorage != "" {
+		ret.HostStorage = e.HostStorage
+	}
+	if e.Workload != "" {
+		ret.Workload = e.Workload
+	}
+	if e.Tunnel != nil {
+		ret.TunnelHost = e.Tunnel.Host
+		ret.TunnelPort = dbutils.DefaultTunnelPort
+		if e.Tunnel.Port != 0 {
+			ret.TunnelPort = e.Tunnel.Port
+		}
+		if e.Tunnel.User != "" {
+			ret.TunnelUsername = e.Tunnel.User
+		}
+		if e.Tunnel.PrivateKeyFile != "" {
+			ret.TunnelPrivateKeyFile = expand(e.Tunnel.PrivateKeyFile)
+			ret.TunnelPassphrase = e.Tunnel.Passphrase
+		}
+		if e.Tunnel.KnownHosts != "" {
+			ret.TunnelKnownHosts = expand(e.Tunnel.KnownHosts)
+		}
+	}
+
+	return ret
+}
+
+// Targets returns the targets for the entries with all the tags, in the order of the inventory.  The name of each target
+// defaults to cluster/database.  With --all-databases there is a target per cluster, for the maintenance database.
+func (i *Inventory) Targets(o dbutils.DBOptions, tags map[string]string) ([]dbutils.Target, error) {
+	ret := make([]dbutils.Target, 0, len(i.Databases))
+	seen := make(map[string]bool)
+	for n, entry := range i.Databases {
+		if !entry.Matches(tags) {
+			continue
+		}
+		if o.AllDatabases {
+			server := dbutils.Target{DBName: o.MaintenanceDB, Options: entry.Options(o)}
+			server.Name = server.GetCluster()
+			if !seen[server.Name] {
+				seen[server.Name] = true
+				ret = append(ret, server)
+			}
+			continue
+		}
+		if entry.Database == "" {
+			return nil, fmt.Errorf("inventory entry %d: database not specified", n+1)
+		}
+		target := dbutils.Target{Name: entry.Name, DBName: entry.Database, Options: entry.Options(o)}
+		if target.Name == "" {
+			target.Name = target.GetCluster() + "/" + entry.Database
+		}
+		if seen[target.Name] {
+			return nil, fmt.Errorf("inventory entry '%s' is duplicated", target.Name)
+		}
+		seen[target.Name] = true
+		ret = append(ret, target)
+	}
+
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no inventory entries match the tags %s", formatTags(tags))
+	}
+
+	return ret, nil
+}
+
+func formatTags(tags map[string]string) string {
+	parts := make([]string, 0, len(tags))
+	for name, value := range tags {
+		parts = append(parts, name+"="+value)
+	}
+	sort.Strings(parts)
+
+	return "'" + strings.Join(parts, ",") + "'"
+}
+
+func expand(name string) string {
+	if strings.HasPrefix(name, "~/") {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(homeDir, name[2:])
+		}
+	}
+
+	return name
+}
+
+// Targets returns the databases to process, from the inventory file (selected by tag), the --dbnames file or the single --dbname.
+// With --all-databases the targets are the servers on which the databases are to be discovered (see dbutils.Discover).
+func Targets(o dbutils.DBOptions) ([]dbutils.Target, error) {
+	tags, err := ParseTags(o.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.AllDatabases && (o.DBName != "" || o.DBNames != "") {
+		return nil, fmt.Errorf("cannot specify --all-databases with the dbname or dbnames options")
+	}
+
+	if o.Inventory != "" {
+		if o.DBNames != "" {
+			return nil, fmt.Errorf("cannot specify both inventory and dbnames options")
+		}
+		inventory, err := Load(o.Inventory)
+		if err != nil {
+			return nil, err
+		}
+		return inventory.Targets(o, tags)
+	}
+	if len(tags) != 0 {
+		return nil, fmt.Errorf("--tags requires an --inventory")
+	}
+
+	if o.AllDatabases {
+		return []dbutils.Target{{Name: o.GetCluster(), DBName: o.MaintenanceDB, Options: o}}, nil
+	}
+
+	if o.DBNames != "" {
+		content, err := os.ReadFile(o.DBNames)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file, error %v", err)
+		}
+		return dbutils.NewTargets(o, strings.Split(string(content), "\n"))
+	}
+
+	dbName := o.DBName
+	if dbName == "" {
+		dbName = dbutils.DefaultDBName
+	}
+
+	return dbutils.NewTargets(o, []string{dbName})
+}
@@ -0,0 +1,99 @@
+package inventory
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"pgmaven/internal/dbutils"
+)
+
+func TestTargets(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "inventory.yaml")
+	content := `
+databases:
+  - host: db1.eu.example.com
+    database: sales
+    schema: sales,tenant_*
+    tags: {env: prod, tier: gold}
+  - host: db2.eu.example.com
+    port: 6432
+    database: sales
+    user: reporter
+    hostMemory: 256GB
+    workload: olap
+    tunnel: {host: bastion.eu.example.com, user: ops}
+    tags: {env: prod, tier: silver}
+  - name: staging-sales
+    cluster: staging
+    database: sales
+    tags: {env: staging}
+`
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	base := dbutils.DBOptions{Host: "localhost", Port: 5432, Username: "monitor", Schemas: []string{"public"}, AllSchemas: true, Cluster: "default", Inventory: name,
+		HostMemory: "32GB"}
+	targets, err := Targets(base)
+	if err != nil {
+		t.Fatalf("Targets failed: %v", err)
+	}
+	if len(targets) != 3 {
+		t.Fatalf("expected 3 targets, found %d", len(targets))
+	}
+
+	first, second, third := targets[0], targets[1], targets[2]
+	if first.Name != "db1.eu.example.com:5432/sales" || strings.Join(first.Options.Schemas, ",") != "sales,tenant_*" || first.Options.AllSchemas || first.Options.Username != "monitor" {
+		t.Errorf("unexpected first target %+v", first)
+	}
+	if second.Name != "db2.eu.example.com:6432/sales" || second.Options.Username != "reporter" ||
+		second.Options.TunnelHost != "bastion.eu.example.com" || second.Options.TunnelPort != dbutils.DefaultTunnelPort ||
+		second.Options.HostMemory != "256GB" || second.Options.Workload != dbutils.WorkloadOLAP {
+		t.Errorf("unexpected second target %+v", second)
+	}
+	if first.Options.HostMemory != "" {
+		t.Errorf("host memory should not be inherited by an entry with its own host %+v", first)
+	}
+	if third.Name != "staging-sales" || third.GetCluster() != "staging" || third.Options.Host != "localhost" || third.Options.HostMemory != "32GB" {
+		t.Errorf("unexpected third target %+v", third)
+	}
+
+	base.Tags = []string{"env=prod", "tier=gold"}
+	if targets, err = Targets(base); err != nil || len(targets) != 1 || targets[0].Options.Host != "db1.eu.example.com" {
+		t.Errorf("expected only db1 to be selected, found %v (%v)", targets, err)
+	}
+
+	base.Tags = []string{"env=test"}
+	if _, err = Targets(base); err == nil {
+		t.Errorf("expected error when no entries match")
+	}
+
+	base.Tags = []string{"env"}
+	if _, err = Targets(base); err == nil {
+		t.Errorf("expected error for invalid tag")
+	}
+}
+
+func TestTargetsAllDatabases(t *testing.T) {
+	inventory := &Inventory{Databases: []Entry{
+		{Host: "db1.example.com", Database: "sales"},
+		{Host: "db1.example.com", Database: "billing"},
+		{Host: "db2.example.com"},
+	}}
+
+	base := dbutils.DBOptions{Host: "localhost", Port: 5432, MaintenanceDB: dbutils.DefaultMaintenanceDB, AllDatabases: true}
+	servers, err := inventory.Targets(base, nil)
+	if err != nil {
+		t.Fatalf("Targets failed: %v", err)
+	}
+	if len(servers) != 2 || servers[0].Name != "db1.example.com:5432" || servers[1].DBName != dbutils.DefaultMaintenanceDB {
+		t.Errorf("expected a target per server, found %+v", servers)
+	}
+
+	base.AllDatabases = false
+	if _, err := inventory.Targets(base, nil); err == nil {
+		t.Errorf("expected error for entry without a database")
+	}
+}
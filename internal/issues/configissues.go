@@ -5,20 +5,49 @@ import (
 	"fmt"
 	"log"
 	"pgmaven/internal/dbutils"
+	"pgmaven/internal/history"
 	"pgmaven/internal/utils"
 	"strconv"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type setting struct {
-	value string
-	units string
+	value   string
+	units   string
+	context string // pg_settings.context, determines whether a change requires a restart or a reload
+}
+
+func (s setting) int() int64 {
+	ret, _ := strconv.ParseInt(s.value, 10, 64)
+	return ret
+}
+
+func (s setting) float() float64 {
+	ret, _ := strconv.ParseFloat(s.value, 64)
+	return ret
+}
+
+func (s setting) bytes() int64 {
+	return utils.PgUnitsToBytes(s.int(), s.units)
+}
+
+// milliseconds returns the value of a time setting (e.g. with units ms, s or min), negative values (typically -1) are unchanged.
+func (s setting) milliseconds() int64 {
+	multiplier := map[string]int64{"ms": 1, "s": 1000, "min": 60 * 1000, "h": 60 * 60 * 1000, "d": 24 * 60 * 60 * 1000}[s.units]
+	if v := s.int(); v > 0 && multiplier != 0 {
+		return v * multiplier
+	}
+
+	return s.int()
 }
 
 type ConfigIssues struct {
 	datasource *dbutils.DataSource
 	context    utils.Context
 	settings   map[string]setting
+	profile    dbutils.HostProfile
 	issues     []utils.Issue
 	timing     utils.Timing
 }
@@ -35,41 +64,54 @@ func (d *ConfigIssues) Execute(args ...string) {
 	d.issues = make([]utils.Issue, 0)
 
 	query := `
-SELECT name, setting, unit FROM pg_settings where name in (
+SELECT name, setting, unit, context FROM pg_settings where name in (
+	'autovacuum',
+	'autovacuum_analyze_scale_factor',
+	'autovacuum_max_workers',
+	'autovacuum_naptime',
+	'autovacuum_vacuum_cost_delay',
+	'autovacuum_vacuum_cost_limit',
+	'autovacuum_vacuum_scale_factor',
 	'checkpoint_completion_target',
 	'default_statistics_target',
 	'effective_cache_size',
+	'effective_io_concurrency',
+	'huge_pages',
+	'idle_in_transaction_session_timeout',
+	'log_min_duration_statement',
 	'maintenance_work_mem',
 	'max_connections',
+	'max_parallel_maintenance_workers',
+	'max_parallel_workers',
+	'max_parallel_workers_per_gather',
+	'max_wal_size',
+	'max_worker_processes',
+	'min_wal_size',
+	'pg_stat_statements.max',
+	'random_page_cost',
 	'shared_buffers',
+	'track_io_timing',
+	'wal_buffers',
 	'work_mem'
 )`
-	// 'effective_io_concurrency',
-	// 'huge_pages',
-	// 'min_wal_size',
-	// 'max_wal_size',
-	// 'max_parallel_workers_per_gather',
-	// 'max_parallel_workers',
-	// 'max_parallel_maintenance_workers',
-	// 'max_worker_processes',
-	// 'random_page_cost',
-	// 'wal_buffers',
 
 	err := d.datasource.ExecuteQueryRows(query, nil, configIssuesProcessor, d)
 
 	if err != nil {
-		fmt.Printf("ERROR: Database: %s, ConfigIssues: failed to get DB settings, error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, ConfigIssues: failed to get DB settings, error: %v\n", d.datasource.GetName(), err)
 		return
 	}
 
+	d.profile = d.datasource.GetHostProfile()
+	if d.profile.Memory == 0 {
+		log.Printf("WARNING: Database: %s, ConfigIssues: host RAM unknown (use --host-memory), memory settings not analyzed\n", d.datasource.GetName())
+	}
+
 	d.analyzeSettings()
 
 	d.timing.SetDurationMS(time.Now().UnixMilli() - startMS)
 }
 
-var memoryTotal = 128 * 1024 * 1024 * 1024
-var memoryBuffers = memoryTotal / (8 * 1024)
-
 func configIssuesProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
 	d := self.(*ConfigIssues)
 	name := (*values[0].(*interface{})).(string)
@@ -79,26 +121,62 @@ func configIssuesProcessor(rowNumber int, columnTypes []*sql.ColumnType, values
 	if unit != nil {
 		units = unit.(string)
 	}
+	context := (*values[3].(*interface{})).(string)
+
+	d.settings[name] = setting{value, units, context}
+}
+
+// maxActiveObserved returns the maximum number of active connections observed in any snapshot.  This is computed by the database
+// (the snapshots may span months in a repository), only an archive is scanned.
+func (d *ConfigIssues) maxActiveObserved() (int64, error) {
+	source := history.Filter(history.NewSource(d.datasource), "pg_stat_activity")
+	snapshots, err := source.Snapshots()
+	if err != nil || len(snapshots) == 0 {
+		return 0, err
+	}
+	snapshotIDs := history.SnapshotIDs(snapshots, snapshots[0].ID, snapshots[len(snapshots)-1].ID)
+
+	if d.datasource.GetArchive() == "" {
+		maxObservedQuery := `
+select coalesce(max(cnt), 0) from (
+	select count(*) as cnt from pgmaven_pg_stat_activity where snapshot_id = ANY($1) and state = 'active' group by snapshot_id
+) as active`
+		ret, err := d.datasource.History().ExecuteQueryRow(maxObservedQuery, []any{pq.Array(snapshotIDs)})
+		if err != nil {
+			return 0, err
+		}
+		return ret.(int64), nil
+	}
+
+	active := make(map[int64]int64)
+	err = source.Scan("pg_stat_activity", snapshotIDs, []string{"state"}, func(snapshotID int64, row history.Row) {
+		if row.String("state") == "active" {
+			active[snapshotID]++
+		}
+	})
+
+	var ret int64
+	for _, count := range active {
+		ret = max(ret, count)
+	}
 
-	d.settings[name] = setting{value, units}
+	return ret, err
 }
 
 func (d *ConfigIssues) analyzeSettings() {
 	// Need to check max_connections first - since we are going to use this in other settings calculations
-	maxObservedQuery := `select max(cnt) from (select count(*) as cnt, insert_dt from pgmaven_pg_stat_activity where state = 'active' group by insert_dt) as foo`
-	maxConnectionsObserved, err := d.datasource.ExecuteQueryRow(maxObservedQuery, nil)
+	maxConnectionsObserved, err := d.maxActiveObserved()
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Query '%s' failed with error: %v\n", d.datasource.GetDBName(), maxObservedQuery, err)
+		log.Printf("ERROR: Database: %s, ConfigIssues failed to determine the maximum active connections observed, error: %v\n", d.datasource.GetName(), err)
 		return
 	}
 
 	name := "max_connections"
 	s := d.settings[name]
 	maxConnectionsSetting, _ := strconv.Atoi(s.value)
-	if maxConnectionsSetting > 200 && maxConnectionsObserved.(int64)*15 < 2000 {
-		d.issues = append(d.issues, utils.Issue{IssueType: "Config", Target: name,
-			Detail:   fmt.Sprintf("Setting: %s, value: %s - excessively large, maximum observed: %d\n", name, s.value, maxConnectionsObserved),
-			Severity: utils.High, Solution: "Update postgresql.conf - 'max_connections = 200'\n"})
+	if maxConnectionsSetting > 200 && maxConnectionsObserved*15 < 2000 {
+		d.addIssue(name, utils.High, fmt.Sprintf("Setting: %s, value: %s - excessively large, maximum observed: %d\n", name, s.value, maxConnectionsObserved),
+			d.update(name, "200"))
 		maxConnectionsSetting = 200
 	}
 
@@ -108,75 +186,101 @@ func (d *ConfigIssues) analyzeSettings() {
 			// Target = .9
 			currentValue, _ := strconv.ParseFloat(s.value, 32)
 			if currentValue < .85 || currentValue > .95 {
-				d.issues = append(d.issues, utils.Issue{IssueType: "Config", Target: name,
-					Detail:   fmt.Sprintf("Setting: %s, value(units): %s%s - unusual\n", name, s.value, s.units),
-					Severity: utils.High,
-					Solution: "Review setting - this is typically 0.9\n"})
+				d.addIssue(name, utils.High, fmt.Sprintf("Setting: %s, value(units): %s%s - unusual\n", name, s.value, s.units),
+					"Review setting - this is typically 0.9\n")
 			}
 		case "default_statistics_target":
 			// Target = 100
 			currentValue, _ := strconv.ParseInt(s.value, 10, 64)
 			if currentValue != 100 {
-				d.issues = append(d.issues, utils.Issue{IssueType: "Config", Target: name,
-					Detail:   fmt.Sprintf("Setting: %s, value: %s\n", name, s.value),
-					Severity: utils.High,
-					Solution: "Review setting - this is typically 100\n"})
+				d.addIssue(name, utils.High, fmt.Sprintf("Setting: %s, value: %s\n", name, s.value),
+					"Review setting - this is typically 100\n")
 			}
 		case "effective_cache_size":
+			if d.profile.Memory == 0 {
+				continue
+			}
 			// Target = Total RAM * 0.5
-			target := float32(memoryTotal) * 0.5
+			target := float32(d.profile.Memory) * 0.5
 			currentValue, _ := strconv.ParseInt(s.value, 10, 64)
 			effectiveCacheSize := utils.PgUnitsToBytes(currentValue, s.units)
 			if issue := testRange(effectiveCacheSize, int64(0.8*target), int64(1.2*target)); issue != "" {
-				d.issues = append(d.issues, utils.Issue{IssueType: "Config", Target: name,
-					Detail: fmt.Sprintf("Setting: %s, value(units): %s %s (%.2fGB) - %s\nGoal: Total RAM * 0.5\n",
-						name, s.value, s.units, float32(effectiveCacheSize)/float32(utils.SIZE_GB), issue),
-					Severity: utils.High,
-					Solution: fmt.Sprintf("Update postgresql.conf - '%s = %s'\n", name, utils.PrettyPrint(utils.CleartoGB(int64(target))))})
+				d.addIssue(name, utils.High, fmt.Sprintf("Setting: %s, value(units): %s %s (%.2fGB) - %s\nGoal: Total RAM * 0.5\n",
+					name, s.value, s.units, float32(effectiveCacheSize)/float32(utils.SIZE_GB), issue),
+					d.update(name, utils.PrettyPrint(utils.CleartoGB(int64(target)))))
 			}
 		case "maintenance_work_mem":
+			if d.profile.Memory == 0 {
+				continue
+			}
 			// Target = Total RAM * 0.05
-			target := float32(memoryTotal) * 0.05
+			target := float32(d.profile.Memory) * 0.05
 			currentValue, _ := strconv.ParseInt(s.value, 10, 64)
 			maintenanceWorkMem := utils.PgUnitsToBytes(currentValue, s.units)
 			if issue := testRange(maintenanceWorkMem, int64(0.5*target), int64(1.5*target)); issue != "" {
-				d.issues = append(d.issues, utils.Issue{IssueType: "Config", Target: name,
-					Detail: fmt.Sprintf("Setting: %s, value(units): %s %s (%.2fMB) - %s\nGoal: Total RAM * 0.05\n",
-						name, s.value, s.units, float32(maintenanceWorkMem)/float32(utils.SIZE_MB), issue),
-					Severity: utils.High,
-					Solution: fmt.Sprintf("Update postgresql.conf - '%s = %s'\n", name, utils.PrettyPrint(utils.CleartoMB(int64(target))))})
+				d.addIssue(name, utils.High, fmt.Sprintf("Setting: %s, value(units): %s %s (%.2fMB) - %s\nGoal: Total RAM * 0.05\n",
+					name, s.value, s.units, float32(maintenanceWorkMem)/float32(utils.SIZE_MB), issue),
+					d.update(name, utils.PrettyPrint(utils.CleartoMB(int64(target)))))
 			}
 		case "shared_buffers":
+			if d.profile.Memory == 0 {
+				continue
+			}
 			// Target = 15% to 25% of the machine’s total RAM
-			shared_buffers, _ := strconv.ParseInt(s.value, 10, 64)
-			if issue := testRange(shared_buffers, int64(0.15*float32(memoryBuffers)), int64(0.25*float32(memoryBuffers))); issue != "" {
-				d.issues = append(d.issues, utils.Issue{IssueType: "Config", Target: name,
-					Detail: fmt.Sprintf("Setting: %s, value(units): %s %s (%.2fGB) - %s\nGoal: 15%% to 25%% of the machine’s total RAM\n",
-						name, s.value, s.units, float32(utils.PgUnitsToBytes(shared_buffers, s.units))/float32(utils.SIZE_GB), issue),
-					Severity: utils.High,
-					Solution: fmt.Sprintf("Update postgresql.conf - '%s = %s'\n", name, utils.PrettyPrint(int64(memoryTotal/4)))})
+			currentValue, _ := strconv.ParseInt(s.value, 10, 64)
+			sharedBuffers := utils.PgUnitsToBytes(currentValue, s.units)
+			if issue := testRange(sharedBuffers, int64(0.15*float32(d.profile.Memory)), int64(0.25*float32(d.profile.Memory))); issue != "" {
+				d.addIssue(name, utils.High, fmt.Sprintf("Setting: %s, value(units): %s %s (%.2fGB) - %s\nGoal: 15%% to 25%% of the machine’s total RAM\n",
+					name, s.value, s.units, float32(sharedBuffers)/float32(utils.SIZE_GB), issue),
+					d.update(name, utils.PrettyPrint(utils.CleartoMB(d.profile.Memory/4))))
 			}
 		case "work_mem":
-			//	Target = Total RAM * 0.25 / max_connections
-			target := (float32(memoryTotal) * 0.25) / float32(maxConnectionsSetting)
+			if d.profile.Memory == 0 {
+				continue
+			}
+			//	Target = Total RAM * 0.25 / max_connections (0.5 for OLAP - fewer, larger, sorts and hashes)
+			fraction := float32(0.25)
+			if d.profile.Workload == dbutils.WorkloadOLAP {
+				fraction = 0.5
+			}
+			target := (float32(d.profile.Memory) * fraction) / float32(maxConnectionsSetting)
 			currentValue, _ := strconv.ParseInt(s.value, 10, 64)
 			workMem := utils.PgUnitsToBytes(currentValue, s.units)
 			if issue := testRange(workMem, int64(0.8*target), int64(1.2*target)); issue != "" {
-				d.issues = append(d.issues, utils.Issue{IssueType: "Config", Target: name,
-					Detail: fmt.Sprintf("Setting: %s, value(units): %s %s (%.2fMB) - %s\nGoal: Total RAM * 0.25 / max_connections(%d)\n",
-						name, s.value, s.units, float32(workMem)/float32(utils.SIZE_MB), issue, maxConnectionsSetting),
-					Severity: utils.High,
-					Solution: fmt.Sprintf("Update postgresql.conf -  '%s = %s'\n",
-						name, utils.PrettyPrint(utils.CleartoMB(int64(target))))})
+				d.addIssue(name, utils.High, fmt.Sprintf("Setting: %s, value(units): %s %s (%.2fMB) - %s\nGoal: Total RAM * %.2f / max_connections(%d)\n",
+					name, s.value, s.units, float32(workMem)/float32(utils.SIZE_MB), issue, fraction, maxConnectionsSetting),
+					d.update(name, utils.PrettyPrint(utils.CleartoMB(int64(target)))))
 			}
 		case "max_connections":
 
 		default:
-			fmt.Printf("ERROR: Internal error - unexpected parameter name '%s' with value: %s, units: %s\n", name, s.value, s.units)
+			if d.analyzeRule(name, s) {
+				continue
+			}
+			log.Printf("ERROR: Internal error - unexpected parameter name '%s' with value: %s, units: %s\n", name, s.value, s.units)
 		}
 	}
 }
 
+// addIssue records an issue with the setting, stating the host profile the recommendation is based on.
+func (d *ConfigIssues) addIssue(name string, severity utils.IssueSeverity, detail string, solution string) {
+	d.issues = append(d.issues, utils.Issue{IssueType: "Config", Target: name, Severity: severity,
+		Detail: detail + fmt.Sprintf("Host profile: %s\n", d.profile), Solution: solution})
+}
+
+// update returns the solution to change the setting to the value, noting whether a restart or reload is required.
+func (d *ConfigIssues) update(name string, value string) string {
+	apply := "requires a reload - SELECT pg_reload_conf()"
+	switch d.settings[name].context {
+	case "postmaster":
+		apply = "requires a restart"
+	case "internal":
+		apply = "cannot be changed - set when PostgreSQL was built or initialized"
+	}
+
+	return fmt.Sprintf("Update postgresql.conf - '%s = %s' (%s)\n", name, value, apply)
+}
+
 func testRange(v int64, lowBound int64, highBound int64) string {
 	if v < lowBound {
 		return "low"
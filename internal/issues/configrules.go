@@ -0,0 +1,176 @@
+package issues
+
+import (
+	"fmt"
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/utils"
+	"strconv"
+)
+
+// analyzeRule checks a setting (other than the memory settings), returns false if there is no rule for the setting.
+func (d *ConfigIssues) analyzeRule(name string, s setting) bool {
+	cpus := int64(d.profile.CPUs)
+	ssd := d.profile.Storage != dbutils.StorageHDD
+
+	switch name {
+	case "autovacuum":
+		if s.value != "on" {
+			d.recommend(name, utils.High, "disabled", "on",
+				"Without autovacuum dead tuples are not removed (tables and indexes bloat), statistics become stale and transaction ID wraparound is not prevented.")
+		}
+	case "autovacuum_analyze_scale_factor":
+		if s.float() > 0.1 {
+			d.recommend(name, utils.Medium, "high", "0.05",
+				"Statistics on large tables are refreshed only after a large fraction of the table has changed, so plans are based on stale statistics.")
+		}
+	case "autovacuum_max_workers":
+		target := max(3, min(cpus/4, 8))
+		if s.int() < target {
+			d.recommend(name, utils.Medium, "low", strconv.FormatInt(target, 10),
+				"Too few workers means large tables are vacuumed one at a time while others accumulate dead tuples.")
+		}
+	case "autovacuum_naptime":
+		if s.milliseconds() > 60*1000 {
+			d.recommend(name, utils.Medium, "high", "1min",
+				"Each database is checked for tables requiring vacuum or analyze only once per naptime.")
+		}
+	case "autovacuum_vacuum_cost_delay":
+		if s.milliseconds() > 2 {
+			d.recommend(name, utils.Medium, "high", "2ms",
+				"Autovacuum sleeps for this long each time the cost limit is reached, a long delay prevents it from keeping up on busy tables (2ms is the default since PostgreSQL 12).")
+		}
+	case "autovacuum_vacuum_cost_limit":
+		if s.int() != -1 && s.int() < 200 {
+			d.recommend(name, utils.Medium, "low", "-1",
+				"Autovacuum is throttled below the default (vacuum_cost_limit), so it may not keep up with the rate dead tuples are created.")
+		}
+	case "autovacuum_vacuum_scale_factor":
+		if s.float() > 0.2 {
+			d.recommend(name, utils.Medium, "high", "0.1",
+				"Large tables accumulate many dead tuples (bloat) before they are vacuumed.")
+		}
+	case "effective_io_concurrency":
+		if ssd && s.int() < 100 {
+			d.recommend(name, utils.Medium, "low", "200",
+				"SSDs service many concurrent requests, a higher value allows bitmap heap scans to prefetch more pages.")
+		} else if !ssd && s.int() > 4 {
+			d.recommend(name, utils.Medium, "high", "2",
+				"A rotating disk services few concurrent requests, prefetching many pages only adds seeks.")
+		}
+	case "huge_pages":
+		if s.value == "off" && d.settings["shared_buffers"].bytes() >= 8*utils.SIZE_GB {
+			d.recommend(name, utils.Medium, "disabled", "try",
+				"With a large shared_buffers huge pages reduce the memory used by page tables and TLB misses (the OS must reserve them, e.g. vm.nr_hugepages).")
+		}
+	case "idle_in_transaction_session_timeout":
+		if s.int() == 0 {
+			d.recommend(name, utils.Medium, "disabled", "10min",
+				"Sessions left idle in a transaction hold locks and prevent vacuum from removing dead tuples.")
+		}
+	case "log_min_duration_statement":
+		target := "1s"
+		if d.profile.Workload == dbutils.WorkloadOLAP {
+			target = "10s"
+		}
+		if s.int() == -1 {
+			d.recommend(name, utils.Medium, "disabled", target, "Slow statements are not logged.")
+		} else if s.int() == 0 {
+			d.recommend(name, utils.Medium, "every statement logged", target,
+				"Logging every statement is expensive on a busy server and makes the slow statements hard to find.")
+		}
+	case "max_parallel_maintenance_workers":
+		target := min((cpus+1)/2, 4)
+		if cpus != 0 && s.int() > target {
+			d.recommend(name, utils.Medium, "high", strconv.FormatInt(target, 10),
+				fmt.Sprintf("Parallel index builds and vacuums with more workers than the CPUs (%d) can support compete with the workload.", cpus))
+		}
+	case "max_parallel_workers":
+		if cpus == 0 {
+			break
+		}
+		if s.int() > cpus*2 {
+			d.recommend(name, utils.Medium, "high", strconv.FormatInt(cpus, 10),
+				fmt.Sprintf("More parallel workers than the CPUs (%d) can support oversubscribes the server.", cpus))
+		} else if s.int() < cpus/2 {
+			d.recommend(name, utils.Medium, "low", strconv.FormatInt(cpus, 10),
+				fmt.Sprintf("Parallel queries cannot use the CPUs (%d) available.", cpus))
+		}
+	case "max_parallel_workers_per_gather":
+		if cpus == 0 {
+			break
+		}
+		// OLAP queries benefit from all the CPUs, otherwise each query is limited so concurrent queries are not starved
+		target := (cpus + 1) / 2
+		if d.profile.Workload != dbutils.WorkloadOLAP {
+			target = min(target, 4)
+		}
+		if s.int() > target {
+			d.recommend(name, utils.Medium, "high", strconv.FormatInt(target, 10),
+				fmt.Sprintf("A single query may use more workers than the CPUs (%d) can support alongside the rest of the workload.", cpus))
+		} else if s.int() == 0 && d.profile.Workload != dbutils.WorkloadOLTP && cpus >= 4 {
+			d.recommend(name, utils.Medium, "disabled", strconv.FormatInt(target, 10),
+				"Parallel query is disabled, large scans, joins and aggregates use a single CPU.")
+		}
+	case "max_wal_size", "min_wal_size":
+		// Larger for write (OLTP) and bulk load (OLAP) workloads, so checkpoints are triggered by checkpoint_timeout
+		minimum, maximum := int64(1), int64(4)
+		switch d.profile.Workload {
+		case dbutils.WorkloadOLTP:
+			minimum, maximum = 2, 8
+		case dbutils.WorkloadOLAP:
+			minimum, maximum = 4, 16
+		}
+		target := maximum
+		if name == "min_wal_size" {
+			target = minimum
+		}
+		if s.bytes() < target*utils.SIZE_GB {
+			d.recommend(name, utils.Medium, "low", fmt.Sprintf("%dGB", target),
+				"Checkpoints are triggered by the volume of WAL rather than checkpoint_timeout, frequent checkpoints increase I/O and the WAL written (full page writes).")
+		}
+	case "max_worker_processes":
+		if cpus != 0 && s.int() < cpus {
+			d.recommend(name, utils.Medium, "low", strconv.FormatInt(cpus, 10),
+				fmt.Sprintf("Parallel workers (and other background workers) are limited by max_worker_processes, so cannot use the CPUs (%d) available.", cpus))
+		}
+	case "pg_stat_statements.max":
+		if s.int() < 5000 {
+			d.recommend(name, utils.Low, "low", "10000",
+				"Statements are evicted (losing their statistics) when more distinct statements are executed, so QueryIssues may miss significant queries.")
+		}
+	case "random_page_cost":
+		if ssd && s.float() > 2 {
+			d.recommend(name, utils.Medium, "high", "1.1",
+				"Random reads on SSD are little slower than sequential reads, a high cost makes the planner favor sequential scans over index scans.")
+		} else if !ssd && s.float() < 2 {
+			d.recommend(name, utils.Medium, "low", "4",
+				"Random reads on a rotating disk are much slower than sequential reads, a low cost makes the planner favor index scans that seek.")
+		}
+	case "track_io_timing":
+		if s.value != "on" {
+			d.recommend(name, utils.Low, "disabled", "on",
+				"I/O timings are not collected, so pg_stat_statements and EXPLAIN (ANALYZE, BUFFERS) cannot report the time spent reading and writing (the overhead is low on most platforms, check with pg_test_timing).")
+		}
+	case "wal_buffers":
+		if s.bytes() < 16*utils.SIZE_MB && d.settings["shared_buffers"].bytes() >= 512*utils.SIZE_MB {
+			d.recommend(name, utils.Medium, "low", "16MB",
+				"WAL is written to disk each time the buffers fill, with many concurrent writes small buffers cause extra writes (-1 sizes it as 1/32 of shared_buffers up to 16MB).")
+		}
+	default:
+		return false
+	}
+
+	return true
+}
+
+// recommend records an issue with the setting, explaining the problem and the recommended value.
+func (d *ConfigIssues) recommend(name string, severity utils.IssueSeverity, problem string, value string, explanation string) {
+	s := d.settings[name]
+	current := s.value
+	if s.units != "" {
+		current += " " + s.units
+	}
+
+	d.addIssue(name, severity, fmt.Sprintf("Setting: %s, value(units): %s - %s\nExplanation: %s\nRecommended: %s\n", name, current, problem, explanation, value),
+		d.update(name, value))
+}
@@ -0,0 +1,59 @@
+package issues
+
+import (
+	"strings"
+	"testing"
+
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/utils"
+)
+
+func TestConfigRules(t *testing.T) {
+	d := ConfigIssues{
+		settings: map[string]setting{
+			"autovacuum":                          {"off", "", "sighup"},
+			"idle_in_transaction_session_timeout": {"0", "ms", "user"},
+			"max_wal_size":                        {"1024", "MB", "sighup"},
+			"max_worker_processes":                {"8", "", "postmaster"},
+			"random_page_cost":                    {"4", "", "user"},
+			"shared_buffers":                      {"1048576", "8kB", "postmaster"},
+			"track_io_timing":                     {"on", "", "superuser"},
+			"wal_buffers":                         {"256", "8kB", "postmaster"},
+		},
+		profile: dbutils.HostProfile{Memory: 64 * utils.SIZE_GB, CPUs: 16, Storage: dbutils.StorageSSD, Workload: dbutils.WorkloadOLTP},
+	}
+
+	for name, s := range d.settings {
+		if !d.analyzeRule(name, s) && name != "shared_buffers" {
+			t.Errorf("no rule for %s", name)
+		}
+	}
+	if d.analyzeRule("unknown_setting", setting{}) {
+		t.Errorf("unexpected rule for unknown_setting")
+	}
+
+	solutions := make(map[string]string)
+	for _, issue := range d.issues {
+		solutions[issue.Target] = issue.Solution
+		if !strings.Contains(issue.Detail, "Explanation: ") || !strings.Contains(issue.Detail, "Host profile: ") {
+			t.Errorf("%s: detail should include the explanation and host profile: %s", issue.Target, issue.Detail)
+		}
+	}
+
+	expected := map[string]string{
+		"autovacuum":                          "Update postgresql.conf - 'autovacuum = on' (requires a reload - SELECT pg_reload_conf())\n",
+		"idle_in_transaction_session_timeout": "Update postgresql.conf - 'idle_in_transaction_session_timeout = 10min' (requires a reload - SELECT pg_reload_conf())\n",
+		"max_wal_size":                        "Update postgresql.conf - 'max_wal_size = 8GB' (requires a reload - SELECT pg_reload_conf())\n",
+		"max_worker_processes":                "Update postgresql.conf - 'max_worker_processes = 16' (requires a restart)\n",
+		"random_page_cost":                    "Update postgresql.conf - 'random_page_cost = 1.1' (requires a reload - SELECT pg_reload_conf())\n",
+		"wal_buffers":                         "Update postgresql.conf - 'wal_buffers = 16MB' (requires a restart)\n",
+	}
+	if len(solutions) != len(expected) {
+		t.Errorf("expected %d issues, found %d: %v", len(expected), len(solutions), solutions)
+	}
+	for name, solution := range expected {
+		if solutions[name] != solution {
+			t.Errorf("%s: expected %q, got %q", name, solution, solutions[name])
+		}
+	}
+}
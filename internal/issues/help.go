@@ -10,17 +10,20 @@ import (
 )
 
 type Help struct {
+	context utils.Context
 }
 
 func (d *Help) Init(context utils.Context, ds *dbutils.DataSource) {
+	d.context = context
 }
 
 func (d *Help) Execute(args ...string) {
 	keys := maps.Keys(detectorRegistry)
 	sort.Strings(keys)
 	for _, key := range keys {
-		fmt.Printf("%s - %s\n", key, detectorRegistry[key].HelpText)
+		fmt.Fprintf(d.context.Writer(), "%s - %s\n", key, detectorRegistry[key].HelpText)
 	}
+	writeThresholds(d.context.Writer())
 }
 
 func (d *Help) GetIssues() []utils.Issue {
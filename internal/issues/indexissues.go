@@ -9,6 +9,8 @@ import (
 
 	"pgmaven/internal/dbutils"
 	"pgmaven/internal/utils"
+
+	"github.com/lib/pq"
 )
 
 type index struct {
@@ -23,20 +25,20 @@ type index struct {
 	dropped    bool
 }
 
-var tableSizes map[string]int64
-
 type IndexIssues struct {
 	datasource    *dbutils.DataSource
+	context       utils.Context
 	issues        []utils.Issue
 	timing        utils.Timing
 	specificIssue string
 	indexes       map[string]*index
+	tableSizes    map[string]int64
+	usage         *indexUsage
 }
 
-const smallTable int64 = 100
-
 func (d *IndexIssues) Init(context utils.Context, ds *dbutils.DataSource) {
 	d.datasource = ds
+	d.context = context
 }
 
 // Search for index-related issues.  Optional arg if provided will constrain to only looking for specific issue.
@@ -88,11 +90,7 @@ func (d *IndexIssues) Execute(args ...string) {
 
 	indexIssueQuery := `
 	WITH table_scans as (
-		SELECT relid,
-			tables.idx_scan + tables.seq_scan as all_scans,
-			( tables.n_tup_ins + tables.n_tup_upd + tables.n_tup_del ) as writes,
-					pg_relation_size(relid) as table_size
-			FROM pg_stat_user_tables as tables
+		%s
 	),
 	all_writes as (
 		SELECT sum(writes) as total_writes
@@ -102,7 +100,7 @@ func (d *IndexIssues) Execute(args ...string) {
 		SELECT idx_stat.relid, idx_stat.indexrelid,
 			idx_stat.schemaname, idx_stat.relname as tablename,
 			idx_stat.indexrelname as indexname,
-			idx_stat.idx_scan,
+			%s as idx_scan,
 			pg_relation_size(idx_stat.indexrelid) as index_bytes,
 			indexdef ~* 'USING btree' AS idx_is_btree,
 			indexdef
@@ -113,9 +111,11 @@ func (d *IndexIssues) Execute(args ...string) {
 				ON idx_stat.schemaname = indexes.schemaname
 					AND idx_stat.relname = indexes.tablename
 					AND idx_stat.indexrelname = indexes.indexname
+			%s
 		WHERE pg_index.indisunique = false
+			AND idx_stat.schemaname = ANY($1)
 			AND 0 <>ALL (indkey)                 -- no index column is an expression
-			AND idx_stat.indexrelname NOT LIKE 'pgmaven_%'
+			AND idx_stat.indexrelname NOT LIKE 'pgmaven_%%'
 			AND NOT EXISTS                         -- does not enforce a constraint
 			(SELECT 1 FROM pg_catalog.pg_constraint c
 				WHERE c.conindid = idx_stat.indexrelid)
@@ -124,7 +124,7 @@ func (d *IndexIssues) Execute(args ...string) {
 				WHERE inh.inhrelid = idx_stat.indexrelid)
 	),
 	index_ratios AS (
-	SELECT schemaname, tablename, indexname,
+	SELECT indexrelid, schemaname, tablename, indexname,
 		idx_scan, all_scans,
 		round(( CASE WHEN all_scans = 0 THEN 0.0::NUMERIC
 			ELSE idx_scan::NUMERIC/all_scans * 100 END),2) as index_scan_pct,
@@ -171,12 +171,44 @@ func (d *IndexIssues) Execute(args ...string) {
 		AND index_bytes > 100000000
 	ORDER BY grp, index_bytes DESC )
 	SELECT reason, schemaname, tablename, indexname,
-		index_scan_pct, scans_per_write, index_size, table_size, indexdef
+		index_scan_pct, scans_per_write, index_size, table_size, indexdef, indexrelid::bigint, index_bytes
 	FROM index_groups
 	`
-	err := d.datasource.ExecuteQueryRows(indexIssueQuery, nil, indexProcessor, d)
+
+	usage, err := d.getUsage()
+	if err != nil {
+		log.Printf("ERROR: Database: %s, IndexIssues failed to determine index usage, error: %v\n", d.datasource.GetName(), err)
+		d.timing.SetDurationMS(time.Now().UnixMilli() - startMS)
+		return
+	}
+	if !d.reportUsage(usage) {
+		d.timing.SetDurationMS(time.Now().UnixMilli() - startMS)
+		return
+	}
+
+	queryArgs := []any{pq.Array(d.datasource.GetSchemas())}
+	if usage.fromSnapshots {
+		// Use the activity over the window (computed from the snapshots)
+		indexIssueQuery = fmt.Sprintf(indexIssueQuery, `SELECT relid, window_tables.all_scans, window_tables.writes, pg_relation_size(relid) as table_size
+			FROM pg_stat_user_tables as tables
+			JOIN unnest($2::bigint[], $3::bigint[], $4::bigint[]) AS window_tables(window_relid, all_scans, writes)
+				ON tables.relid::bigint = window_tables.window_relid`,
+			"window_indexes.idx_scan",
+			`JOIN unnest($5::bigint[], $6::bigint[]) AS window_indexes(window_indexrelid, idx_scan)
+				ON idx_stat.indexrelid::bigint = window_indexes.window_indexrelid`)
+		queryArgs = append(queryArgs, pq.Array(usage.tableIDs), pq.Array(usage.allScans), pq.Array(usage.writes), pq.Array(usage.indexIDs), pq.Array(usage.indexScans))
+	} else {
+		indexIssueQuery = fmt.Sprintf(indexIssueQuery, `SELECT relid,
+			tables.idx_scan + tables.seq_scan as all_scans,
+			( tables.n_tup_ins + tables.n_tup_upd + tables.n_tup_del ) as writes,
+					pg_relation_size(relid) as table_size
+			FROM pg_stat_user_tables as tables`,
+			"idx_stat.idx_scan", "")
+	}
+
+	err = d.datasource.ExecuteQueryRows(indexIssueQuery, queryArgs, indexProcessor, d)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, indexIssueQuery failed with error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, indexIssueQuery failed with error: %v\n", d.datasource.GetName(), err)
 	}
 
 	d.timing.SetDurationMS(time.Now().UnixMilli() - startMS)
@@ -203,17 +235,20 @@ func (d *IndexIssues) isIssueEnabled(issue string) bool {
 func indexProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
 	d := self.(*IndexIssues)
 	indexIssue := (*values[0].(*interface{})).(string)
-	tableName := string((*values[2].(*interface{})).([]uint8))
-	indexName := string((*values[3].(*interface{})).([]uint8))
+	schemaName := string((*values[1].(*interface{})).([]uint8))
+	tableName := utils.QualifiedName(schemaName, string((*values[2].(*interface{})).([]uint8)))
+	indexName := utils.QualifiedName(schemaName, string((*values[3].(*interface{})).([]uint8)))
 	indexScanPct := string((*values[4].(*interface{})).([]uint8))
 	scansPerWrite := string((*values[5].(*interface{})).([]uint8))
 	indexSize := (*values[6].(*interface{})).(string)
 	tableSize := (*values[7].(*interface{})).(string)
 	indexDefinition := (*values[8].(*interface{})).(string)
+	indexID := (*values[9].(*interface{})).(int64)
+	indexBytes := (*values[10].(*interface{})).(int64)
 
 	d.sizeSmallTables()
 
-	size, ok := tableSizes[tableName]
+	size, ok := d.tableSizes[tableName]
 	if ok && size == 0 {
 		// Ignoring indexes for table with no rows
 		return
@@ -223,44 +258,70 @@ func indexProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []inter
 		tableDetail := fmt.Sprintf("Table: %s, Index Size: %s, Table Size: %s, %s index, Scan %%: %s, Scans/write: %s (%s)\n",
 			tableName, indexSize, tableSize, indexIssue, indexScanPct, scansPerWrite, indexName)
 		indexDetail := fmt.Sprintf("Index definition: '%s'\n", indexDefinition)
+
+		// Usage is only meaningful if observed over a sufficient period
+		var severity utils.IssueSeverity
+		coverage := d.usage.getCoverage(indexID)
+		if coverage == 0 {
+			return
+		}
+		usageDetail := fmt.Sprintf("Usage observed: %s\n", d.usage.period)
+		if coverage != d.usage.coverage {
+			usageDetail = fmt.Sprintf("Usage observed: %s (index created during the period)\n", formatCoverage(coverage))
+		}
+		if severity = usageSeverity(coverage, d.context.MinHistory); severity == utils.Low {
+			usageDetail += fmt.Sprintf("Usage observed for less than the minimum history (%s) - severity reduced\n", formatCoverage(d.context.MinHistory))
+		}
+
 		var solution string
+		var wastedBytes int64
 		if indexIssue != "IndexHighWriteLargeNonBtree" {
-			solution = fmt.Sprintf("DROP INDEX \"%s\"\n", indexName)
+			solution = fmt.Sprintf("DROP INDEX %s\n", indexName)
+			wastedBytes = indexBytes
 		} else {
 			solution = "NONE proposed\n"
 		}
 
-		d.issues = append(d.issues, utils.Issue{IssueType: indexIssue, Target: indexName, Severity: utils.High,
-			Detail: tableDetail + indexDetail, Solution: solution})
+		d.issues = append(d.issues, utils.Issue{IssueType: indexIssue, Target: indexName, Severity: severity,
+			Detail: tableDetail + indexDetail + usageDetail, Solution: solution, WastedBytes: wastedBytes})
 	}
 }
 
 func (d *IndexIssues) doDuplicate() {
 	duplicateIndexQuery := `
-	SELECT table_name, pg_size_pretty(sum(pg_relation_size(idx))::bigint) as size,
-		(array_agg(idx))[1] as idx1, (array_agg(idx))[2] as idx2,
-		(array_agg(idx))[3] as idx3, (array_agg(idx))[4] as idx4
+	SELECT schema_name, table_name, pg_size_pretty(sum(pg_relation_size(idx))::bigint) as size,
+		(array_agg(index_name))[1] as idx1, (array_agg(index_name))[2] as idx2,
+		(array_agg(index_name))[3] as idx3, (array_agg(index_name))[4] as idx4,
+		(array_agg(pg_relation_size(idx)))[1] as idx1_bytes, (array_agg(pg_relation_size(idx)))[2] as idx2_bytes
 	FROM (
-	SELECT indexrelid::regclass as idx, indrelid::regclass as table_name, (indrelid::text ||E'\n'|| indclass::text ||E'\n'|| indkey::text ||E'\n'||
+	SELECT indexrelid as idx, nspname as schema_name, tables.relname as table_name, indexes.relname as index_name,
+		(indrelid::text ||E'\n'|| indclass::text ||E'\n'|| indkey::text ||E'\n'||
 										coalesce(indexprs::text,'')||E'\n' || coalesce(indpred::text,'')) as key
-	FROM pg_index) sub
-	GROUP BY table_name, key HAVING count(*)>1
+	FROM pg_index
+		JOIN pg_class as indexes ON indexes.oid = indexrelid
+		JOIN pg_class as tables ON tables.oid = indrelid
+		JOIN pg_namespace ON pg_namespace.oid = tables.relnamespace
+	WHERE nspname = ANY($1)) sub
+	GROUP BY schema_name, table_name, key HAVING count(*)>1
 	ORDER BY sum(pg_relation_size(idx)) DESC;
 	`
-	err := d.datasource.ExecuteQueryRows(duplicateIndexQuery, nil, duplicateIndexProcessor, d)
+	err := d.datasource.ExecuteQueryRows(duplicateIndexQuery, []any{pq.Array(d.datasource.GetSchemas())}, duplicateIndexProcessor, d)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, DuplicateIndexQuery failed with error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, DuplicateIndexQuery failed with error: %v\n", d.datasource.GetName(), err)
 	}
 }
 
 // duplicateIndexProcess is invoked for every row of the Duplicate Index Query.
-// The Query returns a row with the following format (tableName, index size, index1, index2) - where index1 and index2 are duplicated.
+// The Query returns a row with the following format (schemaName, tableName, index size, index1, index2) - where index1 and index2 are duplicated.
 func duplicateIndexProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
 	d := self.(*IndexIssues)
-	tableName := string((*values[0].(*interface{})).([]uint8))
-	indexSize := (*values[1].(*interface{})).(string)
-	index1 := string((*values[2].(*interface{})).([]uint8))
-	index2 := string((*values[3].(*interface{})).([]uint8))
+	schemaName := string((*values[0].(*interface{})).([]uint8))
+	tableName := utils.QualifiedName(schemaName, string((*values[1].(*interface{})).([]uint8)))
+	indexSize := (*values[2].(*interface{})).(string)
+	index1 := utils.QualifiedName(schemaName, string((*values[3].(*interface{})).([]uint8)))
+	index2 := utils.QualifiedName(schemaName, string((*values[4].(*interface{})).([]uint8)))
+	index1Bytes := (*values[7].(*interface{})).(int64)
+	index2Bytes := (*values[8].(*interface{})).(int64)
 
 	tableDetail := fmt.Sprintf("Table: %s, Index Size: %s, Duplicate indexes (%s, %s)\n", tableName, indexSize, index1, index2)
 	index1Definition := d.datasource.IndexDefinition(index1)
@@ -269,11 +330,13 @@ func duplicateIndexProcessor(rowNumber int, columnTypes []*sql.ColumnType, value
 
 	// If Index 2 is unique then kill Index 1
 	if strings.Contains(index2Definition, " UNIQUE ") {
-		d.issues = append(d.issues, utils.Issue{IssueType: "IndexDuplicate", Target: index1, Severity: utils.High, Detail: tableDetail + indexDetail, Solution: fmt.Sprintf("DROP INDEX %s\n", index1)})
+		d.issues = append(d.issues, utils.Issue{IssueType: "IndexDuplicate", Target: index1, Severity: utils.High, Detail: tableDetail + indexDetail,
+			Solution: fmt.Sprintf("DROP INDEX %s\n", index1), WastedBytes: index1Bytes})
 		return
 	}
 
-	d.issues = append(d.issues, utils.Issue{IssueType: "IndexDuplicate", Target: index2, Severity: utils.High, Detail: tableDetail + indexDetail, Solution: fmt.Sprintf("DROP INDEX %s\n", index2)})
+	d.issues = append(d.issues, utils.Issue{IssueType: "IndexDuplicate", Target: index2, Severity: utils.High, Detail: tableDetail + indexDetail,
+		Solution: fmt.Sprintf("DROP INDEX %s\n", index2), WastedBytes: index2Bytes})
 }
 
 func (d *IndexIssues) doSmall() {
@@ -283,78 +346,82 @@ func (d *IndexIssues) doSmall() {
 }
 
 func (d *IndexIssues) sizeSmallTables() {
-	if tableSizes != nil {
+	if d.tableSizes != nil {
 		return
 	}
 
-	tableSizes = make(map[string]int64)
+	// Held by the detector (not shared) as each database has its own tables and databases may be processed concurrently
+	d.tableSizes = make(map[string]int64)
 
 	tableQuery := `
 	select
-	sub.table_name
+	sub.table_schema, sub.table_name
 from
 	(
 	select
-		table_name
+		table_schema, table_name
 	from
 		information_schema.tables
 	where
-		table_schema = $1
+		table_schema = ANY($1)
 		and table_type = 'BASE TABLE'
 		and table_name not ilike 'PGMAVEN_%'
 except
 	select
-		table_name
+		table_schema, table_name
 	from
 		information_schema.tables,
 		pg_stat_user_tables psut
 	where
 		table_name = relname
-		and table_schema = $1
+		and table_schema = schemaname
+		and table_schema = ANY($1)
 		and table_type = 'BASE TABLE'
 		and table_name not ilike 'PGMAVEN_%'
 		and psut.last_analyze is not null
-		and n_live_tup > $2
+		and n_live_tup > $2::numeric
 ) as sub
 order by
-	table_name`
+	table_schema, table_name`
 
-	err := d.datasource.ExecuteQueryRows(tableQuery, []any{d.datasource.GetSchema(), smallTable}, smallTableProcessor, d)
+	err := d.datasource.ExecuteQueryRows(tableQuery, []any{pq.Array(d.datasource.GetSchemas()), smallTableRows.Value()}, smallTableProcessor, d)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Table query failed, error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, Table query failed, error: %v\n", d.datasource.GetName(), err)
 	}
 }
 
 func smallTableProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
 	d := self.(*IndexIssues)
-	tableName := string((*values[0].(*interface{})).([]uint8))
+	tableName := utils.QualifiedName(string((*values[0].(*interface{})).([]uint8)), string((*values[1].(*interface{})).([]uint8)))
 
 	query := fmt.Sprintf(`select count(*) from %s`, tableName)
 	rows, err := d.datasource.ExecuteQueryRow(query, nil)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Query '%s' failed, error: %v\n", d.datasource.GetDBName(), query, err)
+		// The table may have been dropped since it was listed, the error has been counted by ExecuteQueryRow
+		log.Printf("ERROR: Database: %s, Query '%s' failed, error: %v\n", d.datasource.GetName(), query, err)
+		return
 	}
 
-	tableSizes[tableName] = rows.(int64)
+	if count, ok := rows.(int64); ok {
+		d.tableSizes[tableName] = count
+	}
 }
 
 func (d *IndexIssues) doSmallCheck() {
-	var inClause strings.Builder
-
-	for tableName, value := range tableSizes {
-		if value < smallTable {
-			if inClause.Len() != 0 {
-				inClause.WriteString(", ")
-			}
-			inClause.WriteRune('\'')
-			inClause.WriteString(tableName)
-			inClause.WriteRune('\'')
+	// Filtered by name (rather than regclass) so a table dropped since it was sized does not fail the query
+	smallSchemas, smallTables := make([]string, 0), make([]string, 0)
+	smallTable := smallTableRows.Value()
+
+	for tableName, value := range d.tableSizes {
+		if float64(value) < smallTable {
+			schema, name := utils.SplitQualifiedName(tableName)
+			smallSchemas, smallTables = append(smallSchemas, schema), append(smallTables, name)
 		} else {
-			d.issues = append(d.issues, utils.Issue{IssueType: "TableAnalyze", Target: tableName, Detail: "n_live_tup < row count\n", Solution: fmt.Sprintf("ANALYZE \"%s\"\n", tableName)})
+			d.issues = append(d.issues, utils.Issue{IssueType: "TableAnalyze", Target: tableName, Detail: "n_live_tup < row count\n", Solution: fmt.Sprintf("ANALYZE %s\n", tableName)})
 		}
 	}
 
-	smallIndexTemplate := `
+	smallIndexQuery := `
 		SELECT
 			stat.schemaname,
 			stat.relname AS tablename,
@@ -364,9 +431,9 @@ func (d *IndexIssues) doSmallCheck() {
 		  FROM pg_catalog.pg_stat_user_indexes stat
 		  JOIN pg_catalog.pg_index i using (indexrelid)
 		  JOIN pg_catalog.pg_indexes i2 ON stat.schemaname = i2.schemaname AND stat.relname = i2.tablename AND stat.indexrelname = i2.indexname
-		  WHERE stat.schemaname = $1 and stat.relname in (%s)
+		  WHERE (stat.schemaname, stat.relname) IN (SELECT * FROM unnest($1::text[], $2::text[]))
 		  AND stat.idx_scan != 0                 -- has been used (unused will be be picked up separately)
-		  AND i2.indexdef like '%%USING btree%%'   -- only want BTREE indexes
+		  AND i2.indexdef like '%USING btree%'   -- only want BTREE indexes
 		  AND 0 <>ALL (i.indkey)                 -- no index column is an expression
 		  AND NOT i.indisunique                  -- is not a UNIQUE index
 		  AND NOT EXISTS                         -- does not enforce a constraint
@@ -375,26 +442,27 @@ func (d *IndexIssues) doSmallCheck() {
 		  AND NOT EXISTS                         -- is not an index partition
 			(SELECT 1 FROM pg_catalog.pg_inherits AS inh
 			 WHERE inh.inhrelid = stat.indexrelid)
-		  ORDER by tablename asc, indexname asc;
+		  ORDER by schemaname asc, tablename asc, indexname asc;
 		`
-	smallIndexQuery := fmt.Sprintf(smallIndexTemplate, inClause.String())
-	err := d.datasource.ExecuteQueryRows(smallIndexQuery, []any{d.datasource.GetSchema()}, smallIndexProcessor, d)
+	err := d.datasource.ExecuteQueryRows(smallIndexQuery, []any{pq.Array(smallSchemas), pq.Array(smallTables)}, smallIndexProcessor, d)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, SmallIndexQuery failed with error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, SmallIndexQuery failed with error: %v\n", d.datasource.GetName(), err)
 	}
 }
 
 func smallIndexProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
 	d := self.(*IndexIssues)
-	tableName := string((*values[1].(*interface{})).([]uint8))
-	indexName := string((*values[2].(*interface{})).([]uint8))
+	schemaName := string((*values[0].(*interface{})).([]uint8))
+	tableName := utils.QualifiedName(schemaName, string((*values[1].(*interface{})).([]uint8)))
+	indexName := utils.QualifiedName(schemaName, string((*values[2].(*interface{})).([]uint8)))
 	indexSize := (*values[3].(*interface{})).(int64)
 	indexDefinition := (*values[4].(*interface{})).(string)
 
-	tableDetail := fmt.Sprintf("Table: %s, Rows: %d, Index Size: %d, Small indexes (%s)\n", tableName, tableSizes[tableName], indexSize, indexName)
+	tableDetail := fmt.Sprintf("Table: %s, Rows: %d, Index Size: %d, Small indexes (%s)\n", tableName, d.tableSizes[tableName], indexSize, indexName)
 	indexDetail := fmt.Sprintf("Index definition: '%s'\n", indexDefinition)
 
-	d.issues = append(d.issues, utils.Issue{IssueType: "IndexSmall", Target: indexName, Severity: utils.High, Detail: tableDetail + indexDetail, Solution: fmt.Sprintf("DROP INDEX \"%s\"\n", indexName)})
+	d.issues = append(d.issues, utils.Issue{IssueType: "IndexSmall", Target: indexName, Severity: utils.High, Detail: tableDetail + indexDetail,
+		Solution: fmt.Sprintf("DROP INDEX %s\n", indexName), WastedBytes: indexSize})
 }
 
 func (d *IndexIssues) doIndexBloat() {
@@ -408,6 +476,7 @@ WITH btree_index_atts AS (
     JOIN pg_namespace ON pg_namespace.oid = pg_class.relnamespace
     JOIN pg_am ON pg_class.relam = pg_am.oid
     WHERE pg_am.amname = 'btree'
+        AND nspname = ANY($1)
     ),
 index_item_sizes AS (
     SELECT
@@ -475,25 +544,27 @@ raw_bloat AS (
 )
 SELECT nspname as schema_name, table_name, index_name,
         round(realbloat, 1) as bloat_pct,
-        wastedbytes as bloat_bytes, pg_size_pretty(wastedbytes::bigint) as bloat_size,
+        wastedbytes::bigint as bloat_bytes, pg_size_pretty(wastedbytes::bigint) as bloat_size,
         totalbytes as index_bytes, pg_size_pretty(totalbytes::bigint) as index_size,
         table_bytes, pg_size_pretty(table_bytes) as table_size,
         index_scans
 FROM raw_bloat
-WHERE ( realbloat > 50 and wastedbytes > 50000000 )
+WHERE ( realbloat > $2::numeric and wastedbytes > $3::numeric )
 ORDER BY wastedbytes DESC;`
 
-	err := d.datasource.ExecuteQueryRows(indexBloatQuery, nil, bloatProcessor, d)
+	err := d.datasource.ExecuteQueryRows(indexBloatQuery, []any{pq.Array(d.datasource.GetSchemas()), indexBloatPercent.Value(), indexBloatBytes.Value()}, bloatProcessor, d)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Bloat query failed with error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, Bloat query failed with error: %v\n", d.datasource.GetName(), err)
 	}
 }
 
 func bloatProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
 	d := self.(*IndexIssues)
-	tableName := string((*values[1].(*interface{})).([]uint8))
-	indexName := string((*values[2].(*interface{})).([]uint8))
+	schemaName := string((*values[0].(*interface{})).([]uint8))
+	tableName := utils.QualifiedName(schemaName, string((*values[1].(*interface{})).([]uint8)))
+	indexName := utils.QualifiedName(schemaName, string((*values[2].(*interface{})).([]uint8)))
 	bloatPercent := string((*values[3].(*interface{})).([]uint8))
+	bloatBytes := (*values[4].(*interface{})).(int64)
 	bloatSize := (*values[5].(*interface{})).(string)
 	indexSize := (*values[7].(*interface{})).(string)
 	tableSize := (*values[9].(*interface{})).(string)
@@ -503,13 +574,13 @@ func bloatProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []inter
 		tableName, tableSize, indexName, indexSize, bloatPercent, bloatSize, indexScans)
 
 	d.issues = append(d.issues, utils.Issue{IssueType: "IndexBloat", Target: indexName, Severity: utils.High, Detail: detail,
-		Solution: fmt.Sprintf("REINDEX INDEX CONCURRENTLY \"%s\"\n", indexName)})
+		Solution: fmt.Sprintf("REINDEX INDEX CONCURRENTLY %s\n", indexName), WastedBytes: bloatBytes})
 }
 
 func (d *IndexIssues) doHighNullPercent() {
 	indexHighNullPercentQuery := `
 SELECT
-    s.schemaname,
+    n.nspname as schemaname,
     c_table.relname as tablename,
     c.relname AS indexname,
     pg_size_pretty(pg_relation_size(c.oid)) AS index_size,
@@ -519,52 +590,57 @@ SELECT
         ELSE to_char(s.null_frac * 100, '999.00%')
     END AS null_frac,
     pg_size_pretty((pg_relation_size(c.oid) * s.null_frac)::bigint) AS expected_saving,
-    ixs.indexdef
+    ixs.indexdef,
+    (pg_relation_size(c.oid) * s.null_frac)::bigint AS expected_saving_bytes
 FROM
     pg_class c
     JOIN pg_index i ON i.indexrelid = c.oid
     JOIN pg_attribute a ON a.attrelid = c.oid
     JOIN pg_class c_table ON c_table.oid = i.indrelid
-    JOIN pg_indexes ixs ON c.relname = ixs.indexname
-    LEFT JOIN pg_stats s ON s.tablename = c_table.relname AND a.attname = s.attname
+    JOIN pg_namespace n ON n.oid = c_table.relnamespace
+    JOIN pg_indexes ixs ON n.nspname = ixs.schemaname AND c.relname = ixs.indexname
+    LEFT JOIN pg_stats s ON s.schemaname = n.nspname AND s.tablename = c_table.relname AND a.attname = s.attname
 WHERE
+    n.nspname = ANY($1)
     -- Primary key cannot be partial
-    NOT i.indisprimary
+    AND NOT i.indisprimary
     -- Exclude already partial indexes
     AND i.indpred IS NULL
     -- Exclude composite indexes
     AND array_length(i.indkey, 1) = 1
-    -- Larger than 10MB
-    AND pg_relation_size(c.oid) > 10 * 1024 ^ 2
+    -- Larger than the minimum size (MB)
+    AND pg_relation_size(c.oid) > $2::numeric * 1024 ^ 2
     -- Must be btree index
     AND indexdef ~* 'USING btree'
     -- Not interested in playing with unique indexes
     AND not i.indisunique
     -- Only if a large % are nulls
-    and null_frac > .95
+    and null_frac * 100 > $3::numeric
 ORDER BY
-    c_table.relname, c.relname`
+    n.nspname, c_table.relname, c.relname`
 
-	err := d.datasource.ExecuteQueryRows(indexHighNullPercentQuery, nil, highNullProcessor, d)
+	err := d.datasource.ExecuteQueryRows(indexHighNullPercentQuery, []any{pq.Array(d.datasource.GetSchemas()), indexNullMinMB.Value(), indexNullPercent.Value()}, highNullProcessor, d)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, High Null Percent query failed with error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, High Null Percent query failed with error: %v\n", d.datasource.GetName(), err)
 	}
 }
 
 func highNullProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
 	d := self.(*IndexIssues)
-	tableName := string((*values[1].(*interface{})).([]uint8))
-	indexName := string((*values[2].(*interface{})).([]uint8))
+	schemaName := string((*values[0].(*interface{})).([]uint8))
+	tableName := utils.QualifiedName(schemaName, string((*values[1].(*interface{})).([]uint8)))
+	indexName := utils.QualifiedName(schemaName, string((*values[2].(*interface{})).([]uint8)))
 	indexSize := (*values[3].(*interface{})).(string)
 	indexedColumn := (*values[4].(*interface{})).([]uint8)
 	nullFrac := (*values[5].(*interface{})).(string)
 	indexDefinition := (*values[7].(*interface{})).(string)
+	expectedSaving := (*values[8].(*interface{})).(int64)
 
 	detail := fmt.Sprintf("Table: %s, Index: %s, Index Size: %s, Indexed Column: %s, Null %%: %s\nIndex Definition: '%s'\n",
 		tableName, indexName, indexSize, indexedColumn, nullFrac, indexDefinition)
 
 	d.issues = append(d.issues, utils.Issue{IssueType: "IndexHighNullPercent", Target: indexName, Severity: utils.High, Detail: detail,
-		Solution: fmt.Sprintf("-- Consider adding 'WHERE %s IS NOT NULL' to the index.\n", indexedColumn)})
+		Solution: fmt.Sprintf("-- Consider adding 'WHERE %s IS NOT NULL' to the index.\n", indexedColumn), WastedBytes: expectedSaving})
 }
 
 func (d *IndexIssues) doIndexMissing() {
@@ -579,23 +655,25 @@ idx_scan,
 seq_tup_read,
 seq_tup_read / seq_scan as avg_seq_tup_read
 FROM pg_stat_all_tables
-WHERE schemaname='public'
-AND pg_table_size(relid)::numeric > 1000000        -- reasonable table size
-AND seq_scan + idx_scan > 100000                   -- reasonable number of scans
-AND (seq_scan * 100) / (seq_scan + idx_scan) > 10  -- seq scan percent is > 10%
-and seq_tup_read > 1000000                         -- decent number of tuples read via the seq scan
+WHERE schemaname = ANY($1)
+AND pg_table_size(relid)::numeric > $2::numeric              -- reasonable table size
+AND seq_scan + idx_scan > $3::numeric                         -- reasonable number of scans
+AND (seq_scan * 100) / (seq_scan + idx_scan) > $4::numeric    -- seq scan percent is significant
+and seq_tup_read > $5::numeric                                -- decent number of tuples read via the seq scan
 and seq_scan != 0
-and seq_tup_read / seq_scan > 1000`
+and seq_tup_read / seq_scan > $6::numeric`
 
-	err := d.datasource.ExecuteQueryRows(indexMissingQuery, nil, missingProcessor, d)
+	err := d.datasource.ExecuteQueryRows(indexMissingQuery, []any{pq.Array(d.datasource.GetSchemas()),
+		indexMissingMinTableBytes.Value(), indexMissingMinScans.Value(), indexMissingSeqPercent.Value(),
+		indexMissingMinSeqTuples.Value(), indexMissingMinAvgSeqTuples.Value()}, missingProcessor, d)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Index missing query failed with error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, Index missing query failed with error: %v\n", d.datasource.GetName(), err)
 	}
 }
 
 func missingProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
 	d := self.(*IndexIssues)
-	tableName := string((*values[1].(*interface{})).([]uint8))
+	tableName := utils.QualifiedName(string((*values[0].(*interface{})).([]uint8)), string((*values[1].(*interface{})).([]uint8)))
 	tableSize := (*values[2].(*interface{})).(string)
 	seqScans := (*values[3].(*interface{})).(int64)
 	indexScans := (*values[4].(*interface{})).(int64)
@@ -607,7 +685,7 @@ func missingProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []int
 		tableName, tableSize, seqScans, indexScans, seqPercent, seqTuplesRead, avgSeqTuplesRead)
 
 	d.issues = append(d.issues, utils.Issue{IssueType: "IndexMissing", Target: tableName, Severity: utils.High, Detail: detail,
-		Solution: fmt.Sprintf("-- Consider adding an index to \"%s\"\n", tableName)})
+		Solution: fmt.Sprintf("-- Consider adding an index to %s\n", tableName)})
 }
 
 func (d *IndexIssues) doOverlapping() {
@@ -653,12 +731,13 @@ func (d *IndexIssues) doOverlapping() {
 		JOIN dup_natts ON userdex.indexrelid = dup_natts.indexrelid
 		JOIN pg_indexes ON userdex.schemaname = pg_indexes.schemaname
 			AND userdex.indexrelname = pg_indexes.indexname
+	WHERE userdex.schemaname = ANY($1)
 	ORDER BY userdex.schemaname, userdex.relname, cols, userdex.indexrelname;
 	`
 
-	err := d.datasource.ExecuteQueryRows(indexOverlappingQuery, nil, overlappingProcessor, d)
+	err := d.datasource.ExecuteQueryRows(indexOverlappingQuery, []any{pq.Array(d.datasource.GetSchemas())}, overlappingProcessor, d)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Overlapping index query failed with error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, Overlapping index query failed with error: %v\n", d.datasource.GetName(), err)
 	}
 }
 
@@ -684,8 +763,9 @@ func (d *IndexIssues) findOverlapper(tableName string, indexCols string) (found
 
 func overlappingProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
 	d := self.(*IndexIssues)
-	tableName := string((*values[1].(*interface{})).([]uint8))
-	indexName := string((*values[2].(*interface{})).([]uint8))
+	schemaName := string((*values[0].(*interface{})).([]uint8))
+	tableName := utils.QualifiedName(schemaName, string((*values[1].(*interface{})).([]uint8)))
+	indexName := utils.QualifiedName(schemaName, string((*values[2].(*interface{})).([]uint8)))
 	indexCols := (*values[3].(*interface{})).(string)
 	isUnique := (*values[4].(*interface{})).(bool)
 	indexSizeBytes := (*values[5].(*interface{})).(int64)
@@ -711,15 +791,15 @@ func overlappingProcessor(rowNumber int, columnTypes []*sql.ColumnType, values [
 			replacementRelativeSize := float32(indexSizeBytes) / float32(superceded.sizeBytes)
 
 			if replacementUtilization < 1.0 {
-				note = " -- NOTE: replacement is extremely lightly used,  consider dropping \"" + indexName + "\" instead"
+				note = " -- NOTE: replacement is extremely lightly used,  consider dropping " + indexName + " instead"
 			} else if replacementRelativeSize > 1.5 && replacementUtilization < 10.0 {
-				note = " -- NOTE: replacement is lightly utilized and significantly larger, consider dropping \"" + indexName + "\" instead"
+				note = " -- NOTE: replacement is lightly utilized and significantly larger, consider dropping " + indexName + " instead"
 			}
 		}
 
-		solution := fmt.Sprintf("DROP INDEX \"%s\"%s\n", superceded.indexName, note)
+		solution := fmt.Sprintf("DROP INDEX %s%s\n", superceded.indexName, note)
 		d.issues = append(d.issues, utils.Issue{IssueType: "IndexOverlapping", Target: superceded.indexName, Severity: utils.High,
-			Detail: supercededDetail + replacedDetail, Solution: solution})
+			Detail: supercededDetail + replacedDetail, Solution: solution, WastedBytes: superceded.sizeBytes})
 
 		// Mark as dropped - so we don't drop it again
 		superceded.dropped = true
@@ -753,24 +833,26 @@ func (d *IndexIssues) doLowCardinalityColumn() {
 			from pg_stat_user_indexes psui, index_cols, pg_stats stats, pg_indexes
 			  where psui.indexrelid = index_cols.indexrelid
 				and stats.schemaname = pg_indexes.schemaname AND stats.tablename = pg_indexes.tablename AND stats.attname = index_column and pg_indexes.indexname = indexrelname
-				and psui.schemaname = $1
+				and pg_indexes.schemaname = psui.schemaname
+				and psui.schemaname = ANY($1)
 				and relname = stats.tablename
 				and index_column = stats.attname
 				and idx_scan > 0
 				and n_distinct = 1
 				and null_frac < .5
-			order by relname, indexrelname`
+			order by psui.schemaname, relname, indexrelname`
 
-	err := d.datasource.ExecuteQueryRows(lowCardinalityColumnQuery, []any{d.datasource.GetSchema()}, lowCardinalityProcessor, d)
+	err := d.datasource.ExecuteQueryRows(lowCardinalityColumnQuery, []any{pq.Array(d.datasource.GetSchemas())}, lowCardinalityProcessor, d)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Low Cardinality Column query failed with error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, Low Cardinality Column query failed with error: %v\n", d.datasource.GetName(), err)
 	}
 }
 
 func lowCardinalityProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
 	d := self.(*IndexIssues)
-	tableName := string((*values[1].(*interface{})).([]uint8))
-	indexName := string((*values[2].(*interface{})).([]uint8))
+	schemaName := string((*values[0].(*interface{})).([]uint8))
+	tableName := utils.QualifiedName(schemaName, string((*values[1].(*interface{})).([]uint8)))
+	indexName := utils.QualifiedName(schemaName, string((*values[2].(*interface{})).([]uint8)))
 	indexScans := (*values[3].(*interface{})).(int64)
 	indexColumn := string((*values[4].(*interface{})).([]uint8))
 	mostCommonValues := string((*values[5].(*interface{})).([]uint8))
@@ -781,7 +863,7 @@ func lowCardinalityProcessor(rowNumber int, columnTypes []*sql.ColumnType, value
 		tableName, indexName, indexSize, indexColumn, mostCommonValues, indexScans, indexDefinition)
 
 	d.issues = append(d.issues, utils.Issue{IssueType: "IndexLowCardinalityColumn", Target: indexName, Severity: utils.Medium, Detail: detail,
-		Solution: fmt.Sprintf("-- Consider dropping '%s' from index '%s'\n", indexColumn, indexName)})
+		Solution: fmt.Sprintf("-- Consider dropping '%s' from index %s\n", indexColumn, indexName)})
 }
 
 func (d *IndexIssues) GetIssues() []utils.Issue {
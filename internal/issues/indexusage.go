@@ -0,0 +1,141 @@
+package issues
+
+import (
+	"fmt"
+	"log"
+	"pgmaven/internal/history"
+	"pgmaven/internal/utils"
+	"time"
+)
+
+// indexUsage is the index and table activity used to detect unused indexes.  If snapshots are available this is the activity
+// over the analysis window (--duration/--durationOffset), otherwise it is the lifetime counters (i.e. since the statistics were
+// last reset).
+type indexUsage struct {
+	fromSnapshots bool
+	period        string
+	coverage      time.Duration
+	warnings      []string
+	reported      bool
+	// Coverage for each index (by indexrelid) - indexes created during the window have a reduced coverage
+	indexCoverage map[int64]time.Duration
+	// Window activity passed to the index query as arrays
+	tableIDs   []int64
+	allScans   []int64
+	writes     []int64
+	indexIDs   []int64
+	indexScans []int64
+}
+
+// getUsage returns the index and table activity (calculated once per detector).
+func (d *IndexIssues) getUsage() (*indexUsage, error) {
+	if d.usage != nil {
+		return d.usage, nil
+	}
+
+	var err error
+	if d.datasource.GetArchive() != "" || d.datasource.HasSnapshots() {
+		d.usage, err = d.getWindowUsage()
+	} else {
+		d.usage, err = d.getLifetimeUsage()
+	}
+
+	return d.usage, err
+}
+
+func (d *IndexIssues) getLifetimeUsage() (*indexUsage, error) {
+	query := `SELECT extract(epoch from NOW() - coalesce(stats_reset, pg_postmaster_start_time()))::bigint FROM pg_stat_database WHERE datname = current_database()`
+	seconds, err := d.datasource.ExecuteQueryRow(query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	coverage := time.Duration(seconds.(int64)) * time.Second
+	return &indexUsage{coverage: coverage, period: fmt.Sprintf("since statistics reset (%s, no snapshots)", formatCoverage(coverage))}, nil
+}
+
+func (d *IndexIssues) getWindowUsage() (*indexUsage, error) {
+	end := time.Now().Add(-d.context.DurationOffset)
+	start := end.Add(-d.context.Duration)
+
+	source := history.Filter(history.NewSource(d.datasource), "pg_stat_user_tables", "pg_stat_user_indexes")
+	window, err := history.NewWindow(source, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &indexUsage{fromSnapshots: true, coverage: window.Coverage(), indexCoverage: make(map[int64]time.Duration),
+		period: fmt.Sprintf("%v - %v (%s)", window.Start.Time.Format(time.DateTime), window.End.Time.Format(time.DateTime), formatCoverage(window.Coverage()))}
+
+	tables, err := history.Compute(source, window, "pg_stat_user_tables", []string{"relid"}, []string{"seq_scan", "idx_scan", "n_tup_ins", "n_tup_upd", "n_tup_del"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, delta := range tables.Entries {
+		ret.tableIDs = append(ret.tableIDs, delta.Attributes.Int("relid"))
+		ret.allScans = append(ret.allScans, int64(delta.Counters["seq_scan"]+delta.Counters["idx_scan"]))
+		ret.writes = append(ret.writes, int64(delta.Counters["n_tup_ins"]+delta.Counters["n_tup_upd"]+delta.Counters["n_tup_del"]))
+	}
+
+	indexes, err := history.Compute(source, window, "pg_stat_user_indexes", []string{"indexrelid"}, []string{"idx_scan"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, delta := range indexes.Entries {
+		indexID := delta.Attributes.Int("indexrelid")
+		ret.indexIDs = append(ret.indexIDs, indexID)
+		ret.indexScans = append(ret.indexScans, int64(delta.Counters["idx_scan"]))
+		ret.indexCoverage[indexID] = delta.Coverage(window)
+	}
+
+	ret.warnings = append(tables.Warnings(), indexes.Warnings()...)
+
+	return ret, nil
+}
+
+// getCoverage returns the period over which the activity of the index was observed.
+func (u *indexUsage) getCoverage(indexID int64) time.Duration {
+	if coverage, ok := u.indexCoverage[indexID]; ok {
+		return coverage
+	}
+
+	return u.coverage
+}
+
+// reportUsage outputs any warnings (e.g. counter resets) and returns false if there is insufficient history to detect usage issues.
+func (d *IndexIssues) reportUsage(usage *indexUsage) bool {
+	if !usage.reported {
+		for _, warning := range usage.warnings {
+			log.Printf("WARNING: Database: %s, IndexIssues: %s\n", d.datasource.GetName(), warning)
+		}
+	}
+
+	if usage.coverage == 0 {
+		if !usage.reported {
+			log.Printf("WARNING: Database: %s, IndexIssues: insufficient snapshot history to detect index usage issues (%s)\n", d.datasource.GetName(), usage.period)
+		}
+		usage.reported = true
+		return false
+	}
+
+	return true
+}
+
+// DefaultMinHistory is the minimum history (if --min-history is not specified) for an analysis period, i.e. usage must be observed
+// for at least half of the period for unused indexes to be reported at full severity.
+func DefaultMinHistory(duration time.Duration) time.Duration {
+	return duration / 2
+}
+
+// usageSeverity returns the severity of an index usage issue, reduced if the usage was observed for less than the minimum history.
+func usageSeverity(coverage time.Duration, minHistory time.Duration) utils.IssueSeverity {
+	if coverage < minHistory {
+		return utils.Low
+	}
+
+	return utils.High
+}
+
+func formatCoverage(coverage time.Duration) string {
+	return fmt.Sprintf("%.1f days", coverage.Hours()/24)
+}
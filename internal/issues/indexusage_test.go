@@ -0,0 +1,32 @@
+package issues
+
+import (
+	"testing"
+	"time"
+
+	"pgmaven/internal/utils"
+)
+
+func TestUsageSeverity(t *testing.T) {
+	week := 7 * 24 * time.Hour
+	minHistory := DefaultMinHistory(week)
+	if minHistory != week/2 {
+		t.Fatalf("expected the default minimum history to be half the duration, got %v", minHistory)
+	}
+
+	tests := []struct {
+		coverage time.Duration
+		expected utils.IssueSeverity
+	}{
+		{week, utils.High},
+		{week - time.Hour, utils.High}, // snapshots rarely cover the full window
+		{minHistory, utils.High},
+		{minHistory - time.Second, utils.Low},
+		{time.Hour, utils.Low},
+	}
+	for _, test := range tests {
+		if actual := usageSeverity(test.coverage, minHistory); actual != test.expected {
+			t.Errorf("usageSeverity(%v, %v): expected %s, got %s", test.coverage, minHistory, test.expected, actual)
+		}
+	}
+}
@@ -5,8 +5,14 @@ import (
 	"fmt"
 	"log"
 	"pgmaven/internal/dbutils"
+	"pgmaven/internal/history"
 	"pgmaven/internal/utils"
+	"sort"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/exp/maps"
 )
 
 type TableIssues struct {
@@ -15,14 +21,9 @@ type TableIssues struct {
 	issues        []utils.Issue
 	timing        utils.Timing
 	specificIssue string
+	unused        *IndexIssues
 }
 
-const (
-	tableGrowthThreshold = 0.5
-	largeTableThreshold  = 10000000
-	minTableReport       = 100000
-)
-
 func (d *TableIssues) Init(context utils.Context, ds *dbutils.DataSource) {
 	d.datasource = ds
 	d.context = context
@@ -37,7 +38,8 @@ func (d *TableIssues) Execute(args ...string) {
 		d.specificIssue = args[0]
 	}
 
-	if d.isIssueEnabled("TableBloat") {
+	// Bloat is estimated from the live catalog, so is not available when analyzing an archive
+	if d.isIssueEnabled("TableBloat") && !d.datasource.IsOffline() {
 		d.doTableBloat()
 
 		if d.specificIssueEnabled() {
@@ -47,20 +49,18 @@ func (d *TableIssues) Execute(args ...string) {
 		}
 	}
 
-	query := `
-select relname, min(n_live_tup), max(n_live_tup), min(insert_dt), max(insert_dt), max(n_tup_upd + n_tup_del + n_tup_hot_upd)
-	from pgmaven_pg_stat_user_tables
-	where last_analyze is not null
-	and relname not like 'pgmaven%%'
-	group by relname`
-
-	err := d.datasource.ExecuteQueryRows(query, nil, tableIssuesProcessor, d)
-
+	tables, err := d.getTableHistory()
 	if err != nil {
-		fmt.Printf("ERROR: Database: %s, TableIssues: failed to list tables, error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, TableIssues: failed to list tables, error: %v\n", d.datasource.GetName(), err)
 		return
 	}
 
+	names := maps.Keys(tables)
+	sort.Strings(names)
+	for _, name := range names {
+		d.analyzeTable(tables[name])
+	}
+
 	d.timing.SetDurationMS(time.Now().UnixMilli() - startMS)
 }
 
@@ -76,15 +76,90 @@ func (d *TableIssues) isIssueEnabled(issue string) bool {
 	return issue == d.specificIssue
 }
 
-func tableIssuesProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
-	d := self.(*TableIssues)
-	tableName := string((*values[0].(*interface{})).([]uint8))
-	minRows := (*values[1].(*interface{})).(int64)
-	maxRows := (*values[2].(*interface{})).(int64)
-	minInsertDt := (*values[3].(*interface{})).(time.Time)
-	maxInsertDt := (*values[4].(*interface{})).(time.Time)
-	changes := (*values[5].(*interface{})).(int64)
-	timeDiff := maxInsertDt.Sub(minInsertDt).Milliseconds() / 1000
+// tableHistory is the range of the row counts (and times) captured by the snapshots for a table.
+type tableHistory struct {
+	tableName   string
+	minRows     int64
+	maxRows     int64
+	minInsertDt time.Time
+	maxInsertDt time.Time
+	changes     int64
+}
+
+// getTableHistory returns the history of every (analyzed) table in the schemas being analyzed captured by the snapshots, keyed
+// by the schema qualified table name.  This is aggregated by the database (the snapshots may span months in a repository), only an
+// archive is scanned.
+func (d *TableIssues) getTableHistory() (map[string]*tableHistory, error) {
+	source := history.Filter(history.NewSource(d.datasource), "pg_stat_user_tables")
+	snapshots, err := source.Snapshots()
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+	snapshotIDs := history.SnapshotIDs(snapshots, snapshots[0].ID, snapshots[len(snapshots)-1].ID)
+
+	ret := make(map[string]*tableHistory)
+	if d.datasource.GetArchive() == "" {
+		query := `
+select t.schemaname, t.relname, min(t.n_live_tup), max(t.n_live_tup), min(s.snapshot_dt), max(s.snapshot_dt), max(t.n_tup_upd + t.n_tup_del + t.n_tup_hot_upd)
+	from pgmaven_pg_stat_user_tables t
+	join pgmaven_snapshot s on s.snapshot_id = t.snapshot_id
+	where t.snapshot_id = ANY($1)
+	and t.last_analyze is not null
+	and t.relname not like 'pgmaven%'
+	group by t.schemaname, t.relname`
+
+		err = d.datasource.History().ExecuteQueryRows(query, []any{pq.Array(snapshotIDs)}, func(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
+			schemaName := string((*values[0].(*interface{})).([]uint8))
+			if !d.datasource.SchemaMatches(schemaName) {
+				return
+			}
+			tableName := utils.QualifiedName(schemaName, string((*values[1].(*interface{})).([]uint8)))
+			ret[tableName] = &tableHistory{tableName, (*values[2].(*interface{})).(int64), (*values[3].(*interface{})).(int64),
+				(*values[4].(*interface{})).(time.Time), (*values[5].(*interface{})).(time.Time), (*values[6].(*interface{})).(int64)}
+		}, nil)
+		return ret, err
+	}
+
+	times := make(map[int64]time.Time)
+	for _, snapshot := range snapshots {
+		times[snapshot.ID] = snapshot.Time
+	}
+
+	columns := []string{"schemaname", "relname", "n_live_tup", "n_tup_upd", "n_tup_del", "n_tup_hot_upd", "last_analyze"}
+	err = source.Scan("pg_stat_user_tables", snapshotIDs, columns, func(snapshotID int64, row history.Row) {
+		if row["last_analyze"] == nil || strings.HasPrefix(row.String("relname"), "pgmaven") || !d.datasource.SchemaMatches(row.String("schemaname")) {
+			return
+		}
+		tableName := utils.QualifiedName(row.String("schemaname"), row.String("relname"))
+
+		rows := row.Int("n_live_tup")
+		changes := row.Int("n_tup_upd") + row.Int("n_tup_del") + row.Int("n_tup_hot_upd")
+		insertDt := times[snapshotID]
+		table, ok := ret[tableName]
+		if !ok {
+			ret[tableName] = &tableHistory{tableName, rows, rows, insertDt, insertDt, changes}
+			return
+		}
+		table.minRows = min(table.minRows, rows)
+		table.maxRows = max(table.maxRows, rows)
+		if insertDt.Before(table.minInsertDt) {
+			table.minInsertDt = insertDt
+		}
+		if insertDt.After(table.maxInsertDt) {
+			table.maxInsertDt = insertDt
+		}
+		table.changes = max(table.changes, changes)
+	})
+
+	return ret, err
+}
+
+func (d *TableIssues) analyzeTable(table *tableHistory) {
+	tableName := table.tableName
+	minRows := table.minRows
+	maxRows := table.maxRows
+	changes := table.changes
+	timeDiff := table.maxInsertDt.Sub(table.minInsertDt).Milliseconds() / 1000
 	countDiff := maxRows - minRows
 
 	if maxRows == 0 {
@@ -95,7 +170,7 @@ func tableIssuesProcessor(rowNumber int, columnTypes []*sql.ColumnType, values [
 	const daySeconds = 24 * 60 * 60
 
 	if timeDiff < daySeconds/2 {
-		fmt.Printf("WARNING: Database: %s, TableIssues: Table: %s, insufficient data captured by snapshots (%d seconds)\n", d.datasource.GetDBName(), tableName, timeDiff)
+		log.Printf("WARNING: Database: %s, TableIssues: Table: %s, insufficient data captured by snapshots (%d seconds)\n", d.datasource.GetName(), tableName, timeDiff)
 		return
 	}
 
@@ -103,31 +178,44 @@ func tableIssuesProcessor(rowNumber int, columnTypes []*sql.ColumnType, values [
 	rowsPerDay := float32(countDiff) / days
 	dailyPercent := 100 * rowsPerDay / float32(maxRows)
 
-	if d.isIssueEnabled("TableGrowth") && maxRows > minTableReport && dailyPercent > tableGrowthThreshold {
+	if d.isIssueEnabled("TableGrowth") && float64(maxRows) > tableGrowthMinRows.Value() && float64(dailyPercent) > tableGrowthPercent.Value() {
 		detail := fmt.Sprintf("Table: %s, current rows: %d, is growing at %.2f%% per day\n%s",
 			tableName, maxRows, dailyPercent, d.getUnusedIndexes(tableName))
 		d.issues = append(d.issues, utils.Issue{IssueType: "TableGrowth", Target: tableName, Detail: detail, Severity: utils.Medium, Solution: "REVIEW table - consider partitioning and/or pruning\n"})
 	}
 
-	if d.isIssueEnabled("TableSizeLarge") && maxRows > largeTableThreshold {
+	if d.isIssueEnabled("TableSizeLarge") && float64(maxRows) > tableLargeRows.Value() {
 		isPartitionedQuery := `
 SELECT count(*)
 	FROM   pg_catalog.pg_inherits
 	WHERE  inhparent = $1::regclass`
-		partitionCount, _ := d.datasource.ExecuteQueryRow(isPartitionedQuery, []any{tableName})
+		var partitionCount any = int64(0)
+		partitioned := "not partitioned"
+		if d.datasource.IsOffline() {
+			partitioned = "partitioning not checked (archive)"
+		} else {
+			partitionCount, _ = d.datasource.ExecuteQueryRow(isPartitionedQuery, []any{tableName})
+		}
 		if partitionCount.(int64) == 0 {
-			detail := fmt.Sprintf("Table: %s, current rows: %.2fM, insert only: %t, is large and not partitioned\n%s",
-				tableName, float32(maxRows)/10000000.0, changes == 0, d.getUnusedIndexes(tableName))
+			detail := fmt.Sprintf("Table: %s, current rows: %.2fM, insert only: %t, is large and %s\n%s",
+				tableName, float32(maxRows)/10000000.0, changes == 0, partitioned, d.getUnusedIndexes(tableName))
 			d.issues = append(d.issues, utils.Issue{IssueType: "TableSizeLarge", Target: tableName, Severity: utils.Medium, Detail: detail, Solution: "REVIEW table - consider partitioning and/or pruning\n"})
 		}
 	}
 }
 
 func (d *TableIssues) getUnusedIndexes(tableName string) string {
-	sub := IndexIssues{}
-	sub.Init(d.context, d.datasource)
-	sub.Execute(tableName)
-	unused := sub.GetIssues()
+	if d.datasource.IsOffline() {
+		return ""
+	}
+
+	// Reuse the IndexIssues detector so the table sizes (held per detector for --parallel) are only calculated once per database
+	if d.unused == nil {
+		d.unused = new(IndexIssues)
+		d.unused.Init(d.context, d.datasource)
+	}
+	d.unused.Execute(tableName)
+	unused := d.unused.GetIssues()
 
 	if len(unused) == 0 {
 		return ""
@@ -268,41 +356,44 @@ bloat_data AS (
         expected_bytes, round(expected_bytes/(1024^2)::NUMERIC,3) as expected_mb,
         round(bloat_bytes*100/table_bytes) as pct_bloat,
         round(bloat_bytes/(1024::NUMERIC^2),2) as mb_bloat,
-        table_bytes, expected_bytes, est_rows
+        table_bytes, expected_bytes, est_rows, bloat_bytes
     FROM table_estimates_plus
 )
--- filter output for bloated tables
+-- filter output for bloated tables (in the schemas being analyzed)
 SELECT schemaname, tablename,
     can_estimate,
     est_rows,
     pct_bloat, mb_bloat,
-    table_mb
+    table_mb, bloat_bytes::bigint
 FROM bloat_data
 -- this where clause defines which tables actually appear
 -- in the bloat chart
--- example below filters for tables which are either 50%
+-- by default filters for tables which are either 50%
 -- bloated and more than 20mb in size, or more than 25%
 -- bloated and more than 1GB in size
-WHERE ( pct_bloat >= 50 AND mb_bloat >= 20 )
-    OR ( pct_bloat >= 25 AND mb_bloat >= 1000 )
+WHERE schemaname = ANY($1)
+    AND (( pct_bloat >= $2::numeric AND mb_bloat >= $3::numeric )
+    OR ( pct_bloat >= $4::numeric AND mb_bloat >= $5::numeric ))
 ORDER BY pct_bloat DESC;`
 
-	err := d.datasource.ExecuteQueryRows(tableBloatQuery, nil, tableBloatProcessor, d)
+	err := d.datasource.ExecuteQueryRows(tableBloatQuery, []any{pq.Array(d.datasource.GetSchemas()),
+		tableBloatPercent.Value(), tableBloatMB.Value(), tableBloatLargePercent.Value(), tableBloatLargeMB.Value()}, tableBloatProcessor, d)
 	if err != nil {
-		log.Printf("ERROR: Database: %s, Overlapping index query failed with error: %v\n", d.datasource.GetDBName(), err)
+		log.Printf("ERROR: Database: %s, Table bloat query failed with error: %v\n", d.datasource.GetName(), err)
 	}
 }
 
 func tableBloatProcessor(rowNumber int, columnTypes []*sql.ColumnType, values []interface{}, self any) {
 	d := self.(*TableIssues)
-	tableName := string((*values[1].(*interface{})).([]uint8))
+	tableName := utils.QualifiedName(string((*values[0].(*interface{})).([]uint8)), string((*values[1].(*interface{})).([]uint8)))
 	estRows := string((*values[3].(*interface{})).([]uint8))
 	pctBloat := string((*values[4].(*interface{})).([]uint8))
+	bloatBytes := (*values[7].(*interface{})).(int64)
 
 	detail := fmt.Sprintf("Table: %s, Bloat: %s%%, Estimated Rows: %s\n", tableName, pctBloat, estRows)
 
 	d.issues = append(d.issues, utils.Issue{IssueType: "TableBloat", Target: tableName, Detail: detail, Severity: utils.Medium,
-		Solution: fmt.Sprintf("VACUUM \"%s\"\n", tableName)})
+		Solution: fmt.Sprintf("VACUUM %s\n", tableName), WastedBytes: bloatBytes})
 }
 
 func (d *TableIssues) GetIssues() []utils.Issue {
@@ -0,0 +1,164 @@
+package issues
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// Threshold is a limit used by a detector to decide whether to report an issue, each is a command line option (e.g.
+// --index-bloat-percent) and may also be set in the thresholds file (keyed by the option name).  The values are only set at
+// startup (before any detector runs), so may be read concurrently by the detectors (e.g. with --parallel).
+type Threshold struct {
+	Name        string
+	Issues      string
+	Description string
+	Default     float64
+	Min         float64
+	Max         float64
+	value       float64
+}
+
+const unlimited = 1e18
+
+func newThreshold(name string, issues string, description string, def float64, min float64, max float64) *Threshold {
+	return &Threshold{Name: name, Issues: issues, Description: description, Default: def, Min: min, Max: max, value: def}
+}
+
+var (
+	smallTableRows              = newThreshold("small-table-rows", "IndexSmall, TableAnalyze", "tables with fewer rows are small (indexes are unnecessary)", 100, 1, unlimited)
+	tableGrowthPercent          = newThreshold("table-growth-percent", "TableGrowth", "minimum daily growth (percent of rows)", 0.5, 0, unlimited)
+	tableGrowthMinRows          = newThreshold("table-growth-min-rows", "TableGrowth", "minimum rows for a table to be reported as growing", 100000, 0, unlimited)
+	tableLargeRows              = newThreshold("table-large-rows", "TableSizeLarge", "minimum rows for an unpartitioned table to be reported as large", 10000000, 1, unlimited)
+	tableBloatPercent           = newThreshold("table-bloat-percent", "TableBloat", "minimum bloat (percent of the table)", 50, 0, 100)
+	tableBloatMB                = newThreshold("table-bloat-mb", "TableBloat", "minimum bloat (MB)", 20, 0, unlimited)
+	tableBloatLargePercent      = newThreshold("table-bloat-large-percent", "TableBloat", "minimum bloat (percent of the table) if the bloat exceeds table-bloat-large-mb", 25, 0, 100)
+	tableBloatLargeMB           = newThreshold("table-bloat-large-mb", "TableBloat", "bloat (MB) above which table-bloat-large-percent applies", 1000, 0, unlimited)
+	indexBloatPercent           = newThreshold("index-bloat-percent", "IndexBloat", "minimum bloat (percent of the index)", 50, 0, 100)
+	indexBloatBytes             = newThreshold("index-bloat-bytes", "IndexBloat", "minimum bloat (bytes)", 50000000, 0, unlimited)
+	indexNullPercent            = newThreshold("index-null-percent", "IndexHighNullPercent", "minimum percentage of nulls in the indexed column", 95, 0, 100)
+	indexNullMinMB              = newThreshold("index-null-min-mb", "IndexHighNullPercent", "minimum index size (MB)", 10, 0, unlimited)
+	indexMissingMinTableBytes   = newThreshold("index-missing-min-table-bytes", "IndexMissing", "minimum table size (bytes)", 1000000, 0, unlimited)
+	indexMissingMinScans        = newThreshold("index-missing-min-scans", "IndexMissing", "minimum number of scans (sequential and index)", 100000, 0, unlimited)
+	indexMissingSeqPercent      = newThreshold("index-missing-seq-percent", "IndexMissing", "minimum percentage of scans that are sequential", 10, 0, 100)
+	indexMissingMinSeqTuples    = newThreshold("index-missing-min-seq-tuples", "IndexMissing", "minimum tuples read by sequential scans", 1000000, 0, unlimited)
+	indexMissingMinAvgSeqTuples = newThreshold("index-missing-min-avg-seq-tuples", "IndexMissing", "minimum average tuples read per sequential scan", 1000, 0, unlimited)
+	queryCPUPercent             = newThreshold("query-cpu-percent", "QueryIssues", "minimum percentage of the total execution time for a query to be reported", 1, 0, 100)
+)
+
+// thresholds is the set of thresholds (in the order listed by --detect Help).
+var thresholds = []*Threshold{
+	smallTableRows, tableGrowthPercent, tableGrowthMinRows, tableLargeRows,
+	tableBloatPercent, tableBloatMB, tableBloatLargePercent, tableBloatLargeMB,
+	indexBloatPercent, indexBloatBytes, indexNullPercent, indexNullMinMB,
+	indexMissingMinTableBytes, indexMissingMinScans, indexMissingSeqPercent, indexMissingMinSeqTuples, indexMissingMinAvgSeqTuples,
+	queryCPUPercent,
+}
+
+var thresholdsFile string
+
+// String, Set and Type implement pflag.Value so each threshold is validated as it is set.
+func (t *Threshold) String() string {
+	return strconv.FormatFloat(t.value, 'f', -1, 64)
+}
+
+func (t *Threshold) Set(value string) error {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return fmt.Errorf("invalid number '%s'", value)
+	}
+	if v < t.Min || v > t.Max {
+		if t.Max == unlimited {
+			return fmt.Errorf("%s must be at least %s", value, strconv.FormatFloat(t.Min, 'f', -1, 64))
+		}
+		return fmt.Errorf("%s must be between %s and %s", value, strconv.FormatFloat(t.Min, 'f', -1, 64), strconv.FormatFloat(t.Max, 'f', -1, 64))
+	}
+	t.value = v
+
+	return nil
+}
+
+func (t *Threshold) Type() string {
+	return "number"
+}
+
+// InitThresholds registers the --thresholds option and an option for each threshold.
+func InitThresholds() {
+	flag.StringVar(&thresholdsFile, "thresholds", "", "file of detector thresholds (keyed by option name, e.g. index-bloat-percent: 40), options take precedence")
+	for _, t := range thresholds {
+		flag.Var(t, t.Name, fmt.Sprintf("%s threshold - %s", t.Issues, t.Description))
+	}
+}
+
+// ApplyThresholds sets the thresholds from the thresholds file (if any), those set on the command line (or via a profile) take precedence.
+func ApplyThresholds(changed func(string) bool) error {
+	if thresholdsFile == "" {
+		return nil
+	}
+
+	content, err := os.ReadFile(thresholdsFile)
+	if err != nil {
+		return fmt.Errorf("failed to read thresholds file '%s', error: %v", thresholdsFile, err)
+	}
+	values := make(map[string]any)
+	if err := yaml.Unmarshal(content, &values); err != nil {
+		return fmt.Errorf("failed to parse thresholds file '%s', error: %v", thresholdsFile, err)
+	}
+
+	return setThresholds(values, changed, thresholdsFile)
+}
+
+func setThresholds(values map[string]any, changed func(string) bool, file string) error {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		t := lookupThreshold(name)
+		if t == nil {
+			return fmt.Errorf("thresholds file '%s', threshold '%s' not recognized (use --detect Help to list thresholds)", file, name)
+		}
+		if changed(name) {
+			continue
+		}
+		if err := t.Set(fmt.Sprint(values[name])); err != nil {
+			return fmt.Errorf("thresholds file '%s', threshold '%s': %v", file, name, err)
+		}
+	}
+
+	return nil
+}
+
+func lookupThreshold(name string) *Threshold {
+	for _, t := range thresholds {
+		if t.Name == name {
+			return t
+		}
+	}
+
+	return nil
+}
+
+// Value returns the effective value of the threshold.
+func (t *Threshold) Value() float64 {
+	return t.value
+}
+
+// writeThresholds outputs the effective value of each threshold (along with the default if different).
+func writeThresholds(w io.Writer) {
+	fmt.Fprintln(w, "\nThresholds (set via the option or --thresholds file):")
+	for _, t := range thresholds {
+		value := t.String()
+		if t.value != t.Default {
+			value += fmt.Sprintf(" (default: %s)", strconv.FormatFloat(t.Default, 'f', -1, 64))
+		}
+		fmt.Fprintf(w, "  %s = %s - %s: %s\n", t.Name, value, t.Issues, t.Description)
+	}
+}
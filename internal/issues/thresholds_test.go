@@ -0,0 +1,44 @@
+package issues
+
+import (
+	"testing"
+)
+
+func TestThresholds(t *testing.T) {
+	// The defaults apply without InitThresholds (e.g. detectors run from tests)
+	for _, threshold := range thresholds {
+		if threshold.Value() != threshold.Default {
+			t.Fatalf("%s: expected the default %v, got %v", threshold.Name, threshold.Default, threshold.Value())
+		}
+	}
+	if tableLargeRows.Value() != 10000000 {
+		t.Fatalf("unexpected table-large-rows %v", tableLargeRows.Value())
+	}
+	defer func() {
+		for _, threshold := range thresholds {
+			threshold.value = threshold.Default
+		}
+	}()
+
+	values := map[string]any{"index-bloat-percent": 40, "table-growth-percent": 0.25, "query-cpu-percent": 5}
+	changed := func(name string) bool { return name == "query-cpu-percent" }
+	if err := setThresholds(values, changed, "thresholds.yaml"); err != nil {
+		t.Fatalf("setThresholds failed: %v", err)
+	}
+	if indexBloatPercent.Value() != 40 || tableGrowthPercent.Value() != 0.25 {
+		t.Fatalf("thresholds not set from the file")
+	}
+	if queryCPUPercent.Value() != 1 {
+		t.Fatalf("threshold set on the command line should take precedence, got %v", queryCPUPercent.Value())
+	}
+
+	if err := setThresholds(map[string]any{"index-bloat-percent": 150}, changed, "thresholds.yaml"); err == nil {
+		t.Fatalf("expected error for out of range threshold")
+	}
+	if err := setThresholds(map[string]any{"index-bloat": 10}, changed, "thresholds.yaml"); err == nil {
+		t.Fatalf("expected error for unknown threshold")
+	}
+	if err := lookupThreshold("small-table-rows").Set("abc"); err == nil {
+		t.Fatalf("expected error for invalid number")
+	}
+}
@@ -0,0 +1,235 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"pgmaven/internal/utils"
+)
+
+// DefaultWorstTenants is the number of (most wasteful) tenants listed for each Aggregate.
+const DefaultWorstTenants = 5
+
+// Aggregate is an Issue reported for the same logical object (i.e. the target with the schema removed) in many schemas, e.g. where
+// each tenant has its own schema with an identical layout.
+type Aggregate struct {
+	Cluster     string              `json:"cluster,omitempty"`
+	Database    string              `json:"database"`
+	IssueType   string              `json:"issueType"`
+	Object      string              `json:"object"`
+	Severity    utils.IssueSeverity `json:"severity"`
+	WastedBytes int64               `json:"wastedBytes"`
+	Tenants     []Tenant            `json:"tenants"`
+	Detail      string              `json:"detail"`
+	Solution    string              `json:"solution"`
+}
+
+// Tenant is a schema in which the Issue was reported.
+type Tenant struct {
+	Schema      string              `json:"schema"`
+	Severity    utils.IssueSeverity `json:"severity"`
+	WastedBytes int64               `json:"wastedBytes"`
+}
+
+// AggregateIssues groups the Issues for the same logical object across schemas, Issues that are not schema qualified (e.g. Config)
+// or only reported in a single schema are returned unchanged.  The Aggregates are ordered by the total wasted space, and the tenants
+// of each by their wasted space.
+func AggregateIssues(issues []utils.Issue) ([]Aggregate, []utils.Issue) {
+	type key struct {
+		cluster, database, issueType, object string
+	}
+	groups := make(map[key][]utils.Issue)
+	order := make([]key, 0)
+	for _, issue := range issues {
+		schema, object := utils.SplitQualifiedName(issue.Target)
+		if schema == "" {
+			continue
+		}
+		k := key{issue.Cluster, issue.Database, issue.IssueType, object}
+		if _, ok := groups[k]; !ok {
+			order = append(order, k)
+		}
+		groups[k] = append(groups[k], issue)
+	}
+
+	aggregates := make([]Aggregate, 0)
+	aggregated := make(map[string]bool)
+	for _, k := range order {
+		group := groups[k]
+		if len(group) < 2 {
+			continue
+		}
+
+		aggregate := Aggregate{Cluster: k.cluster, Database: k.database, IssueType: k.issueType, Object: k.object, Severity: utils.Low}
+		for _, issue := range group {
+			schema, _ := utils.SplitQualifiedName(issue.Target)
+			aggregate.Tenants = append(aggregate.Tenants, Tenant{Schema: schema, Severity: issue.Severity, WastedBytes: issue.WastedBytes})
+			aggregate.WastedBytes += issue.WastedBytes
+			if issue.Severity.AtLeast(aggregate.Severity) {
+				aggregate.Severity = issue.Severity
+			}
+			aggregated[issue.Fingerprint()] = true
+		}
+		sort.SliceStable(aggregate.Tenants, func(i, j int) bool {
+			return aggregate.Tenants[i].WastedBytes > aggregate.Tenants[j].WastedBytes
+		})
+
+		// The detail is that of the worst tenant, and the solution is templated for every tenant
+		worst := group[0]
+		for _, issue := range group {
+			if issue.WastedBytes > worst.WastedBytes {
+				worst = issue
+			}
+		}
+		worstSchema, _ := utils.SplitQualifiedName(worst.Target)
+		aggregate.Detail = worst.Detail
+		aggregate.Solution = templateSolution(worst.Solution, worstSchema, aggregate.Schemas())
+		aggregates = append(aggregates, aggregate)
+	}
+
+	sort.SliceStable(aggregates, func(i, j int) bool {
+		return aggregates[i].WastedBytes > aggregates[j].WastedBytes
+	})
+
+	others := make([]utils.Issue, 0, len(issues))
+	for _, issue := range issues {
+		if !aggregated[issue.Fingerprint()] {
+			others = append(others, issue)
+		}
+	}
+
+	return aggregates, others
+}
+
+// Schemas returns the schemas in which the Issue was reported (in order).
+func (a *Aggregate) Schemas() []string {
+	ret := make([]string, len(a.Tenants))
+	for i, tenant := range a.Tenants {
+		ret[i] = tenant.Schema
+	}
+	sort.Strings(ret)
+
+	return ret
+}
+
+// count returns the number of tenants with a severity at or above the threshold.
+func (a *Aggregate) count(threshold utils.IssueSeverity) int {
+	count := 0
+	for _, tenant := range a.Tenants {
+		if tenant.Severity.AtLeast(threshold) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// DumpTo outputs the Aggregate in a human-readable form (in the style of Issue.DumpTo) listing the worst tenants.
+func (a *Aggregate) DumpTo(w io.Writer, worstTenants int) {
+	fmt.Fprintf(w, "ISSUE: %s\n", a.IssueType)
+	fmt.Fprintf(w, "SEVERITY: %s\n", a.Severity)
+	if a.Cluster != "" {
+		fmt.Fprintf(w, "CLUSTER: %s\n", a.Cluster)
+	}
+	if a.Database != "" {
+		fmt.Fprintf(w, "DATABASE: %s\n", a.Database)
+	}
+	fmt.Fprintf(w, "TARGET: %s (%d schemas)\n", a.Object, len(a.Tenants))
+	fmt.Fprintf(w, "WASTED: %s\n", FormatBytes(a.WastedBytes))
+	worst := make([]string, 0, worstTenants)
+	for _, tenant := range a.Tenants[:min(worstTenants, len(a.Tenants))] {
+		worst = append(worst, fmt.Sprintf("%s (%s)", tenant.Schema, FormatBytes(tenant.WastedBytes)))
+	}
+	fmt.Fprintf(w, "WORST: %s\n", strings.Join(worst, ", "))
+	fmt.Fprintf(w, "DETAIL:\n%s", utils.Indent(a.Detail))
+	fmt.Fprintf(w, "SUGGESTION:\n%s", utils.Indent(a.Solution))
+}
+
+// templateSolution returns the Solution for every schema, statements are executed for each schema via psql's \gexec and the
+// schema in any other line (e.g. a comment) is replaced by <schema>.
+func templateSolution(solution string, schema string, schemas []string) string {
+	var ret strings.Builder
+	for _, line := range strings.Split(strings.TrimSuffix(solution, "\n"), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if i := strings.Index(trimmed, " -- "); i != -1 {
+			trimmed = strings.TrimSpace(trimmed[:i])
+		}
+		if isExecutable(trimmed) {
+			ret.WriteString(GexecStatement(strings.TrimSuffix(trimmed, ";"), schema, schemas) + "\n")
+			continue
+		}
+		ret.WriteString(replaceQualifier(line, utils.QuoteIdentifier(schema)+".", "<schema>.") + "\n")
+	}
+
+	return ret.String()
+}
+
+// Template returns the statement as a format() string, with the (qualifying) schema replaced by %1$I, false if the statement does
+// not reference the schema.
+func Template(statement string, schema string) (string, bool) {
+	qualifier := strings.ReplaceAll(utils.QuoteIdentifier(schema)+".", "%", "%%")
+	template := strings.ReplaceAll(statement, "%", "%%")
+	replaced := replaceQualifier(template, qualifier, "%1$I.")
+	if replaced == template {
+		return "", false
+	}
+
+	return replaced, true
+}
+
+// replaceQualifier replaces the schema qualifier (e.g. tenant_1.) where it is not part of a longer identifier.
+func replaceQualifier(s string, qualifier string, replacement string) string {
+	var ret strings.Builder
+	for {
+		i := strings.Index(s, qualifier)
+		if i == -1 {
+			ret.WriteString(s)
+			return ret.String()
+		}
+		ret.WriteString(s[:i])
+		if i > 0 && isIdentifierChar(s[i-1]) {
+			ret.WriteString(qualifier)
+		} else {
+			ret.WriteString(replacement)
+		}
+		s = s[i+len(qualifier):]
+	}
+}
+
+func isIdentifierChar(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c == '_' || c == '$' || c == '"' || c == '.'
+}
+
+// GexecStatement returns a query that generates the statement for each schema, to be executed by psql's \gexec.
+func GexecStatement(statement string, schema string, schemas []string) string {
+	template, ok := Template(statement, schema)
+	if !ok {
+		return statement + ";"
+	}
+
+	literals := make([]string, len(schemas))
+	for i, s := range schemas {
+		literals[i] = quoteLiteral(s)
+	}
+
+	return fmt.Sprintf("SELECT format(%s, schema_name) FROM unnest(ARRAY[%s]) AS schema_name ORDER BY schema_name \\gexec",
+		quoteLiteral(template), strings.Join(literals, ", "))
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// FormatBytes returns the size in a human-readable form (in the style of pg_size_pretty).
+func FormatBytes(bytes int64) string {
+	units := []string{"bytes", "kB", "MB", "GB", "TB"}
+	value, unit := bytes, 0
+	for unit < len(units)-1 && value >= 10*1024 {
+		value = (value + 512) / 1024
+		unit++
+	}
+
+	return fmt.Sprintf("%d %s", value, units[unit])
+}
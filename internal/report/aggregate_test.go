@@ -0,0 +1,94 @@
+package report
+
+import (
+	"strings"
+	"testing"
+
+	"pgmaven/internal/utils"
+)
+
+func TestAggregateIssues(t *testing.T) {
+	unused := func(schema string, bytes int64) utils.Issue {
+		target := utils.QualifiedName(schema, "orders_status_idx")
+		return utils.Issue{Database: "saas", IssueType: "IndexUnused", Target: target, Severity: utils.High,
+			Detail: "Table: " + schema + ".orders\n", Solution: "DROP INDEX " + target + "\n", WastedBytes: bytes}
+	}
+	issues := []utils.Issue{unused("tenant_1", 1000), unused("tenant_2", 5000), unused("Tenant3", 3000),
+		{Database: "saas", IssueType: "IndexUnused", Target: "tenant_1.invoices_idx", Severity: utils.High},
+		{Database: "saas", IssueType: "Config", Target: "work_mem", Severity: utils.Medium}}
+
+	aggregates, others := AggregateIssues(issues)
+	if len(aggregates) != 1 || len(others) != 2 {
+		t.Fatalf("expected 1 aggregate and 2 other issues, found %d and %d", len(aggregates), len(others))
+	}
+
+	aggregate := aggregates[0]
+	if aggregate.Object != "orders_status_idx" || aggregate.WastedBytes != 9000 || len(aggregate.Tenants) != 3 ||
+		aggregate.Tenants[0].Schema != "tenant_2" || aggregate.Tenants[1].Schema != "Tenant3" {
+		t.Errorf("unexpected aggregate %+v", aggregate)
+	}
+	if aggregate.Detail != "Table: tenant_2.orders\n" {
+		t.Errorf("expected the detail of the worst tenant, found '%s'", aggregate.Detail)
+	}
+	expected := `SELECT format('DROP INDEX %1$I.orders_status_idx', schema_name) FROM unnest(ARRAY['Tenant3', 'tenant_1', 'tenant_2']) AS schema_name ORDER BY schema_name \gexec` + "\n"
+	if aggregate.Solution != expected {
+		t.Errorf("unexpected solution '%s'", aggregate.Solution)
+	}
+	if aggregate.count(utils.High) != 3 {
+		t.Errorf("expected 3 HIGH tenants, found %d", aggregate.count(utils.High))
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tests := []struct {
+		statement string
+		schema    string
+		expected  string
+	}{
+		{"REINDEX INDEX CONCURRENTLY tenant_1.orders_idx", "tenant_1", "REINDEX INDEX CONCURRENTLY %1$I.orders_idx"},
+		{"CREATE INDEX CONCURRENTLY pct_idx ON \"Tenant%1\".orders USING btree (a)", "Tenant%1", "CREATE INDEX CONCURRENTLY pct_idx ON %1$I.orders USING btree (a)"},
+		{"VACUUM xtenant_1.orders", "tenant_1", ""},
+	}
+
+	for _, test := range tests {
+		actual, ok := Template(test.statement, test.schema)
+		if ok != (test.expected != "") || actual != test.expected {
+			t.Errorf("Template(%s, %s): expected '%s', got '%s' (%v)", test.statement, test.schema, test.expected, actual, ok)
+		}
+	}
+}
+
+func TestRemediationAggregate(t *testing.T) {
+	r := NewRemediation(0, true)
+	statements := make([]Statement, 0)
+	for _, schema := range []string{"tenant_2", "tenant_1"} {
+		issue := utils.Issue{IssueType: "IndexUnused", Target: schema + ".orders_idx", Solution: "DROP INDEX " + schema + ".orders_idx\n"}
+		statement := Statements(issue)[0]
+		statement.Rollback = "CREATE INDEX CONCURRENTLY orders_idx ON " + schema + ".orders USING btree (status)"
+		statements = append(statements, statement)
+	}
+	// The rollback for a table in the search_path is not qualified, so cannot be templated
+	public := utils.Issue{IssueType: "IndexUnused", Target: "public.orders_idx", Solution: "DROP INDEX public.orders_idx\n"}
+	statement := Statements(public)[0]
+	statement.Rollback = "CREATE INDEX CONCURRENTLY orders_idx ON orders USING btree (status)"
+	statements = append(statements, statement)
+
+	groups := r.group(statements)
+	if len(groups) != 2 || len(groups[0].schemas) != 2 || len(groups[1].schemas) != 0 {
+		t.Fatalf("expected the tenant statements to be grouped, found %d groups", len(groups))
+	}
+	if actual := groups[0].statement(groups[0].SQL); !strings.HasPrefix(actual, "SELECT format('DROP INDEX CONCURRENTLY %1$I.orders_idx', schema_name) FROM unnest(ARRAY['tenant_1', 'tenant_2'])") {
+		t.Errorf("unexpected statement '%s'", actual)
+	}
+	if actual := groups[1].statement(groups[1].SQL); actual != "DROP INDEX CONCURRENTLY public.orders_idx;" {
+		t.Errorf("unexpected statement '%s'", actual)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for bytes, expected := range map[int64]string{0: "0 bytes", 10239: "10239 bytes", 10240: "10 kB", 52428800: "50 MB"} {
+		if actual := FormatBytes(bytes); actual != expected {
+			t.Errorf("FormatBytes(%d): expected '%s', got '%s'", bytes, expected, actual)
+		}
+	}
+}
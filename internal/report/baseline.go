@@ -0,0 +1,152 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/utils"
+)
+
+const expiresLayout = "2006-01-02"
+
+// BaselineEntry records an accepted Issue, optionally with a justification and an expiry date (YYYY-MM-DD).
+type BaselineEntry struct {
+	Fingerprint   string              `json:"fingerprint"`
+	Cluster       string              `json:"cluster,omitempty"`
+	Database      string              `json:"database"`
+	IssueType     string              `json:"issueType"`
+	Target        string              `json:"target"`
+	Severity      utils.IssueSeverity `json:"severity"`
+	Justification string              `json:"justification,omitempty"`
+	Expires       string              `json:"expires,omitempty"`
+}
+
+// Baseline is the set of known Issues which should not be reported again unless they regress or the entry expires.
+type Baseline struct {
+	Entries []BaselineEntry `json:"entries"`
+	index   map[string]*BaselineEntry
+}
+
+// LoadBaseline reads (and validates) a Baseline file.
+func LoadBaseline(file string) (*Baseline, error) {
+	buffer, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read baseline file '%s', error: %v", file, err)
+	}
+
+	b := new(Baseline)
+	if err := json.Unmarshal(buffer, b); err != nil {
+		return nil, fmt.Errorf("failed to parse baseline file '%s', error: %v", file, err)
+	}
+
+	for _, entry := range b.Entries {
+		if entry.Expires == "" {
+			continue
+		}
+		if _, err := time.Parse(expiresLayout, entry.Expires); err != nil {
+			return nil, fmt.Errorf("baseline file '%s', fingerprint: %s, invalid expiry date '%s' (expected YYYY-MM-DD)", file, entry.Fingerprint, entry.Expires)
+		}
+	}
+
+	b.buildIndex()
+
+	return b, nil
+}
+
+// NewBaseline creates a Baseline from the Issues provided, any justification and expiry date in the previous Baseline are retained.
+func NewBaseline(issues []utils.Issue, previous *Baseline) *Baseline {
+	b := &Baseline{Entries: make([]BaselineEntry, 0, len(issues))}
+	seen := make(map[string]bool)
+
+	for _, issue := range issues {
+		fingerprint := issue.Fingerprint()
+		if seen[fingerprint] {
+			continue
+		}
+		seen[fingerprint] = true
+
+		entry := BaselineEntry{Fingerprint: fingerprint, Cluster: issue.Cluster, Database: issue.Database, IssueType: issue.IssueType, Target: issue.Target, Severity: issue.Severity}
+		if previous != nil {
+			if existing, ok := previous.lookup(issue); ok {
+				entry.Justification = existing.Justification
+				entry.Expires = existing.Expires
+			}
+		}
+		b.Entries = append(b.Entries, entry)
+	}
+
+	b.buildIndex()
+
+	return b
+}
+
+func (b *Baseline) buildIndex() {
+	b.index = make(map[string]*BaselineEntry, len(b.Entries))
+	for i := range b.Entries {
+		b.index[b.Entries[i].Fingerprint] = &b.Entries[i]
+	}
+}
+
+// lookup returns the entry for the Issue, entries from baselines written before targets were schema qualified match objects in
+// the default schema.
+func (b *Baseline) lookup(issue utils.Issue) (*BaselineEntry, bool) {
+	if entry, ok := b.lookupCluster(issue); ok {
+		return entry, true
+	}
+	if schema, name := utils.SplitQualifiedName(issue.Target); schema == dbutils.DefaultSchema {
+		issue.Target = name
+		return b.lookupCluster(issue)
+	}
+
+	return nil, false
+}
+
+// lookupCluster returns the entry for the Issue, entries from baselines written before the cluster was recorded match any cluster.
+func (b *Baseline) lookupCluster(issue utils.Issue) (*BaselineEntry, bool) {
+	if entry, ok := b.index[issue.Fingerprint()]; ok {
+		return entry, true
+	}
+	if issue.Cluster == "" {
+		return nil, false
+	}
+
+	issue.Cluster = ""
+	entry, ok := b.index[issue.Fingerprint()]
+	if ok && entry.Cluster != "" {
+		return nil, false
+	}
+
+	return entry, ok
+}
+
+// Suppress returns the Issues which are new or have regressed (i.e. are more severe than the baseline), along with a count of those suppressed.
+func (b *Baseline) Suppress(issues []utils.Issue, now time.Time) ([]utils.Issue, int) {
+	today := now.Format(expiresLayout)
+	ret := make([]utils.Issue, 0, len(issues))
+
+	for _, issue := range issues {
+		entry, ok := b.lookup(issue)
+		if !ok || (entry.Expires != "" && entry.Expires < today) || issue.Severity < entry.Severity {
+			ret = append(ret, issue)
+		}
+	}
+
+	return ret, len(issues) - len(ret)
+}
+
+// Write outputs the Baseline to the file specified.
+func (b *Baseline) Write(file string) error {
+	buffer, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode baseline, error: %v", err)
+	}
+
+	if err := os.WriteFile(file, append(buffer, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write baseline file '%s', error: %v", file, err)
+	}
+
+	return nil
+}
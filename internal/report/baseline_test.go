@@ -0,0 +1,82 @@
+package report
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pgmaven/internal/utils"
+)
+
+func TestBaselineSuppress(t *testing.T) {
+	accepted := utils.Issue{Database: "demo", IssueType: "IndexSmall", Target: "idx_small", Severity: utils.Medium}
+	expiring := utils.Issue{Database: "demo", IssueType: "IndexUnused", Target: "idx_unused", Severity: utils.High}
+	baseline := NewBaseline([]utils.Issue{accepted, expiring}, nil)
+	baseline.Entries[1].Expires = "2024-06-30"
+
+	file := filepath.Join(t.TempDir(), "baseline.json")
+	if err := baseline.Write(file); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	baseline, err := LoadBaseline(file)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	regressed := accepted
+	regressed.Severity = utils.High
+	fresh := utils.Issue{Database: "demo", IssueType: "IndexSmall", Target: "idx_other", Severity: utils.Medium}
+
+	now := time.Date(2024, 6, 30, 12, 0, 0, 0, time.UTC)
+	kept, suppressed := baseline.Suppress([]utils.Issue{accepted, expiring, fresh}, now)
+	if suppressed != 2 || len(kept) != 1 || kept[0].Target != "idx_other" {
+		t.Fatalf("expected only idx_other to be reported, found %v (suppressed: %d)", kept, suppressed)
+	}
+
+	kept, suppressed = baseline.Suppress([]utils.Issue{regressed, expiring}, now.AddDate(0, 0, 1))
+	if suppressed != 0 || len(kept) != 2 {
+		t.Fatalf("expected regressed and expired issues to be reported, found %v (suppressed: %d)", kept, suppressed)
+	}
+}
+
+func TestBaselineRetainsJustification(t *testing.T) {
+	issue := utils.Issue{Database: "demo", IssueType: "IndexSmall", Target: "idx_small", Severity: utils.Medium}
+	previous := NewBaseline([]utils.Issue{issue}, nil)
+	previous.Entries[0].Justification = "Required for month-end reporting"
+
+	updated := NewBaseline([]utils.Issue{issue, issue}, previous)
+	if len(updated.Entries) != 1 || updated.Entries[0].Justification != "Required for month-end reporting" {
+		t.Fatalf("expected justification to be retained, found %v", updated.Entries)
+	}
+}
+
+func TestBaselineCluster(t *testing.T) {
+	legacy := NewBaseline([]utils.Issue{{Database: "demo", IssueType: "IndexSmall", Target: "idx_small", Severity: utils.Medium}}, nil)
+	prod := utils.Issue{Cluster: "prod:5432", Database: "demo", IssueType: "IndexSmall", Target: "idx_small", Severity: utils.Medium}
+	staging := prod
+	staging.Cluster = "staging:5432"
+
+	// Baselines written before the cluster was recorded apply to every cluster
+	if _, suppressed := legacy.Suppress([]utils.Issue{prod, staging}, time.Now()); suppressed != 2 {
+		t.Fatalf("expected legacy baseline to suppress both issues, suppressed: %d", suppressed)
+	}
+
+	baseline := NewBaseline([]utils.Issue{prod}, nil)
+	kept, suppressed := baseline.Suppress([]utils.Issue{prod, staging}, time.Now())
+	if suppressed != 1 || len(kept) != 1 || kept[0].Cluster != "staging:5432" {
+		t.Fatalf("expected only the staging issue to be reported, found %v (suppressed: %d)", kept, suppressed)
+	}
+}
+
+func TestBaselineSchema(t *testing.T) {
+	legacy := NewBaseline([]utils.Issue{{Database: "demo", IssueType: "IndexSmall", Target: "idx_small", Severity: utils.Medium}}, nil)
+	public := utils.Issue{Cluster: "prod:5432", Database: "demo", IssueType: "IndexSmall", Target: "public.idx_small", Severity: utils.Medium}
+	tenant := public
+	tenant.Target = "tenant_1.idx_small"
+
+	// Baselines written before targets were schema qualified only apply to the default schema
+	kept, suppressed := legacy.Suppress([]utils.Issue{public, tenant}, time.Now())
+	if suppressed != 1 || len(kept) != 1 || kept[0].Target != "tenant_1.idx_small" {
+		t.Fatalf("expected only the tenant issue to be reported, found %v (suppressed: %d)", kept, suppressed)
+	}
+}
@@ -0,0 +1,292 @@
+package report
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"pgmaven/internal/dbutils"
+	"pgmaven/internal/utils"
+)
+
+// The order in which remediations should be applied - Duplicate, then Overlapping, then Unused indexes (see README).
+var remediationOrder = []string{
+	"IndexDuplicate", "IndexOverlapping", "IndexUnused", "IndexLowScansHighWrites", "IndexSeldomUsedLarge", "IndexSmall",
+	"IndexBloat", "TableBloat", "TableAnalyze",
+}
+
+var executablePrefixes = []string{"DROP INDEX ", "REINDEX ", "VACUUM ", "ANALYZE "}
+
+// Statement is an executable SQL statement extracted from the Solution of an Issue.
+type Statement struct {
+	Issue    utils.Issue
+	SQL      string
+	Note     string
+	Rollback string
+}
+
+// Statements returns the executable statements from the Solution of the Issue, DROP INDEX is always performed CONCURRENTLY.
+func Statements(issue utils.Issue) []Statement {
+	ret := make([]Statement, 0)
+
+	for _, line := range strings.Split(issue.Solution, "\n") {
+		line = strings.TrimSpace(line)
+		note := ""
+		if i := strings.Index(line, " -- "); i != -1 {
+			note = strings.TrimSpace(line[i+4:])
+			line = strings.TrimSpace(line[:i])
+		}
+		if !isExecutable(line) {
+			continue
+		}
+		if strings.HasPrefix(line, "DROP INDEX ") && !strings.HasPrefix(line, "DROP INDEX CONCURRENTLY ") {
+			line = "DROP INDEX CONCURRENTLY " + line[len("DROP INDEX "):]
+		}
+		ret = append(ret, Statement{Issue: issue, SQL: strings.TrimSuffix(line, ";"), Note: note})
+	}
+
+	return ret
+}
+
+func isExecutable(line string) bool {
+	for _, prefix := range executablePrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func remediationRank(issueType string) int {
+	for i, t := range remediationOrder {
+		if t == issueType {
+			return i
+		}
+	}
+
+	return len(remediationOrder)
+}
+
+// SortStatements orders the statements as they should be applied.
+func SortStatements(statements []Statement) {
+	sort.SliceStable(statements, func(i, j int) bool {
+		return remediationRank(statements[i].Issue.IssueType) < remediationRank(statements[j].Issue.IssueType)
+	})
+}
+
+// IsDropIndex returns true if the statement drops an index (and hence can be rolled back by recreating it).
+func (s *Statement) IsDropIndex() bool {
+	return strings.HasPrefix(s.SQL, "DROP INDEX ")
+}
+
+// Concurrently converts an index definition (as returned by pg_get_indexdef) to be created CONCURRENTLY.
+func Concurrently(definition string) string {
+	if i := strings.Index(definition, "INDEX "); i != -1 && !strings.Contains(definition, " CONCURRENTLY ") {
+		return definition[:i] + "INDEX CONCURRENTLY " + definition[i+len("INDEX "):]
+	}
+
+	return definition
+}
+
+// Remediation collects the executable solutions across all databases in order to generate a change script and the corresponding rollback script.
+type Remediation struct {
+	lockTimeout time.Duration
+	aggregate   bool
+	databases   []remediationDatabase
+	statements  map[remediationDatabase][]Statement
+	mutex       sync.Mutex
+}
+
+// remediationDatabase identifies the database the statements are executed against.
+type remediationDatabase struct {
+	cluster string
+	dbName  string
+	host    string
+	port    int
+}
+
+// connect returns the psql command to connect to the database, the server is only specified if the script spans clusters.
+func (d remediationDatabase) connect(multipleClusters bool) string {
+	if multipleClusters {
+		return fmt.Sprintf("\\connect %s - %s %d", d.dbName, d.host, d.port)
+	}
+
+	return "\\connect " + d.dbName
+}
+
+// NewRemediation returns an empty Remediation, if aggregate is set statements that differ only by schema (e.g. for each tenant) are
+// written once and executed for each schema via psql's \gexec.
+func NewRemediation(lockTimeout time.Duration, aggregate bool) *Remediation {
+	return &Remediation{lockTimeout: lockTimeout, aggregate: aggregate, statements: make(map[remediationDatabase][]Statement)}
+}
+
+// statementGroup is a statement along with the schemas it is executed for, if there is more than one.
+type statementGroup struct {
+	Statement
+	schema  string
+	schemas []string
+}
+
+// group returns the statements grouped by those that differ only by schema (in the order of the first of each).
+func (r *Remediation) group(statements []Statement) []*statementGroup {
+	ret := make([]*statementGroup, 0, len(statements))
+	groups := make(map[string]*statementGroup)
+	for _, statement := range statements {
+		schema, object := utils.SplitQualifiedName(statement.Issue.Target)
+		template, ok := Template(statement.SQL, schema)
+		rollback, rollbackOK := Template(statement.Rollback, schema)
+		// A rollback that does not reference the schema (e.g. the table is in the search_path) cannot be executed for every schema
+		if r.aggregate && ok && (rollbackOK || statement.Rollback == "") {
+			note := replaceQualifier(statement.Note, utils.QuoteIdentifier(schema)+".", "<schema>.")
+			key := strings.Join([]string{statement.Issue.IssueType, object, template, rollback, note}, "\x00")
+			if existing, found := groups[key]; found {
+				existing.schemas = append(existing.schemas, schema)
+				continue
+			}
+			groups[key] = &statementGroup{Statement: statement, schema: schema, schemas: []string{schema}}
+			ret = append(ret, groups[key])
+			continue
+		}
+		ret = append(ret, &statementGroup{Statement: statement})
+	}
+
+	return ret
+}
+
+// target returns the comment identifying the statement in the scripts.
+func (g *statementGroup) target() string {
+	if len(g.schemas) < 2 {
+		return fmt.Sprintf("%s: %s", g.Issue.IssueType, g.Issue.Target)
+	}
+	_, object := utils.SplitQualifiedName(g.Issue.Target)
+
+	return fmt.Sprintf("%s: %s (%d schemas)", g.Issue.IssueType, object, len(g.schemas))
+}
+
+// statement returns the SQL (or that for the rollback), executed for every schema in the group.
+func (g *statementGroup) statement(sql string) string {
+	if len(g.schemas) < 2 {
+		return sql + ";"
+	}
+	sort.Strings(g.schemas)
+
+	return GexecStatement(sql, g.schema, g.schemas)
+}
+
+// note returns the note, with the schema replaced if the statement is executed for every schema in the group.
+func (g *statementGroup) note() string {
+	note := strings.TrimPrefix(g.Note, "NOTE: ")
+	if len(g.schemas) < 2 {
+		return note
+	}
+
+	return replaceQualifier(note, utils.QuoteIdentifier(g.schema)+".", "<schema>.")
+}
+
+// Add records the executable statements for the Issues, the DataSource is used to capture the definition of any index to be dropped.
+func (r *Remediation) Add(ds *dbutils.DataSource, issues []utils.Issue) {
+	database := remediationDatabase{cluster: ds.GetCluster(), dbName: ds.GetDBName(), host: ds.GetHost(), port: ds.GetPort()}
+	added := make([]Statement, 0)
+	for _, issue := range issues {
+		for _, statement := range Statements(issue) {
+			if statement.IsDropIndex() {
+				if definition := ds.IndexDefinition(issue.Target); definition != "" {
+					statement.Rollback = Concurrently(definition)
+				}
+			}
+			added = append(added, statement)
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	existing, ok := r.statements[database]
+	if !ok {
+		r.databases = append(r.databases, database)
+	}
+
+	seen := make(map[string]bool)
+	for _, statement := range existing {
+		seen[statement.SQL] = true
+	}
+	for _, statement := range added {
+		if !seen[statement.SQL] {
+			seen[statement.SQL] = true
+			existing = append(existing, statement)
+		}
+	}
+
+	SortStatements(existing)
+	r.statements[database] = existing
+}
+
+// RollbackFile returns the name of the rollback script corresponding to the remediation script.
+func RollbackFile(file string) string {
+	return strings.TrimSuffix(file, ".sql") + "_rollback.sql"
+}
+
+func scriptHeader(file string) string {
+	return fmt.Sprintf("-- Generated by pgmaven %s at %s\n-- Review CAREFULLY before executing, e.g. psql -f %s\n"+
+		"-- Statements must NOT be executed inside a transaction block (CONCURRENTLY and VACUUM do not support this)\n",
+		utils.GetVersionString(), time.Now().UTC().Format(time.RFC3339), file)
+}
+
+// Write outputs the remediation script to the file specified and the rollback script to the corresponding rollback file.
+func (r *Remediation) Write(file string) error {
+	var script, rollback strings.Builder
+
+	script.WriteString(scriptHeader(file))
+	rollback.WriteString(scriptHeader(RollbackFile(file)))
+
+	clusters := make(map[string]bool)
+	for _, database := range r.databases {
+		clusters[database.cluster] = true
+	}
+
+	for _, database := range r.databases {
+		statements := r.statements[database]
+		if len(statements) == 0 {
+			continue
+		}
+
+		groups := r.group(statements)
+		preamble := fmt.Sprintf("\n%s\nSET lock_timeout = '%dms';\n", database.connect(len(clusters) > 1), r.lockTimeout.Milliseconds())
+		script.WriteString(preamble)
+		for _, group := range groups {
+			fmt.Fprintf(&script, "\n-- %s\n", group.target())
+			if group.Note != "" {
+				fmt.Fprintf(&script, "-- NOTE: %s\n", group.note())
+			}
+			fmt.Fprintf(&script, "%s\n", group.statement(group.SQL))
+		}
+
+		// Indexes are recreated in the reverse order to which they were dropped
+		rollback.WriteString(preamble)
+		for i := len(groups) - 1; i >= 0; i-- {
+			group := groups[i]
+			if !group.IsDropIndex() {
+				continue
+			}
+			fmt.Fprintf(&rollback, "\n-- %s\n", group.target())
+			if group.Rollback == "" {
+				fmt.Fprintf(&rollback, "-- WARNING: definition of index '%s' not available\n", group.Issue.Target)
+				continue
+			}
+			fmt.Fprintf(&rollback, "%s\n", group.statement(group.Rollback))
+		}
+	}
+
+	if err := os.WriteFile(file, []byte(script.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write remediation script '%s', error: %v", file, err)
+	}
+	if err := os.WriteFile(RollbackFile(file), []byte(rollback.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write rollback script '%s', error: %v", RollbackFile(file), err)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package report
+
+import (
+	"testing"
+
+	"pgmaven/internal/utils"
+)
+
+func TestStatements(t *testing.T) {
+	overlapping := utils.Issue{IssueType: "IndexOverlapping", Target: "idx_a",
+		Solution: "DROP INDEX \"idx_a\" -- NOTE: replacement is lightly utilized and significantly larger, consider dropping \"idx_ab\" instead\n"}
+	statements := Statements(overlapping)
+	if len(statements) != 1 {
+		t.Fatalf("expected 1 statement, found %d", len(statements))
+	}
+	if statements[0].SQL != "DROP INDEX CONCURRENTLY \"idx_a\"" {
+		t.Fatalf("unexpected SQL '%s'", statements[0].SQL)
+	}
+	if statements[0].Note == "" || !statements[0].IsDropIndex() {
+		t.Fatalf("expected a NOTE on a DROP INDEX statement, found %v", statements[0])
+	}
+
+	for _, solution := range []string{"-- Consider adding an index to \"orders\"\n", "REVIEW table - is it active?\n", "NONE proposed\n", "Update postgresql.conf - 'work_mem = 64MB'\n"} {
+		if found := Statements(utils.Issue{Solution: solution}); len(found) != 0 {
+			t.Fatalf("expected no statements for '%s', found %v", solution, found)
+		}
+	}
+}
+
+func TestSortStatements(t *testing.T) {
+	statements := []Statement{
+		{Issue: utils.Issue{IssueType: "TableBloat"}},
+		{Issue: utils.Issue{IssueType: "IndexUnused"}},
+		{Issue: utils.Issue{IssueType: "IndexOverlapping"}},
+		{Issue: utils.Issue{IssueType: "IndexDuplicate"}},
+	}
+	SortStatements(statements)
+
+	expected := []string{"IndexDuplicate", "IndexOverlapping", "IndexUnused", "TableBloat"}
+	for i, statement := range statements {
+		if statement.Issue.IssueType != expected[i] {
+			t.Fatalf("expected %s at %d, found %s", expected[i], i, statement.Issue.IssueType)
+		}
+	}
+}
+
+func TestConcurrently(t *testing.T) {
+	definition := Concurrently("CREATE UNIQUE INDEX silly_key ON bookings.boarding_passes USING btree (ticket_no, flight_id)")
+	if definition != "CREATE UNIQUE INDEX CONCURRENTLY silly_key ON bookings.boarding_passes USING btree (ticket_no, flight_id)" {
+		t.Fatalf("unexpected definition '%s'", definition)
+	}
+}
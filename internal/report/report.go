@@ -0,0 +1,207 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"pgmaven/internal/utils"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Result captures the output of a single Detector run against a single Database.
+type Result struct {
+	Cluster    string        `json:"cluster,omitempty"`
+	Database   string        `json:"database"`
+	Detector   string        `json:"detector"`
+	DurationMS int64         `json:"durationMS"`
+	Issues     []utils.Issue `json:"issues"`
+	Aggregates []Aggregate   `json:"aggregates,omitempty"` // issues grouped across schemas (--aggregate-schemas)
+}
+
+// Summary provides the counts of issues (by severity) reported, as well as those filtered out.
+type Summary struct {
+	Issues       int            `json:"issues"`
+	BySeverity   map[string]int `json:"bySeverity"`
+	BelowMinimum int            `json:"belowMinimumSeverity"`
+	Suppressed   int            `json:"suppressed"`
+	Errors       int            `json:"errors"`
+}
+
+// DatabaseStatus records the outcome of processing a single database.
+type DatabaseStatus struct {
+	Cluster    string `json:"cluster,omitempty"`
+	Database   string `json:"database"`
+	OK         bool   `json:"ok"`
+	Issues     int    `json:"issues"`
+	Errors     int    `json:"errors"`
+	DurationMS int64  `json:"durationMS"`
+}
+
+// Report is the collection of all Results for a run of pgmaven (across all databases).
+// All methods are safe to use from multiple goroutines.
+type Report struct {
+	Version   string           `json:"version"`
+	Generated time.Time        `json:"generated"`
+	Summary   Summary          `json:"summary"`
+	Databases []DatabaseStatus `json:"databases,omitempty"`
+	Results   []Result         `json:"results"`
+	mutex     sync.Mutex
+}
+
+func NewReport() *Report {
+	bySeverity := map[string]int{utils.High.String(): 0, utils.Medium.String(): 0, utils.Low.String(): 0}
+	return &Report{Version: utils.GetVersionString(), Generated: time.Now().UTC(), Summary: Summary{BySeverity: bySeverity}, Results: make([]Result, 0)}
+}
+
+// IsValidFormat returns true if the format is one of the supported output formats.
+func IsValidFormat(format string) bool {
+	return format == FormatText || format == FormatJSON
+}
+
+func (r *Report) Add(result Result) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if result.Issues == nil {
+		result.Issues = make([]utils.Issue, 0)
+	}
+	for _, issue := range result.Issues {
+		r.Summary.Issues++
+		r.Summary.BySeverity[issue.Severity.String()]++
+	}
+	for _, aggregate := range result.Aggregates {
+		for _, tenant := range aggregate.Tenants {
+			r.Summary.Issues++
+			r.Summary.BySeverity[tenant.Severity.String()]++
+		}
+	}
+	r.Results = append(r.Results, result)
+}
+
+// AddFiltered records the number of issues discarded as below the minimum severity.
+func (r *Report) AddFiltered(count int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Summary.BelowMinimum += count
+}
+
+// AddSuppressed records the number of issues suppressed by the baseline.
+func (r *Report) AddSuppressed(count int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Summary.Suppressed += count
+}
+
+// AddErrors records the number of errors (e.g. connection failures) encountered.
+func (r *Report) AddErrors(count int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Summary.Errors += count
+}
+
+// AddDatabase records the outcome of processing a database.
+func (r *Report) AddDatabase(status DatabaseStatus) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Databases = append(r.Databases, status)
+}
+
+// WriteDatabaseSummary outputs a line per database processed with the status, issue count and duration.
+func (r *Report) WriteDatabaseSummary(w io.Writer) {
+	r.sort()
+
+	fmt.Fprintln(w, "cluster,database,status,issues,errors,duration")
+	for _, status := range r.Databases {
+		outcome := "ok"
+		if !status.OK {
+			outcome = "failed"
+		}
+		fmt.Fprintf(w, "%s,%s,%s,%d,%d,%v\n", status.Cluster, status.Database, outcome, status.Issues, status.Errors, time.Duration(status.DurationMS)*time.Millisecond)
+	}
+}
+
+// sort orders the Results and Databases by Cluster and Database, so output is consistent regardless of processing order.
+func (r *Report) sort() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	sort.SliceStable(r.Results, func(i, j int) bool {
+		return r.Results[i].Cluster+"\x00"+r.Results[i].Database < r.Results[j].Cluster+"\x00"+r.Results[j].Database
+	})
+	sort.SliceStable(r.Databases, func(i, j int) bool {
+		return r.Databases[i].Cluster+"\x00"+r.Databases[i].Database < r.Databases[j].Cluster+"\x00"+r.Databases[j].Database
+	})
+}
+
+// CountAtLeast returns the number of issues reported with a severity at or above the threshold.
+func (r *Report) CountAtLeast(threshold utils.IssueSeverity) int {
+	count := 0
+	for _, result := range r.Results {
+		for _, issue := range result.Issues {
+			if issue.Severity.AtLeast(threshold) {
+				count++
+			}
+		}
+		for _, aggregate := range result.Aggregates {
+			count += aggregate.count(threshold)
+		}
+	}
+
+	return count
+}
+
+// SummaryLine returns a one line description of the issues reported.
+func (r *Report) SummaryLine() string {
+	return fmt.Sprintf("Issues: %d (HIGH: %d, MEDIUM: %d, LOW: %d), Below minimum severity: %d, Suppressed: %d, Errors: %d\n",
+		r.Summary.Issues, r.Summary.BySeverity[utils.High.String()], r.Summary.BySeverity[utils.Medium.String()], r.Summary.BySeverity[utils.Low.String()],
+		r.Summary.BelowMinimum, r.Summary.Suppressed, r.Summary.Errors)
+}
+
+// FilterSeverity returns the issues with a severity at or above the minimum, along with a count of those discarded.
+func FilterSeverity(issues []utils.Issue, minimum utils.IssueSeverity) ([]utils.Issue, int) {
+	ret := make([]utils.Issue, 0, len(issues))
+	for _, issue := range issues {
+		if issue.Severity.AtLeast(minimum) {
+			ret = append(ret, issue)
+		}
+	}
+
+	return ret, len(issues) - len(ret)
+}
+
+// WriteJSON outputs the entire Report as a single JSON document.
+func (r *Report) WriteJSON(w io.Writer) error {
+	r.sort()
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(r); err != nil {
+		return fmt.Errorf("failed to encode report, error: %v", err)
+	}
+
+	return nil
+}
+
+// LoadReport reads a Report previously written using WriteJSON.
+func LoadReport(file string) (*Report, error) {
+	buffer, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report '%s', error: %v", file, err)
+	}
+
+	r := new(Report)
+	if err := json.Unmarshal(buffer, r); err != nil {
+		return nil, fmt.Errorf("failed to parse report '%s', error: %v", file, err)
+	}
+
+	return r, nil
+}
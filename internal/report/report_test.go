@@ -0,0 +1,60 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"pgmaven/internal/utils"
+)
+
+func TestWriteJSON(t *testing.T) {
+	rep := NewReport()
+	rep.Add(newResult("demo", "IndexIssues", utils.Issue{Database: "demo", IssueType: "IndexUnused", Target: "idx_a", Severity: utils.High}))
+	rep.Add(Result{Database: "demo", Detector: "TableIssues"})
+
+	var buffer bytes.Buffer
+	if err := rep.WriteJSON(&buffer); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var decoded map[string]any
+	if err := json.Unmarshal(buffer.Bytes(), &decoded); err != nil {
+		t.Fatalf("output is not valid JSON, error: %v", err)
+	}
+
+	results := decoded["results"].([]any)
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, found %d", len(results))
+	}
+	issue := results[0].(map[string]any)["issues"].([]any)[0].(map[string]any)
+	if issue["severity"] != "HIGH" {
+		t.Fatalf("expected HIGH, found %v", issue["severity"])
+	}
+	if empty := results[1].(map[string]any)["issues"].([]any); len(empty) != 0 {
+		t.Fatalf("expected no issues, found %d", len(empty))
+	}
+}
+
+func newResult(database string, detector string, issues ...utils.Issue) Result {
+	return Result{Database: database, Detector: detector, DurationMS: 10, Issues: issues}
+}
+
+func TestWriteDatabaseSummary(t *testing.T) {
+	rep := NewReport()
+	// Added in completion order (e.g. with --parallel), output is ordered by cluster and database
+	rep.AddDatabase(DatabaseStatus{Cluster: "prod", Database: "sales", OK: true, Issues: 3, DurationMS: 1500})
+	rep.AddDatabase(DatabaseStatus{Cluster: "prod", Database: "billing", OK: false, Errors: 1, DurationMS: 20})
+	rep.AddDatabase(DatabaseStatus{Cluster: "dev", Database: "sales", OK: true})
+
+	var buffer bytes.Buffer
+	rep.WriteDatabaseSummary(&buffer)
+	expected := `cluster,database,status,issues,errors,duration
+dev,sales,ok,0,0,0s
+prod,billing,failed,0,1,20ms
+prod,sales,ok,3,0,1.5s
+`
+	if buffer.String() != expected {
+		t.Fatalf("unexpected summary:\n%s\nexpected:\n%s", buffer.String(), expected)
+	}
+}
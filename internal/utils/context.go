@@ -1,10 +1,48 @@
 package utils
 
-import "time"
+import (
+	"io"
+	"os"
+	"time"
+)
 
 type Context struct {
-	DryRun         bool
-	Duration       time.Duration
-	DurationOffset time.Duration
-	Verbose        bool
+	ApplyAllow         []string
+	DownsampleAfter    time.Duration
+	DownsampleInterval time.Duration
+	DryRun             bool
+	Duration           time.Duration
+	DurationOffset     time.Duration
+	LockTimeout        time.Duration
+	MinHistory         time.Duration
+	Out                io.Writer
+	Retain             time.Duration
+	StatementTimeout   time.Duration
+	Verbose            bool
+}
+
+// Writer returns the destination for (non-error) output, this defaults to stdout.
+func (c Context) Writer() io.Writer {
+	if c.Out == nil {
+		return os.Stdout
+	}
+
+	return c.Out
+}
+
+// SetRetention sets the snapshot retention (e.g. 30d) and downsampling (e.g. 7d:daily) options, either may be blank.
+func (c *Context) SetRetention(retain string, downsample string) (err error) {
+	if retain != "" {
+		if c.Retain, err = ParseDuration(retain); err != nil {
+			return err
+		}
+	}
+
+	if downsample != "" {
+		if c.DownsampleAfter, c.DownsampleInterval, err = ParseDownsample(downsample); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
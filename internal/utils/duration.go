@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	Day  = 24 * time.Hour
+	Week = 7 * Day
+)
+
+var downsampleIntervals = map[string]time.Duration{
+	"hourly": time.Hour,
+	"daily":  Day,
+	"weekly": Week,
+}
+
+// ParseDuration extends time.ParseDuration to support days and weeks (e.g. 30d, 2w).
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	for suffix, unit := range map[string]time.Duration{"d": Day, "w": Week} {
+		if strings.HasSuffix(s, suffix) {
+			count, err := strconv.ParseFloat(strings.TrimSuffix(s, suffix), 64)
+			if err != nil || count < 0 {
+				return 0, fmt.Errorf("invalid duration '%s'", s)
+			}
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+
+	ret, err := time.ParseDuration(s)
+	if err != nil || ret < 0 {
+		return 0, fmt.Errorf("invalid duration '%s'", s)
+	}
+
+	return ret, nil
+}
+
+// ParseDownsample parses a downsampling specification of the form <after>:<interval>, e.g. 7d:daily.
+// The interval is one of hourly, daily or weekly (or a duration).
+func ParseDownsample(s string) (after time.Duration, interval time.Duration, err error) {
+	afterSpec, intervalSpec, found := strings.Cut(s, ":")
+	if !found {
+		return 0, 0, fmt.Errorf("invalid downsample '%s', expected <after>:<interval> (e.g. 7d:daily)", s)
+	}
+
+	if after, err = ParseDuration(afterSpec); err != nil {
+		return 0, 0, err
+	}
+
+	interval, ok := downsampleIntervals[strings.ToLower(intervalSpec)]
+	if !ok {
+		if interval, err = ParseDuration(intervalSpec); err != nil || interval == 0 {
+			return 0, 0, fmt.Errorf("invalid downsample interval '%s', should be hourly, daily, weekly or a duration", intervalSpec)
+		}
+	}
+
+	return after, interval, nil
+}
@@ -1,7 +1,13 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 )
 
 type IssueSeverity int32
@@ -10,29 +16,93 @@ func (i IssueSeverity) String() string {
 	return [...]string{"HIGH", "MEDIUM", "LOW"}[i]
 }
 
+func (i IssueSeverity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(i.String())
+}
+
+func (i *IssueSeverity) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+
+	severity, err := ParseSeverity(name)
+	if err != nil {
+		return err
+	}
+	*i = severity
+
+	return nil
+}
+
 const (
 	High IssueSeverity = iota
 	Medium
 	Low
 )
 
+// ParseSeverity returns the IssueSeverity corresponding to the (case-insensitive) name provided.
+func ParseSeverity(name string) (IssueSeverity, error) {
+	switch strings.ToUpper(name) {
+	case "HIGH":
+		return High, nil
+	case "MEDIUM":
+		return Medium, nil
+	case "LOW":
+		return Low, nil
+	}
+
+	return Low, fmt.Errorf("severity '%s' not recognized, should be one of HIGH, MEDIUM, or LOW", name)
+}
+
+// AtLeast returns true if the severity is as severe or more severe than the threshold.
+func (i IssueSeverity) AtLeast(threshold IssueSeverity) bool {
+	return i <= threshold
+}
+
 type Issue struct {
-	IssueType string
-	Target    string
-	Severity  IssueSeverity
-	Detail    string
-	Solution  string
+	Cluster     string        `json:"cluster,omitempty"`
+	Database    string        `json:"database"`
+	IssueType   string        `json:"issueType"`
+	Target      string        `json:"target"`
+	Severity    IssueSeverity `json:"severity"`
+	Detail      string        `json:"detail"`
+	Solution    string        `json:"solution"`
+	WastedBytes int64         `json:"wastedBytes,omitempty"` // (estimated) space reclaimed by the Solution, e.g. the size of an index to be dropped
+}
+
+// Fingerprint returns a stable identifier for the Issue - based on the Cluster (if known), Database, IssueType and Target.
+func (i *Issue) Fingerprint() string {
+	key := i.Database + "\x00" + i.IssueType + "\x00" + i.Target
+	if i.Cluster != "" {
+		key = i.Cluster + "\x00" + key
+	}
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:8])
 }
 
 func (i *Issue) Dump() {
-	fmt.Printf("ISSUE: %s\n", i.IssueType)
-	fmt.Printf("SEVERITY: %s\n", i.Severity)
-	fmt.Printf("TARGET: %s\n", i.Target)
-	fmt.Printf("DETAIL:\n%s", indent(i.Detail))
-	fmt.Printf("SUGGESTION:\n%s", indent(i.Solution))
+	i.DumpTo(os.Stdout)
+}
+
+// DumpTo outputs the Issue in a human-readable form to the supplied Writer.
+func (i *Issue) DumpTo(w io.Writer) {
+	fmt.Fprintf(w, "ISSUE: %s\n", i.IssueType)
+	fmt.Fprintf(w, "SEVERITY: %s\n", i.Severity)
+	if i.Cluster != "" {
+		fmt.Fprintf(w, "CLUSTER: %s\n", i.Cluster)
+	}
+	if i.Database != "" {
+		fmt.Fprintf(w, "DATABASE: %s\n", i.Database)
+	}
+	fmt.Fprintf(w, "TARGET: %s\n", i.Target)
+	fmt.Fprintf(w, "DETAIL:\n%s", Indent(i.Detail))
+	fmt.Fprintf(w, "SUGGESTION:\n%s", Indent(i.Solution))
 }
 
-func indent(s string) (ret string) {
+// Indent returns the (multi-line) text with each line indented by a tab.
+func Indent(s string) (ret string) {
 	ret = "\t"
 	for i, c := range s {
 		ret += string(c)
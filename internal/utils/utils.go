@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strconv"
@@ -52,6 +53,60 @@ func QuoteIfNeeded(s string) string {
 	return "\"" + s + "\""
 }
 
+// QuoteIdentifier returns the SQL identifier, quoted if it is not a simple lower case name.
+func QuoteIdentifier(s string) string {
+	if s == "" {
+		return QuoteAlways(s)
+	}
+	for i, c := range s {
+		if (c < 'a' || c > 'z') && c != '_' && (i == 0 || ((c < '0' || c > '9') && c != '$')) {
+			return QuoteAlways(s)
+		}
+	}
+
+	return s
+}
+
+// QualifiedName returns the schema qualified name of the object (e.g. table or index), suitable for use in SQL.
+func QualifiedName(schema string, name string) string {
+	return QuoteIdentifier(schema) + "." + QuoteIdentifier(name)
+}
+
+// SplitQualifiedName is the inverse of QualifiedName, the schema is "" if the name is not qualified.
+func SplitQualifiedName(s string) (schema string, name string) {
+	if !strings.HasPrefix(s, "\"") {
+		if dot := strings.Index(s, "."); dot != -1 {
+			return unquoteIdentifier(s[:dot]), unquoteIdentifier(s[dot+1:])
+		}
+		return "", unquoteIdentifier(s)
+	}
+
+	// Find the closing quote of the schema, doubled quotes are escaped quotes
+	for i := 1; i < len(s); i++ {
+		if s[i] != '"' {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '"' {
+			i++
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '.' {
+			return unquoteIdentifier(s[:i+1]), unquoteIdentifier(s[i+2:])
+		}
+		break
+	}
+
+	return "", unquoteIdentifier(s)
+}
+
+func unquoteIdentifier(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	return strings.ReplaceAll(s[1:len(s)-1], "\"\"", "\"")
+}
+
 var shorthand = [...]string{
 	"1", "2", "4", "8", "16", "32", "64", "128", "256", "512",
 	"1kB", "2kB", "4kB", "8kB", "16kB", "32kB", "64kB", "128kB", "256kB", "512kB",
@@ -135,3 +190,20 @@ func MSB(v int64) (r int) {
 
 	return
 }
+
+// ParseSize parses a size in bytes, optionally with units (e.g. 512MB, 64GB, 1TB).
+func ParseSize(s string) (int64, error) {
+	units := map[string]int64{"": 1, "B": 1, "KB": SIZE_KB, "K": SIZE_KB, "MB": SIZE_MB, "M": SIZE_MB, "GB": SIZE_GB, "G": SIZE_GB, "TB": 1024 * SIZE_GB, "T": 1024 * SIZE_GB}
+	trimmed := strings.TrimSpace(s)
+	i := strings.IndexFunc(trimmed, func(c rune) bool { return (c < '0' || c > '9') && c != '.' })
+	if i == -1 {
+		i = len(trimmed)
+	}
+	value, err := strconv.ParseFloat(trimmed[:i], 64)
+	multiplier, ok := units[strings.ToUpper(strings.TrimSpace(trimmed[i:]))]
+	if err != nil || !ok || value < 0 {
+		return 0, fmt.Errorf("invalid size '%s'", s)
+	}
+
+	return int64(value * float64(multiplier)), nil
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"testing"
+	"time"
 )
 
 func TestMSB(t *testing.T) {
@@ -131,3 +132,85 @@ func TestClear(t *testing.T) {
 	}
 
 }
+
+func TestSeverity(t *testing.T) {
+	severity, err := ParseSeverity("medium")
+	if err != nil || severity != Medium {
+		t.Fatalf("expected MEDIUM, found %s (err: %v)", severity, err)
+	}
+
+	_, err = ParseSeverity("CRITICAL")
+	if err == nil {
+		t.Fatalf("expected error for unknown severity")
+	}
+
+	if !High.AtLeast(Medium) || !Medium.AtLeast(Medium) || Low.AtLeast(Medium) {
+		t.Fatalf("AtLeast ordering incorrect")
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{"30d": 30 * Day, "2w": 2 * Week, "12h": 12 * time.Hour, "0.5d": 12 * time.Hour}
+	for input, expected := range tests {
+		actual, err := ParseDuration(input)
+		if err != nil || actual != expected {
+			t.Fatalf("ParseDuration(%s) should be %v - found %v (%v)", input, expected, actual, err)
+		}
+	}
+
+	if _, err := ParseDuration("xd"); err == nil {
+		t.Fatalf("ParseDuration(xd) should fail")
+	}
+
+	after, interval, err := ParseDownsample("7d:daily")
+	if err != nil || after != Week || interval != Day {
+		t.Fatalf("ParseDownsample(7d:daily) - found %v, %v (%v)", after, interval, err)
+	}
+
+	if _, _, err := ParseDownsample("7d"); err == nil {
+		t.Fatalf("ParseDownsample(7d) should fail")
+	}
+}
+
+func TestQualifiedName(t *testing.T) {
+	tests := []struct {
+		schema   string
+		name     string
+		expected string
+	}{
+		{"public", "orders_idx", "public.orders_idx"},
+		{"tenant_1", "Orders", "tenant_1.\"Orders\""},
+		{"Sales.EU", "line\"item", "\"Sales.EU\".\"line\"\"item\""},
+		{"public", "1st", "public.\"1st\""},
+	}
+
+	for _, test := range tests {
+		actual := QualifiedName(test.schema, test.name)
+		if actual != test.expected {
+			t.Errorf("QualifiedName(%s, %s): expected %s, got %s", test.schema, test.name, test.expected, actual)
+		}
+		if schema, name := SplitQualifiedName(actual); schema != test.schema || name != test.name {
+			t.Errorf("SplitQualifiedName(%s): got (%s, %s)", actual, schema, name)
+		}
+	}
+
+	if schema, name := SplitQualifiedName("orders_idx"); schema != "" || name != "orders_idx" {
+		t.Errorf("SplitQualifiedName(orders_idx): got (%s, %s)", schema, name)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{"64GB": 64 * SIZE_GB, "512mb": 512 * SIZE_MB, "1.5G": 3 * SIZE_GB / 2, "8192": 8192, "1 TB": 1024 * SIZE_GB}
+	for input, expected := range tests {
+		actual, err := ParseSize(input)
+		if err != nil || actual != expected {
+			t.Fatalf("ParseSize(%s) should be %d - found %d (%v)", input, expected, actual, err)
+		}
+	}
+
+	for _, input := range []string{"", "GB", "64XB", "-1GB"} {
+		if _, err := ParseSize(input); err == nil {
+			t.Fatalf("ParseSize(%s) should fail", input)
+		}
+	}
+}